@@ -0,0 +1,325 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The [api] section of the config file protects the daemon's network APIs
+// (-http, -prusalink, -moonraker and -grpc): the key clients must send, and
+// the certificate to serve them over TLS with, if any. [api keys] names more
+// keys, each with its role:
+//
+//	[api keys]
+//	wall = monitor 5d41402abc4b2a76
+//	slicer = control 7d793037a0760186
+var api struct {
+	keys map[string]apiKey // by the key itself
+	tls  *tls.Config
+	rate int // control requests a client may make a minute, or 0 for any
+
+	mu      sync.Mutex
+	buckets map[string]*apiBucket // by client: key name and host
+}
+
+// A client may make up to api_burst control requests at once, and after that
+// only as many as [api] rate allows, so that one gone haywire can't tie up
+// the printer's serial link with them.
+const api_burst = 5
+
+// apiBucket is a client's token bucket: a control request takes a token,
+// and they come back at [api] rate.
+type apiBucket struct {
+	tokens float64
+	at     time.Time
+}
+
+var errTooOften = errors.New("too many control requests; try again later")
+
+// apiKey is who sent a key, and what they may do with it.
+type apiKey struct {
+	name    string
+	control bool // start, pause and cancel jobs and send GCode, or only watch
+}
+
+// anyone is who requests are from when there are no keys, on localhost. They
+// may only watch: any web page open in a browser there can make requests.
+var anyone = apiKey{name: "localhost"}
+
+// checkAPI reads the config file's sections for the daemon's API flags
+// before any printer is connected, lest a typo in them stop the daemon only
+// once opening the ports has reset the boards.
+func checkAPI() error {
+	if moonraker_addr == "" && grpc_addr == "" && http_addr == "" && prusalink_addr == "" {
+		return nil
+	}
+	if err := readAPIConfig(); err != nil {
+		return err
+	}
+	for _, fl := range []struct{ name, addr string }{
+		{"-http", http_addr},
+		{"-grpc", grpc_addr},
+		{"-prusalink", prusalink_addr},
+	} {
+		if fl.addr != "" {
+			_, err := newHTTPAPI(nil, fl.name)
+			return err
+		}
+	}
+	return nil
+}
+
+// readAPIConfig reads the [api] and [api keys] sections. TLS is off, unless
+// cert and cert_key name a certificate and its private key, or tls =
+// self-signed makes one up.
+func readAPIConfig() error {
+	var err error
+	if api.rate, err = confInt("api", "rate", 30); err != nil {
+		return err
+	}
+	api.buckets = make(map[string]*apiBucket)
+	api.keys = make(map[string]apiKey)
+	if key := conf.get("api", "key"); key != "" {
+		api.keys[key] = apiKey{name: "api", control: true}
+	}
+	for name, val := range conf["api keys"] {
+		role, key, _ := strings.Cut(val, " ")
+		key = strings.TrimSpace(key)
+		if role != "control" && role != "monitor" || key == "" {
+			return fmt.Errorf("[api keys] %s = %s: want control or monitor, then the key", name, val)
+		}
+		if _, dup := api.keys[key]; dup {
+			return fmt.Errorf("[api keys] %s: the key is already given", name)
+		}
+		api.keys[key] = apiKey{name: name, control: role == "control"}
+	}
+
+	var cert tls.Certificate
+	switch mode := conf.get("api", "tls"); mode {
+	case "", "off":
+		path := conf.get("api", "cert")
+		if path == "" {
+			return nil
+		}
+		cert, err = tls.LoadX509KeyPair(path, conf.get("api", "cert_key"))
+	case "self-signed":
+		cert, err = selfSigned()
+	default:
+		return fmt.Errorf("[api] tls = %s: want off or self-signed", mode)
+	}
+	if err != nil {
+		return fmt.Errorf("[api]: %w", err)
+	}
+	// As openssl x509 -fingerprint -sha256 shows it, to check clients against.
+	sum := sha256.Sum256(cert.Certificate[0])
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	log.Print("TLS certificate SHA-256 fingerprint: ", strings.Join(hex, ":"))
+	api.tls = &tls.Config{Certificates: []tls.Certificate{cert}}
+	return nil
+}
+
+// loopback reports whether a listening address can only be reached from
+// this machine.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// listenAPI listens on the address a flag gives for an API. Without any
+// keys it only listens on this machine: anyone who could reach it could
+// otherwise watch the printer.
+func listenAPI(flag, addr string) (net.Listener, error) {
+	if len(api.keys) == 0 && !loopback(addr) {
+		return nil, fmt.Errorf("%s %s: no key in the [api] section of the config "+
+			"file, to serve beyond this machine (e.g. on localhost:%s without one)",
+			flag, addr, addr[strings.LastIndex(addr, ":")+1:])
+	}
+	return net.Listen("tcp", addr)
+}
+
+// serveAPI serves HTTP on an API's listener, over TLS if the [api] section
+// says so.
+func serveAPI(l net.Listener, h http.Handler) {
+	if api.tls != nil {
+		l = tls.NewListener(l, api.tls)
+	}
+	go http.Serve(l, h)
+}
+
+// lookupKey finds who sent a key, or reports that it isn't one of the keys.
+// Anyone may watch when there are none to send.
+func lookupKey(sent string) (apiKey, bool) {
+	if len(api.keys) == 0 {
+		return anyone, true
+	}
+	for key, k := range api.keys {
+		if subtle.ConstantTimeCompare([]byte(sent), []byte(key)) == 1 {
+			return k, true
+		}
+	}
+	return apiKey{}, false
+}
+
+// refusal says why a key can't be used to control the printer.
+func (k apiKey) refusal() string {
+	if k == anyone {
+		return "controlling the printer takes a key from the [api] section of the config file"
+	}
+	return k.name + "'s key can only monitor"
+}
+
+// requestKey finds who sent an HTTP request, by its X-Api-Key header.
+func requestKey(r *http.Request) (apiKey, bool) {
+	return lookupKey(r.Header.Get("X-Api-Key"))
+}
+
+// apiClient is who made a request to one of the APIs, and from where.
+type apiClient struct {
+	apiKey
+	addr string // host:port
+	via  string // the API: HTTP, PrusaLink, Moonraker or gRPC
+}
+
+// requestClient is who sent an HTTP request that has been let in.
+func requestClient(r *http.Request, via string) apiClient {
+	k, _ := requestKey(r)
+	return apiClient{k, r.RemoteAddr, via}
+}
+
+// admit lets a client's control request through, unless they have made too
+// many lately, and logs it on the printer's console, so the log file and
+// monitors have who did what, and when.
+func (c apiClient) admit(d *dripper, what string) error {
+	host, _, err := net.SplitHostPort(c.addr)
+	if err != nil {
+		host = c.addr
+	}
+	if !c.allowed(host) {
+		log.Printf("Too many control requests from %s@%s over %s: %s", c.name, host, c.via, what)
+		return errTooOften
+	}
+	d.con.Printf("-- API %s by %s@%s over %s at %s", what, c.name, host, c.via,
+		time.Now().Format("2006-01-02 15:04:05"))
+	return nil
+}
+
+// allowed takes a token from the client's bucket, if there is one.
+func (c apiClient) allowed(host string) bool {
+	if api.rate == 0 {
+		return true
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	now := time.Now()
+	// A bucket that has filled up again is as good as none, and dropping
+	// them keeps clients whose addresses keep changing from piling them up.
+	for k, b := range api.buckets {
+		if b.tokens+now.Sub(b.at).Minutes()*float64(api.rate) >= api_burst {
+			delete(api.buckets, k)
+		}
+	}
+	b := api.buckets[c.name+"@"+host]
+	if b == nil {
+		b = &apiBucket{tokens: api_burst, at: now}
+		api.buckets[c.name+"@"+host] = b
+	}
+	b.tokens += now.Sub(b.at).Minutes() * float64(api.rate)
+	if b.tokens > api_burst {
+		b.tokens = api_burst
+	}
+	b.at = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// selfSigned loads the certificate made up for tls = self-signed, or makes
+// one and saves it in the user's config directory, so clients that have
+// been told to trust it go on trusting it.
+func selfSigned() (tls.Certificate, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	dir = filepath.Join(dir, "dripp3r")
+	cert_path, key_path := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "cert-key.pem")
+	if cert, err := tls.LoadX509KeyPair(cert_path, key_path); err == nil {
+		return cert, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return tls.Certificate{}, err
+	}
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	host, _ := os.Hostname()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: host, Organization: []string{"dripp3r"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{host, "localhost"},
+	}
+	// Every address the machine has, for clients that go by IP.
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ipnet.IP)
+			}
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	key_der, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert_pem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	key_pem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key_der})
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.WriteFile(key_path, key_pem, 0600); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.WriteFile(cert_path, cert_pem, 0644); err != nil {
+		return tls.Certificate{}, err
+	}
+	log.Print("Made a self-signed certificate: ", cert_path)
+	return tls.X509KeyPair(cert_pem, key_pem)
+}
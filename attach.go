@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+)
+
+type attachChoice int
+
+const (
+	attachWatch attachChoice = iota
+	attachPause
+	attachResume
+	attachCancel
+	attachHackerMode
+	attachDetach
+)
+
+// ctlAttach is the interactive client for a daemon. It behaves like the
+// local console, but detaching (or losing the terminal) leaves the job
+// running in the daemon so it can be attached to again later.
+func ctlAttach(sock, printer string) error {
+	conn, err := ctlSession(sock, "attach")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	enc := json.NewEncoder(conn)
+	id := 0
+	call := func(method string, params ctlParams) error {
+		id++
+		params.Printer = printer
+		return enc.Encode(rpcRequest{
+			"2.0", json.RawMessage(fmt.Sprint(id)), method, params,
+		})
+	}
+
+	lost := make(chan error, 1)
+	go func() {
+		lost <- readSession(json.NewDecoder(conn), plainView{printer})
+	}()
+
+	user_input, input_err := userInput(os.Stdin)
+	sig_chan := make(chan os.Signal, 1)
+	signal.Notify(sig_chan, os.Interrupt)
+	defer signal.Reset(os.Interrupt)
+
+	fmt.Println("-- ATTACHED: Press Ctrl-C for the menu.")
+	var hack_mode bool
+	for {
+		var err error
+		select {
+		case line, ok := <-user_input:
+			if !ok {
+				user_input = nil
+			} else if hack_mode {
+				err = call("gcode", ctlParams{GCode: line})
+			} else {
+				err = call("key", ctlParams{Name: line})
+			}
+		case <-sig_chan:
+			// Drop SIGINT handler so ^C twice will exit.
+			signal.Reset(os.Interrupt)
+			hack_mode = false
+			setPrompt("")
+			switch attachMenu(user_input) {
+			case attachWatch:
+				fmt.Println("-- WATCH")
+			case attachPause:
+				err = call("pause", ctlParams{})
+			case attachResume:
+				err = call("resume", ctlParams{})
+			case attachCancel:
+				err = call("cancel", ctlParams{})
+			case attachHackerMode:
+				fmt.Println("-- HACKER MODE: Type Gcodes now.")
+				hack_mode = true
+				setPrompt("gcode> ")
+			case attachDetach:
+				fmt.Println("-- DETACHED: The daemon keeps printing.")
+				return nil
+			}
+			signal.Notify(sig_chan, os.Interrupt)
+		case err = <-lost:
+		case err = <-input_err:
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func attachMenu(userin <-chan string) attachChoice {
+	// discard buffered input
+	flushUserInput(userin)
+
+	for {
+		fmt.Print(`-- ATTACH MENU
+w) watch       (just show the console)
+p) pause       (pause the job)
+c) continue    (resume the job)
+s) stop job    (cancel, drip stop GCode)
+h) hacker mode (enter GCodes on keyboard)
+d) detach      (exit, the job keeps running)
+`)
+		ans, ok := <-userin
+		if !ok {
+			// Losing the terminal is as good as detaching.
+			return attachDetach
+		}
+		switch ans {
+		case "w":
+			return attachWatch
+		case "p":
+			return attachPause
+		case "c":
+			return attachResume
+		case "s":
+			return attachCancel
+		case "h":
+			return attachHackerMode
+		case "d":
+			return attachDetach
+		default:
+			fmt.Printf("invalid entry: %#v\n", ans)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Set by -host-m600: change filament here when the file says M600, for
+// firmware built without ADVANCED_PAUSE.
+var host_m600 bool
+
+// Where the head waits while the filament is changed, and how much filament
+// is pulled out and pushed in, in mm, unless the [change] section of the
+// config file says otherwise.
+const (
+	change_lift    = 10
+	change_park_x  = 0
+	change_park_y  = 0
+	change_unload  = 100
+	change_load    = 100
+	change_purge   = 10
+	change_fast    = 1200 // mm/min, for unloading
+	change_slow    = 300  // mm/min, for loading
+	change_purging = 150  // mm/min, for purging
+)
+
+// startChange pauses the job, parks the head out of the way and beeps, then
+// the keys unload and load the filament until the job carries on.
+func (d *dripper) startChange() (string, error) {
+	if !isPrinter() {
+		return "", errNotPrinter
+	}
+	lift, err := confFloat("change", "lift", change_lift)
+	if err != nil {
+		return "", err
+	}
+	x, err := confFloat("change", "park_x", change_park_x)
+	if err != nil {
+		return "", err
+	}
+	y, err := confFloat("change", "park_y", change_park_y)
+	if err != nil {
+		return "", err
+	}
+	d.changing = true
+	if d.gcode != nil {
+		d.paused = true
+	}
+	d.holdPosition()
+	d.emit(hookEvent{Event: "filament_change"})
+	d.moveBy(fmt.Sprintf("G1 Z%g F600", lift))
+	d.hack_queue = append(d.hack_queue, fmt.Sprintf("G1 X%g Y%g F3000", x, y),
+		"M300 S880 P500", "M105")
+	d.con.Println("-- FILAMENT CHANGE: u unloads the filament, l loads the " +
+		"new one, e purges a little more, c carries on.")
+	return "changing filament", nil
+}
+
+// changeKey handles a key while the filament is changed.
+func (d *dripper) changeKey(k string) (string, error) {
+	switch k {
+	case "u":
+		length, err := confFloat("change", "unload_length", change_unload)
+		if err != nil {
+			return "", err
+		}
+		return d.extrudeBy(-length, change_fast)
+	case "l":
+		length, err := confFloat("change", "load_length", change_load)
+		if err != nil {
+			return "", err
+		}
+		return d.extrudeBy(length, change_slow)
+	case "e":
+		return d.extrudeBy(change_purge, change_purging)
+	case "c", "q", "esc":
+		d.changing = false
+		d.con.Println("-- END FILAMENT CHANGE")
+		if d.paused {
+			d.con.Println("-- RESUME")
+			d.paused = false
+		}
+		d.returnToJob()
+		return "carrying on", nil
+	case "p", " ":
+		return "", errors.New("press c to carry on after changing the filament")
+	}
+	return "", fmt.Errorf("%w %q changing filament", errNoHotkey, k)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Line numbers and checksums can be turned off for firmware that chokes on
+// them.
+var no_checksum bool
+
+// How many sent lines are kept for the printer to ask for again.
+const resend_history = 64
+
+var (
+	line_number_re = regexp.MustCompile(`^N\d+\s*`)
+	checksum_re    = regexp.MustCompile(`\s*\*\d+$`)
+	resend_re      = regexp.MustCompile(`^(?:Resend|rs):?\s*N?(\d+)`)
+	advanced_ok_re = regexp.MustCompile(`^ok\b.*\sB(\d+)`)
+)
+
+// stripLineNumber removes the N-number and checksum a line was given by
+// whichever host sent it before, so they can be regenerated. A line without
+// an N-number keeps its end, as in "M117 5*2".
+func stripLineNumber(line []byte) []byte {
+	n := line_number_re.Find(line)
+	if n == nil {
+		return line
+	}
+	return checksum_re.ReplaceAll(line[len(n):], nil)
+}
+
+// numberLine gives a line its number and checksum: "N12 G28*32".
+func numberLine(n int, line []byte) []byte {
+	out := []byte(fmt.Sprintf("N%d %s", n, line))
+	var cs byte
+	for _, c := range out {
+		cs ^= c
+	}
+	return append(out, fmt.Sprintf("*%d", cs)...)
+}
+
+// parseM110 returns the line number an M110 sets the printer to.
+func parseM110(line []byte) (int, bool) {
+	f := bytes.Fields(line)
+	if len(f) == 0 || string(f[0]) != "M110" {
+		return 0, false
+	}
+	for _, arg := range f[1:] {
+		if arg[0] == 'N' {
+			n, err := strconv.Atoi(string(arg[1:]))
+			return n, err == nil
+		}
+	}
+	return 0, true
+}
+
+// parseAdvancedOK returns the free slots in Marlin's command buffer that an
+// ok tells of when it is built with ADVANCED_OK: 3 in "ok N12 P15 B3".
+func parseAdvancedOK(ln string) (int, bool) {
+	m := advanced_ok_re.FindStringSubmatch(ln)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m[1])
+	return n, err == nil
+}
+
+// parseResend returns the line number in a "Resend: 12" request.
+func parseResend(ln string) (int, bool) {
+	m := resend_re.FindStringSubmatch(ln)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m[1])
+	return n, err == nil
+}
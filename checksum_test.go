@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestStripLineNumber(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"N12 G28*32", "G28"},
+		{"N12 G1 X5 Y5 *103", "G1 X5 Y5"},
+		{"N7G28*30", "G28"},
+		{"G28", "G28"},
+		{"M117 5*2", "M117 5*2"},
+		{"M117 N12*3", "M117 N12*3"},
+		{"", ""},
+	} {
+		if got := string(stripLineNumber([]byte(tc.in))); got != tc.want {
+			t.Errorf("stripLineNumber(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestNumberLine(t *testing.T) {
+	for _, tc := range []struct {
+		n        int
+		in, want string
+	}{
+		{12, "G28", "N12 G28*32"},
+		{0, "M110", "N0 M110*35"},
+		{1, "M117 5*2", "N1 M117 5*2*40"},
+	} {
+		if got := string(numberLine(tc.n, []byte(tc.in))); got != tc.want {
+			t.Errorf("numberLine(%d, %q) = %q, want %q", tc.n, tc.in, got, tc.want)
+		}
+		if got := string(stripLineNumber([]byte(tc.want))); got != tc.in {
+			t.Errorf("stripLineNumber(%q) = %q, want %q", tc.want, got, tc.in)
+		}
+	}
+}
+
+func TestParseResend(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		n    int
+		want bool
+	}{
+		{"Resend: 12", 12, true},
+		{"Resend:12", 12, true},
+		{"Resend: N12", 12, true},
+		{"rs 7", 7, true},
+		{"rs N7", 7, true},
+		{"ok", 0, false},
+		{"Error:Line Number is not Last Line Number+1, Last Line: 11", 0, false},
+		{"Resend: 99999999999999999999", 0, false},
+	} {
+		n, ok := parseResend(tc.in)
+		if ok != tc.want || ok && n != tc.n {
+			t.Errorf("parseResend(%q) = %d, %v, want %d, %v", tc.in, n, ok, tc.n, tc.want)
+		}
+	}
+}
+
+func TestParseAdvancedOK(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		n    int
+		want bool
+	}{
+		{"ok N12 P15 B3", 3, true},
+		{"ok P15 B0", 0, true},
+		{"ok", 0, false},
+		{"ok T:210.0 /210.0 B:60.0 /60.0", 0, false},
+		{"echo: B3", 0, false},
+	} {
+		n, ok := parseAdvancedOK(tc.in)
+		if ok != tc.want || n != tc.n {
+			t.Errorf("parseAdvancedOK(%q) = %d, %v, want %d, %v", tc.in, n, ok, tc.n, tc.want)
+		}
+	}
+}
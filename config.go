@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// config holds the settings from the config file by section and key:
+//
+//	[hooks]
+//	job_end = notify-send "Print done"
+//	pause = "curl -d paused http://lights/\nmpc pause"
+//
+// Lines starting with # or ; are comments. A value in double quotes is
+// unquoted like a Go string, so it can hold newlines.
+type config map[string]map[string]string
+
+var (
+	config_path = defaultConfigPath()
+	conf        = config{}
+)
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "dripp3r", "dripp3r.conf")
+}
+
+// readConfig loads the config file given with -config. The default config
+// file need not exist.
+func readConfig() error {
+	if config_path == "" {
+		return nil
+	}
+	c, err := loadConfig(config_path)
+	if errors.Is(err, fs.ErrNotExist) && config_path == defaultConfigPath() {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	conf = c
+	return nil
+}
+
+func loadConfig(path string) (config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := config{}
+	section := ""
+	scan := bufio.NewScanner(f)
+	for n := 1; scan.Scan(); n++ {
+		ln := strings.TrimSpace(scan.Text())
+		switch {
+		case ln == "" || ln[0] == '#' || ln[0] == ';':
+			continue
+		case ln[0] == '[' && ln[len(ln)-1] == ']':
+			section = strings.TrimSpace(ln[1 : len(ln)-1])
+			continue
+		}
+		key, val, ok := strings.Cut(ln, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		if strings.HasPrefix(val, `"`) {
+			if val, err = strconv.Unquote(val); err != nil {
+				return nil, fmt.Errorf("%s:%d: bad quoted value", path, n)
+			}
+		}
+		if c[section] == nil {
+			c[section] = make(map[string]string)
+		}
+		c[section][key] = val
+	}
+	return c, scan.Err()
+}
+
+func (c config) get(section, key string) string {
+	return c[section][key]
+}
+
+// section returns the printer's own version of a config file section, e.g.
+// [pause ender3] for [pause], if it sets key, or else the section itself.
+func (d *dripper) section(section, key string) string {
+	if own := section + " " + d.name; conf.get(own, key) != "" {
+		return own
+	}
+	return section
+}
+
+// confFloat returns a number from the config file, or def if it isn't set.
+func confFloat(section, key string, def float64) (float64, error) {
+	val := conf.get(section, key)
+	if val == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return 0, fmt.Errorf("[%s] %s: not a number: %q", section, key, val)
+	}
+	return f, nil
+}
+
+// confInt returns a whole number from the config file, or def if it isn't
+// set.
+func confInt(section, key string, def int) (int, error) {
+	val := conf.get(section, key)
+	if val == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseUint(val, 10, 31)
+	if err != nil {
+		return 0, fmt.Errorf("[%s] %s: not a whole number: %q", section, key, val)
+	}
+	return int(n), nil
+}
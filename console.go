@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// console prints the conversation with a printer to stdout and copies it to
+// any monitors attached through the control channel. When a daemon drives
+// several printers, each has its own named console and every line is
+// prefixed with the name.
+type console struct {
+	name  string
+	hub   *monitorHub
+	mu    sync.Mutex
+	run   *regexp.Regexp // the kind of line being collapsed (see noise.go)
+	more  int            // lines of it not shown
+	began time.Time      // when the job began, for -timestamps job
+}
+
+// Set by -timestamps: whether lines to and from the printer are stamped with
+// the "clock" time or the time since the "job" began.
+var timestamps = ""
+
+// monitorHub is the set of monitors shared by all of a daemon's consoles.
+type monitorHub struct {
+	mu       sync.Mutex
+	monitors map[chan rpcNotice]bool
+}
+
+func newConsole() *console {
+	return &console{
+		hub:   &monitorHub{monitors: make(map[chan rpcNotice]bool)},
+		began: time.Now(),
+	}
+}
+
+// named returns a console for one printer that shares c's monitors.
+func (c *console) named(name string) *console {
+	return &console{name: name, hub: c.hub, began: time.Now()}
+}
+
+func (c *console) Printf(format string, args ...interface{}) {
+	c.print(fmt.Sprintf(format, args...))
+}
+
+func (c *console) Println(args ...interface{}) {
+	c.print(fmt.Sprintln(args...))
+}
+
+func (c *console) print(s string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ln := range strings.Split(strings.TrimSuffix(s, "\n"), "\n") {
+		out := c.stamp(ln)
+		c.logLine(out)
+		if json_out {
+			c.jsonLine(ln)
+		} else if c.screen(ln) {
+			c.show(out)
+		}
+		c.notify("console", consoleLine{c.name, ln})
+	}
+}
+
+// stamp puts the time in front of a line to or from the printer, as
+// -timestamps says.
+func (c *console) stamp(ln string) string {
+	if !strings.HasPrefix(ln, ">> ") && !strings.HasPrefix(ln, "<< ") {
+		return ln
+	}
+	switch timestamps {
+	case "clock":
+		return time.Now().Format("15:04:05.000 ") + ln
+	case "job":
+		d := time.Since(c.began)
+		return fmt.Sprintf("+%d:%02d:%06.3f %s", int(d.Hours()),
+			int(d.Minutes())%60, (d % time.Minute).Seconds(), ln)
+	}
+	return ln
+}
+
+// jobBegan starts the clock for -timestamps job.
+func (c *console) jobBegan() {
+	c.mu.Lock()
+	c.began = time.Now()
+	c.mu.Unlock()
+}
+
+func (c *console) show(ln string) {
+	if c.name != "" {
+		fmt.Printf("[%s] %s\n", c.name, ln)
+	} else {
+		fmt.Println(ln)
+	}
+}
+
+type consoleLine struct {
+	Printer string `json:"printer,omitempty"`
+	Line    string `json:"line"`
+}
+
+type consoleTemps struct {
+	Printer string `json:"printer,omitempty"`
+	temps
+}
+
+func (c *console) notifyTemps(t temps) {
+	if json_out {
+		writeJSON(struct {
+			jsonHead
+			temps
+		}{jsonHead{"temps", time.Now(), c.name}, t})
+	}
+	c.notify("temps", consoleTemps{c.name, t})
+}
+
+type consoleStatus struct {
+	Printer string `json:"printer,omitempty"`
+	ctlStatus
+}
+
+func (c *console) notifyStatus(st ctlStatus) {
+	if json_out {
+		writeJSON(struct {
+			jsonHead
+			ctlStatus
+		}{jsonHead{"status", time.Now(), c.name}, st})
+	}
+	c.notify("status", consoleStatus{c.name, st})
+}
+
+// showEvent prints the lines sent to the printer and its responses, unless
+// they are quiet, and sends the temperatures to the monitors.
+func (c *console) showEvent(ev event) {
+	switch ev := ev.(type) {
+	case lineSent:
+		if !ev.Quiet || verbose {
+			c.Printf(">> %s\n", stripLineNumber([]byte(ev.Line)))
+		}
+	case responseReceived:
+		if !ev.Quiet || verbose {
+			c.Printf("<< %s\n", ev.Line)
+		}
+	case tempsUpdated:
+		c.notifyTemps(ev.temps)
+	}
+}
+
+// notify sends a notification to every monitor. A monitor that can't keep up
+// misses notifications rather than stalling the printer.
+func (c *console) notify(method string, params interface{}) {
+	note := rpcNotice{"2.0", method, params}
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	for ch := range c.hub.monitors {
+		select {
+		case ch <- note:
+		default:
+		}
+	}
+}
+
+func (c *console) attach() chan rpcNotice {
+	ch := make(chan rpcNotice, 256)
+	c.hub.mu.Lock()
+	c.hub.monitors[ch] = true
+	c.hub.mu.Unlock()
+	return ch
+}
+
+func (c *console) detach(ch chan rpcNotice) {
+	c.hub.mu.Lock()
+	delete(c.hub.monitors, ch)
+	c.hub.mu.Unlock()
+}
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The control channel speaks JSON-RPC 2.0 with one message per line, over a
+// Unix domain socket or a Windows named pipe (see ctlsock_*.go).
+type rpcRequest struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  ctlParams       `json:"params"`
+}
+
+type rpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcNotice is a notification streamed to monitors.
+type rpcNotice struct {
+	Version string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+const (
+	rpcParseError = -32700
+	rpcAppError   = -32000
+)
+
+type ctlParams struct {
+	Printer string `json:"printer,omitempty"`
+	Path    string `json:"path,omitempty"`
+	GCode   string `json:"gcode,omitempty"`
+	Name    string `json:"name,omitempty"`
+}
+
+type ctlStatus struct {
+	State      string   `json:"state"`
+	Job        string   `json:"job,omitempty"`
+	JobID      int      `json:"job_id,omitempty"` // counts the jobs started
+	Queued     int      `json:"queued"`
+	Temps      temps    `json:"temps"`
+	Feedrate   int      `json:"feedrate"`
+	Flow       int      `json:"flow"`
+	Fan        int      `json:"fan"`
+	Position   position `json:"position"`
+	Babystep   float64  `json:"babystep"`
+	FirstLayer bool     `json:"first_layer,omitempty"`
+	Machine    string   `json:"machine,omitempty"`  // unless a 3D printer
+	Firmware   string   `json:"firmware,omitempty"` // its own state, unless idle
+	Heating    string   `json:"heating,omitempty"`  // while M109 or M190 waits
+	Progress   float64  `json:"progress,omitempty"` // 0 to 1, by lines sent
+	Line       int      `json:"line,omitempty"`
+	Lines      int      `json:"lines,omitempty"`
+	Layer      int      `json:"layer,omitempty"` // going by the slicer's comments
+	Layers     int      `json:"layers,omitempty"`
+	Left       int      `json:"left,omitempty"` // seconds the job has left
+}
+
+func (st ctlStatus) String() string {
+	s := st.State
+	if st.Job != "" {
+		s += " " + st.Job
+	}
+	if st.Lines > 0 {
+		s += fmt.Sprintf(" (line %d of %d)", st.Line, st.Lines)
+	}
+	if st.Layer > 0 {
+		s += " (layer " + st.layer() + ")"
+	}
+	if st.Left > 0 {
+		s += " (" + clock(time.Duration(st.Left)*time.Second) + " left)"
+	}
+	if st.Firmware != "" {
+		s += " (firmware " + st.Firmware + ")"
+	}
+	if st.Heating != "" {
+		s += " (heating " + st.Heating + ")"
+	}
+	if st.Machine != "" {
+		return fmt.Sprintf("%s (%d queued) %s %s feed %d%%", s, st.Queued,
+			st.Machine, st.Position, st.Feedrate)
+	}
+	s = fmt.Sprintf("%s (%d queued) %s %s feed %d%% flow %d%% fan %d%%", s,
+		st.Queued, st.Temps, st.Position, st.Feedrate, st.Flow, st.Fan)
+	if st.Babystep != 0 {
+		s += fmt.Sprintf(" babystep %+.2f", st.Babystep)
+	}
+	if st.FirstLayer {
+		s += " first layer"
+	}
+	return s
+}
+
+// layer is the layer being printed, out of how many there are if known,
+// e.g. "37/214".
+func (st ctlStatus) layer() string {
+	if st.Layers > 0 {
+		return fmt.Sprintf("%d/%d", st.Layer, st.Layers)
+	}
+	return strconv.Itoa(st.Layer)
+}
+
+// title is the status for the terminal's title, to see how a job is getting
+// on with the window minimized or in another tab, e.g. "dripp3r 42% 1h03m
+// left - benchy.gcode".
+func (st ctlStatus) title(printer string) string {
+	s := "dripp3r"
+	if printer != "" {
+		s += " [" + printer + "]"
+	}
+	if st.State != "printing" && st.State != "idle" {
+		s += " " + st.State
+	}
+	if st.Lines > 0 {
+		s += fmt.Sprintf(" %d%%", int(st.Progress*100))
+	}
+	if st.Left > 0 {
+		s += " " + clock(time.Duration(st.Left)*time.Second) + " left"
+	}
+	if st.Job != "" {
+		s += " - " + filepath.Base(st.Job)
+	}
+	return s
+}
+
+// line is the status in short, for the status line: overrides are only
+// shown when they are in use.
+func (st ctlStatus) line() string {
+	t := st.Temps
+	if st.FirstLayer {
+		return fmt.Sprintf("FIRST LAYER | Z%+.2f | flow %d%% | speed %d%% | "+
+			"T%.0f/%.0f B%.0f/%.0f", st.Babystep, st.Flow, st.Feedrate,
+			t.Hotend, t.HotendTarget, t.Bed, t.BedTarget)
+	}
+	s := st.State
+	if st.Job != "" {
+		s += " " + filepath.Base(st.Job)
+	}
+	if st.Lines > 0 {
+		s += fmt.Sprintf(" %d%%", int(st.Progress*100))
+	}
+	if st.Layer > 0 {
+		s += " layer " + st.layer()
+	}
+	if st.Left > 0 {
+		s += " " + clock(time.Duration(st.Left)*time.Second) + " left"
+	}
+	if st.Machine != "" {
+		s += " | " + st.Position.String()
+	} else if st.Heating != "" {
+		s += fmt.Sprintf(" | heating %s | %s", st.Heating, st.Position)
+	} else {
+		s += fmt.Sprintf(" | T%.0f/%.0f B%.0f/%.0f | %s", t.Hotend, t.HotendTarget,
+			t.Bed, t.BedTarget, st.Position)
+	}
+	if st.Feedrate != 100 {
+		s += fmt.Sprintf(" | feed %d%%", st.Feedrate)
+	}
+	if st.Flow != 100 {
+		s += fmt.Sprintf(" | flow %d%%", st.Flow)
+	}
+	if st.Fan != 0 {
+		s += fmt.Sprintf(" | fan %d%%", st.Fan)
+	}
+	return s
+}
+
+// ctlRequest is a single call read from the control channel. Requests are
+// handled inside the drip loop so they never race the serial state.
+type ctlRequest struct {
+	method string
+	params ctlParams
+	reply  chan ctlReply
+}
+
+type ctlReply struct {
+	result interface{}
+	err    error
+}
+
+func serveControl(l net.Listener, f *farm, con *console) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			// Listener was closed when the daemon exited.
+			return
+		}
+		go handleControl(conn, f, con)
+	}
+}
+
+// handleControl answers each request line with one response line, for as
+// many lines as the client sends. A "monitor" or "attach" request turns the
+// connection into a session that also streams notifications.
+func handleControl(conn net.Conn, f *farm, con *console) {
+	defer conn.Close()
+	scan := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for scan.Scan() {
+		var call rpcRequest
+		if err := json.Unmarshal(scan.Bytes(), &call); err != nil {
+			enc.Encode(rpcResponse{
+				Version: "2.0",
+				Error:   &rpcError{rpcParseError, err.Error()},
+			})
+			continue
+		}
+		switch call.Method {
+		case "monitor", "attach":
+			resp := rpcResponse{Version: "2.0", ID: call.ID}
+			resp.Result, _ = json.Marshal(call.Method)
+			if enc.Encode(resp) == nil {
+				sessionControl(scan, enc, con, f, call.Method == "monitor")
+			}
+			return
+		}
+		if err := enc.Encode(callLoop(f, call)); err != nil {
+			return
+		}
+	}
+}
+
+// callLoop hands a call to the drip loop of the printer it names and waits
+// for its response.
+func callLoop(f *farm, call rpcRequest) rpcResponse {
+	var rep ctlReply
+	if call.Method == "printers" {
+		rep.result = f.names
+	} else if d, err := f.lookup(call.Params.Printer); err != nil {
+		rep.err = err
+	} else {
+		rep = d.call(call)
+	}
+	return rep.response(call.ID)
+}
+
+// call hands a call to the drip loop and waits for its reply.
+func (d *dripper) call(call rpcRequest) ctlReply {
+	req := ctlRequest{call.Method, call.Params, make(chan ctlReply)}
+	select {
+	case d.ctl_chan <- req:
+	case <-d.stopped:
+		return ctlReply{err: errors.New("printer is stopped")}
+	}
+	return <-req.reply
+}
+
+func (rep ctlReply) response(id json.RawMessage) rpcResponse {
+	resp := rpcResponse{Version: "2.0", ID: id}
+	if rep.err == nil {
+		resp.Result, rep.err = json.Marshal(rep.result)
+	}
+	if rep.err != nil {
+		resp.Error = &rpcError{rpcAppError, rep.err.Error()}
+	}
+	return resp
+}
+
+// sessionControl streams console and temperature notifications to an
+// attached client while still answering its requests. Read-only monitors
+// have every request refused.
+func sessionControl(scan *bufio.Scanner, enc *json.Encoder, con *console,
+	f *farm, readonly bool) {
+	notes := con.attach()
+	defer con.detach(notes)
+
+	replies := make(chan rpcResponse)
+	done := make(chan struct{})
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		defer close(done)
+		for scan.Scan() {
+			var call rpcRequest
+			resp := rpcResponse{Version: "2.0"}
+			err := json.Unmarshal(scan.Bytes(), &call)
+			switch {
+			case err != nil:
+				resp.Error = &rpcError{rpcParseError, err.Error()}
+			case readonly:
+				resp.ID = call.ID
+				resp.Error = &rpcError{rpcAppError, "monitor is read-only"}
+			default:
+				resp = callLoop(f, call)
+			}
+			select {
+			case replies <- resp:
+			case <-quit:
+				return
+			}
+		}
+	}()
+	for {
+		var err error
+		select {
+		case note := <-notes:
+			err = enc.Encode(note)
+		case resp := <-replies:
+			err = enc.Encode(resp)
+		case <-done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// ctlMain runs one of the client subcommands against a running daemon, e.g.
+// "dripp3r pause" or "dripp3r queue add part.gcode".
+func ctlMain(cmd string, args []string) {
+	flags := flag.NewFlagSet(cmd, flag.ExitOnError)
+	sock := flags.String("socket", defaultSocketPath(), "control socket `path`")
+	printer := flags.String("p", "", "printer `name` when the daemon runs several")
+	split := flags.Bool("split", false, "monitor each printer in its own pane")
+	flags.Usage = usage
+	flags.Parse(args)
+	args = flags.Args()
+
+	params := ctlParams{Printer: *printer}
+	switch cmd {
+	case "status", "pause", "resume", "cancel", "cooldown", "monitor",
+		"attach":
+		if len(args) != 0 {
+			usage()
+		}
+	case "queue":
+		if len(args) != 2 || args[0] != "add" {
+			usage()
+		}
+		// The daemon may not share our working directory.
+		path, err := filepath.Abs(args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		params.Path = path
+	case "gcode":
+		if len(args) == 0 {
+			usage()
+		}
+		params.GCode = strings.Join(args, " ")
+	case "macro", "preheat":
+		if len(args) != 1 {
+			usage()
+		}
+		params.Name = args[0]
+	}
+
+	switch cmd {
+	case "monitor":
+		var view sessionView = plainView{*printer}
+		if *split {
+			view = newPaneView(*printer)
+		} else if newStatusLine() != nil {
+			// Ctrl-C is the way out: clear the status line first.
+			sig_chan := make(chan os.Signal, 1)
+			signal.Notify(sig_chan, os.Interrupt)
+			go func() {
+				<-sig_chan
+				closeEditor()
+				os.Exit(exitCompleted)
+			}()
+		}
+		err := ctlMonitor(*sock, view)
+		closeEditor()
+		ctlCheck(err)
+		return
+	case "attach":
+		err := ctlAttach(*sock, *printer)
+		closeEditor()
+		ctlCheck(err)
+		return
+	case "status":
+		ctlCheck(ctlStatusAll(*sock, *printer))
+		return
+	case "eeprom":
+		ctlCheck(ctlEEPROM(*sock, *printer, args))
+		return
+	case "probe-test":
+		if len(args) != 0 {
+			usage()
+		}
+		ctlCheck(ctlProbeTest(*sock, *printer))
+		return
+	case "exclude":
+		ctlCheck(ctlExclude(*sock, *printer, args))
+		return
+	}
+	var msg string
+	ctlCheck(ctlCall(*sock, cmd, params, &msg))
+	if msg != "" {
+		fmt.Println(msg)
+	}
+}
+
+// ctlStatusAll prints the status of one printer, or of every printer with
+// its name when none is chosen.
+func ctlStatusAll(sock, printer string) error {
+	names := []string{printer}
+	if printer == "" {
+		if err := ctlCall(sock, "printers", ctlParams{}, &names); err != nil {
+			return err
+		}
+	}
+	for _, name := range names {
+		var st ctlStatus
+		if err := ctlCall(sock, "status", ctlParams{Printer: name}, &st); err != nil {
+			return err
+		}
+		if len(names) > 1 {
+			fmt.Printf("%s: ", name)
+		}
+		fmt.Println(st)
+	}
+	return nil
+}
+
+func ctlCheck(err error) {
+	var rerr *rpcError
+	switch {
+	case errors.As(err, &rerr):
+		fmt.Fprintln(os.Stderr, rerr.Message)
+		os.Exit(1)
+	case err != nil:
+		log.Fatal(err)
+	}
+}
+
+// ctlCall makes a single call to the daemon and decodes its result into
+// result. Errors reported by the daemon are returned as *rpcError.
+func ctlCall(sock, method string, params ctlParams, result interface{}) error {
+	conn, err := ctlDial(sock)
+	if err != nil {
+		return fmt.Errorf("is the daemon running? %w", err)
+	}
+	defer conn.Close()
+	call := rpcRequest{"2.0", json.RawMessage("1"), method, params}
+	if err := json.NewEncoder(conn).Encode(call); err != nil {
+		return err
+	}
+	var resp rpcResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	return json.Unmarshal(resp.Result, result)
+}
+
+// ctlMonitor attaches to the daemon read-only and shows the console and
+// temperatures as they arrive.
+func ctlMonitor(sock string, view sessionView) error {
+	conn, err := ctlSession(sock, "monitor")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return readSession(json.NewDecoder(conn), view)
+}
+
+// ctlSession opens a monitor or attach session with the daemon.
+func ctlSession(sock, method string) (net.Conn, error) {
+	conn, err := ctlDial(sock)
+	if err != nil {
+		return nil, fmt.Errorf("is the daemon running? %w", err)
+	}
+	call := rpcRequest{"2.0", json.RawMessage("0"), method, ctlParams{}}
+	if err := json.NewEncoder(conn).Encode(call); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// readSession passes the notifications and replies streamed in a session to
+// view until the connection drops.
+func readSession(dec *json.Decoder, view sessionView) error {
+	for {
+		var msg struct {
+			rpcResponse
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			return err
+		}
+		switch {
+		case msg.Error != nil:
+			view.note("-- ERROR: " + msg.Error.Message)
+		case msg.Method == "console":
+			var ln consoleLine
+			json.Unmarshal(msg.Params, &ln)
+			view.line(ln.Printer, ln.Line)
+		case msg.Method == "temps":
+			var t consoleTemps
+			json.Unmarshal(msg.Params, &t)
+			view.temps(t.Printer, t.temps)
+		case msg.Method == "status":
+			var st consoleStatus
+			json.Unmarshal(msg.Params, &st)
+			view.status(st.Printer, st.ctlStatus)
+		case msg.Result != nil && string(msg.ID) != "0":
+			var res string
+			if json.Unmarshal(msg.Result, &res) == nil && res != "" {
+				view.note("-- " + res)
+			}
+		}
+	}
+}
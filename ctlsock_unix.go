@@ -0,0 +1,77 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+func defaultSocketPath() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = privateSocketDir()
+	}
+	return filepath.Join(dir, "dripp3r.sock")
+}
+
+// privateSocketDir is where the socket goes without XDG_RUNTIME_DIR: a
+// directory of the user's own in the shared temporary one, so no one else
+// can put a socket where the user's commands will look for it.
+func privateSocketDir() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("dripp3r-%d", os.Getuid()))
+}
+
+// checkSocketDir makes the private socket directory if need be, and makes
+// sure it is the user's and no one else's.
+func checkSocketDir(dir string) error {
+	if err := os.Mkdir(dir, 0o700); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !fi.IsDir() || !ok || int(st.Uid) != os.Getuid() || fi.Mode().Perm() != 0o700 {
+		return fmt.Errorf("%s is not a directory of yours with mode 0700; remove it or give a -socket", dir)
+	}
+	return nil
+}
+
+func ctlListen(path string) (net.Listener, error) {
+	if dir := filepath.Dir(path); dir == privateSocketDir() {
+		if err := checkSocketDir(dir); err != nil {
+			return nil, err
+		}
+	}
+	// A socket left behind by a crashed daemon would make Listen fail, but
+	// one that still answers belongs to a daemon that is running.
+	c, err := net.Dial("unix", path)
+	switch {
+	case err == nil:
+		c.Close()
+		return nil, fmt.Errorf("%s: another daemon is listening there; give this one its own -socket", path)
+	case errors.Is(err, syscall.ECONNREFUSED):
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// Only the user may control the printer, as with the pipe on Windows.
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func ctlDial(path string) (net.Conn, error) {
+	return net.Dial("unix", path)
+}
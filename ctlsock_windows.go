@@ -0,0 +1,25 @@
+//go:build windows
+
+package main
+
+import (
+	"github.com/Microsoft/go-winio"
+	"net"
+)
+
+func defaultSocketPath() string {
+	return `\\.\pipe\dripp3r`
+}
+
+// Pipe access for SYSTEM, administrators and interactive users, so "dripp3r
+// attach" works from a normal desktop session while the daemon runs as a
+// service.
+const pipeSDDL = "D:P(A;;GA;;;SY)(A;;GA;;;BA)(A;;GRGW;;;IU)"
+
+func ctlListen(path string) (net.Listener, error) {
+	return winio.ListenPipe(path, &winio.PipeConfig{SecurityDescriptor: pipeSDDL})
+}
+
+func ctlDial(path string) (net.Conn, error) {
+	return winio.DialPipe(path, nil)
+}
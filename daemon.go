@@ -0,0 +1,453 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// daemonMain stays connected to one or more printers and drips whatever
+// jobs and GCodes arrive on the control channel, until interrupted.
+func daemonMain(args []string) int {
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	sock := flags.String("socket", defaultSocketPath(), "control socket `path`")
+	flags.StringVar(&moonraker_addr, "moonraker", "",
+		"serve the Moonraker API for a printer on `[name=]address`, e.g. :7125")
+	flags.StringVar(&grpc_addr, "grpc", "",
+		"serve the gRPC API on `address`, e.g. :50051")
+	flags.StringVar(&http_addr, "http", "",
+		"serve the HTTP API to upload and print files on `address`, e.g. :8080")
+	flags.StringVar(&prusalink_addr, "prusalink", "",
+		"serve the PrusaLink API for a printer on `[name=]address`, e.g. :8081")
+	flags.BoolVar(&mock, "mock", false,
+		"drive a virtual Marlin printer named mock, for demos and trying things out")
+	flags.Float64Var(&timed, "timed", 0,
+		"with -mock, have the virtual printer go `n` times faster")
+	dripFlags(flags)
+	flags.Usage = usage
+	flags.Parse(args)
+	ports := flags.Args()
+	if mock {
+		ports = append(ports, mock_port)
+	}
+	if len(ports) == 0 {
+		usage()
+	}
+	if err := readConfig(); err != nil {
+		die(exitUsage, err)
+	}
+	if err := checkRepeat(""); err != nil {
+		die(exitUsage, err)
+	}
+	if err := checkTimed(); err != nil {
+		die(exitUsage, err)
+	}
+	if err := checkTimelapse(); err != nil {
+		die(exitUsage, err)
+	}
+	if err := checkConsole(); err != nil {
+		die(exitUsage, err)
+	}
+	if dump_raw != "" && len(ports) > 1 {
+		die(exitUsage, errors.New("-dump-raw records one printer, not several"))
+	}
+	if err := checkDump(); err != nil {
+		die(exitUsage, err)
+	}
+	if err := checkFirmware(); err != nil {
+		die(exitUsage, err)
+	}
+	if err := checkMachine(); err != nil {
+		die(exitUsage, err)
+	}
+	if err := checkAPI(); err != nil {
+		die(exitUsage, err)
+	}
+	// journald adds its own timestamps.
+	if os.Getenv("JOURNAL_STREAM") != "" {
+		log.SetFlags(0)
+	}
+
+	// Claim the control socket before opening any port, so a second daemon
+	// on the same socket stops without resetting the boards.
+	l, err := ctlListen(*sock)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer l.Close()
+
+	f := &farm{drippers: make(map[string]*dripper)}
+	con := newConsole()
+	for _, arg := range ports {
+		name, path, ok := strings.Cut(arg, "=")
+		if !ok {
+			name, path = filepath.Base(arg), arg
+		}
+		if f.drippers[name] != nil {
+			die(exitUsage, fmt.Errorf("printer named %q given twice", name))
+		}
+		port, err := openPort(path)
+		if err != nil {
+			die(exitSerial, err)
+		}
+		defer port.Close()
+
+		pcon := con
+		if len(ports) > 1 {
+			pcon = con.named(name)
+		}
+		d := newDripper(port, pcon)
+		d.name = name
+		d.daemon = true
+		if err := d.readProfile(); err != nil {
+			die(exitUsage, err)
+		}
+		if err := d.startExtensions(); err != nil {
+			die(exitUsage, err)
+		}
+		// Poll temperatures so status and monitors stay current.
+		d.temp_tick = time.NewTicker(5 * time.Second).C
+		f.names = append(f.names, name)
+		f.drippers[name] = d
+	}
+
+	log.Print("Listening on ", *sock)
+	go serveControl(l, f, con)
+	if moonraker_addr != "" {
+		if err := serveMoonraker(f, con); err != nil {
+			die(exitUsage, err)
+		}
+	}
+	if grpc_addr != "" {
+		if err := serveGRPC(f, con); err != nil {
+			die(exitUsage, err)
+		}
+	}
+	if http_addr != "" {
+		if err := serveHTTPAPI(f); err != nil {
+			die(exitUsage, err)
+		}
+	}
+	if prusalink_addr != "" {
+		if err := servePrusaLink(f); err != nil {
+			die(exitUsage, err)
+		}
+	}
+	sdNotify("READY=1\nSTATUS=Listening on " + *sock)
+	if wd := sdWatchdog(); wd > 0 {
+		go f.watchdog(wd / 2)
+	}
+	defer sdNotify("STOPPING=1")
+
+	if runningAsService() {
+		if err := runService(f); err != nil {
+			log.Print(err)
+			return exitFailed
+		}
+		return exitCompleted
+	}
+	f.run()
+	for _, name := range f.names {
+		if err := f.drippers[name].err; err != nil {
+			return exitCode(err)
+		}
+	}
+	return exitCompleted
+}
+
+// farm holds the printers run by a daemon, by name.
+type farm struct {
+	names    []string
+	drippers map[string]*dripper
+}
+
+// run runs every printer's drip loop until they have all stopped.
+func (f *farm) run() {
+	var wg sync.WaitGroup
+	for _, d := range f.drippers {
+		wg.Add(1)
+		go func(d *dripper) {
+			defer wg.Done()
+			d.loop()
+		}(d)
+	}
+	wg.Wait()
+}
+
+// stop shuts every printer down gracefully, as if sent SIGTERM.
+func (f *farm) stop() {
+	for _, d := range f.drippers {
+		go func(d *dripper) {
+			d.sig_chan <- syscall.SIGTERM
+		}(d)
+	}
+}
+
+// lookup finds the printer a request is for. The name may be left out when
+// there is only one printer.
+func (f *farm) lookup(name string) (*dripper, error) {
+	if name == "" {
+		if len(f.names) != 1 {
+			return nil, fmt.Errorf("choose a printer: %s",
+				strings.Join(f.names, ", "))
+		}
+		name = f.names[0]
+	}
+	d := f.drippers[name]
+	if d == nil {
+		return nil, fmt.Errorf("no printer named %q", name)
+	}
+	return d, nil
+}
+
+// controlLater starts the control requests that are answered when the
+// printer replies to a GCode, and reports whether req was one.
+func (d *dripper) controlLater(req ctlRequest) bool {
+	var code string
+	switch req.method {
+	case "settings":
+		code = "M503"
+		d.hack_queue = append(d.hack_queue, code)
+	case "probe-test":
+		// Probing mid-print would ruin it.
+		if d.gcode != nil {
+			req.reply <- ctlReply{err: errors.New("cannot test the probe while printing")}
+			return true
+		}
+		code = "M48"
+		d.hack_queue = append(d.hack_queue, "G28", code)
+	default:
+		return false
+	}
+	d.waiting[code] = append(d.waiting[code], req.reply)
+	return true
+}
+
+// reply answers the requests waiting for the printer's reply to a GCode.
+func (d *dripper) reply(code string, res interface{}, err error) {
+	for _, reply := range d.waiting[code] {
+		reply <- ctlReply{res, err}
+	}
+	delete(d.waiting, code)
+}
+
+// control runs a control request on the drip loop and returns the result.
+func (d *dripper) control(method string, params ctlParams) (interface{}, error) {
+	switch method {
+	case "print", "queue":
+		if params.Path == "" {
+			return nil, errors.New("missing path")
+		}
+		if d.halted {
+			return nil, fmt.Errorf("halted: send %s first", d.fw.recover)
+		}
+		if d.job_name != "" || d.gcode != nil {
+			d.job_queue = append(d.job_queue, params.Path)
+			return fmt.Sprintf("queued %d", len(d.job_queue)), nil
+		}
+		if err := d.startJob(params.Path); err != nil {
+			return nil, err
+		}
+		return "printing " + params.Path, nil
+	case "gcode":
+		if params.GCode == "" {
+			return nil, errors.New("missing gcode")
+		}
+		d.queueLine(params.GCode)
+		return "", nil
+	case "raw":
+		// As the network APIs send GCode: as it is, without the macros,
+		// or the : and @ commands, which run files and programs here.
+		if params.GCode == "" {
+			return nil, errors.New("missing gcode")
+		}
+		if hostLine(params.GCode) {
+			return nil, fmt.Errorf("not GCode: %s", params.GCode)
+		}
+		d.hack_queue = append(d.hack_queue, strings.TrimSpace(params.GCode))
+		return "", nil
+	case "macro":
+		name := findMacro(params.Name)
+		if name == "" {
+			return nil, fmt.Errorf("no macro named %q", params.Name)
+		}
+		d.runMacro(name)
+		return "running " + name, nil
+	case "preheat":
+		return d.preheat(params.Name)
+	case "cooldown":
+		return d.cooldown(), nil
+	case "pause":
+		if d.job_name == "" {
+			return nil, errors.New("no job")
+		}
+		return d.requestPause()
+	case "resume":
+		if d.pause_at != "" && !d.paused {
+			d.cancelPause()
+			return "pause cancelled", nil
+		}
+		if !d.paused {
+			return nil, errors.New("not paused")
+		}
+		d.con.Println("-- RESUME")
+		d.paused = false
+		d.returnToJob()
+		return "resumed", nil
+	case "cancel":
+		if d.job_name == "" {
+			return nil, errors.New("no job")
+		}
+		d.cancelJob(errAborted)
+		return "cancelled", nil
+	case "key":
+		return d.hotkey(params.Name)
+	case "objects":
+		return d.objectList(), nil
+	case "exclude":
+		return d.excludeObject(params.Name)
+	case "status":
+		return d.status(), nil
+	}
+	return nil, fmt.Errorf("unknown method: %s", method)
+}
+
+func (d *dripper) status() ctlStatus {
+	st := ctlStatus{
+		State:      "idle",
+		Job:        d.job_name,
+		Queued:     len(d.job_queue),
+		Temps:      d.temps,
+		Feedrate:   d.feedrate,
+		Flow:       d.flow,
+		Fan:        fanPercent(d.fan),
+		Position:   d.pos,
+		Babystep:   d.babystep,
+		FirstLayer: d.onFirstLayer(),
+	}
+	if !isPrinter() {
+		st.Machine = machine
+	}
+	if d.fw_state != "idle" {
+		st.Firmware = d.fw_state
+	}
+	if d.heat != nil {
+		st.Heating = d.heat.String()
+	}
+	if p, ok := d.progress(); ok {
+		st.Progress = p
+		st.Line, st.Lines = d.sent, d.totals.lines
+		st.Layers = d.totals.layers
+	}
+	if d.job_name != "" {
+		st.JobID = d.jobs
+		st.Layer = d.layer
+	}
+	if left, ok := d.left(); ok {
+		st.Left = int(left.Seconds())
+	}
+	switch {
+	case d.halted:
+		st.State = "halted"
+	case d.paused:
+		st.State = "paused"
+	case d.pause_at != "":
+		st.State = "pausing"
+	case d.job_name != "":
+		st.State = "printing"
+	case d.gcode != nil:
+		st.State = "stopping"
+	}
+	return st
+}
+
+// showStatus puts the status on the status line, and sends it to monitors,
+// when it changes.
+func (d *dripper) showStatus() {
+	st := d.status()
+	s := st.String()
+	if s == d.last_status {
+		return
+	}
+	d.last_status = s
+	setStatus(st.line())
+	setTitle(st.title(d.con.name))
+	d.con.notifyStatus(st)
+}
+
+func (d *dripper) startJob(path string) error {
+	f, err := openGCode(path)
+	if err != nil {
+		return err
+	}
+	d.con.Println("-- DRIP FILE", path)
+	d.job_name = path
+	d.sent = 0
+	d.layer = 0
+	d.first_layer = false
+	d.object, d.objects, d.excluded = "", nil, nil
+	d.pause_at = ""
+	d.frames, d.frames_dir = 0, ""
+	d.hold = nil
+	d.jobs++
+	d.scanTotals()
+	d.gcode_file, d.gcode_err = d.jobLines(f)
+	d.gcode = d.gcode_file
+	d.con.jobBegan()
+	d.bus.publish(jobStarted{d.job_name})
+	d.beforeJob()
+	return nil
+}
+
+// beforeJob queues what goes ahead of a job's own GCode.
+func (d *dripper) beforeJob() {
+	d.checkFootprint()
+	d.levelBed()
+	d.checkMesh()
+	d.primeLine()
+}
+
+// cancelJob abandons the current job, if any, and drips the stop GCodes.
+func (d *dripper) cancelJob(reason error) {
+	if d.job_name != "" {
+		d.con.Println("-- CANCEL", d.job_name)
+	}
+	d.jobFailed(reason)
+	d.runs = 0
+	if d.gcode_file != nil {
+		drainLines(d.gcode_file)
+	}
+	d.gcode_file = nil
+	d.job_name = ""
+	d.paused = false
+	d.pause_at = ""
+	d.hold = nil
+	d.gcode = stopGCode()
+}
+
+// jobDone is called when the current line source runs dry. It starts the
+// next queued job, if any.
+func (d *dripper) jobDone() {
+	if d.job_name != "" {
+		log.Print("Job done: ", d.job_name)
+	}
+	d.job_name = ""
+	d.gcode_file = nil
+	d.gcode = nil
+	for len(d.job_queue) > 0 {
+		path := d.job_queue[0]
+		d.job_queue = d.job_queue[1:]
+		if err := d.startJob(path); err != nil {
+			log.Print(err)
+			continue
+		}
+		return
+	}
+}
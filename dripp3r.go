@@ -7,15 +7,373 @@ Please note, this has only been tested on Windows and only with Marlin v1.0.6.
 Provide the serial port name/path as second argument and the path to the file
 containing Gcode as the second argument.
 
+Use "-" as the path to read GCode from stdin, e.g. to print straight from a
+slicer: "slicer --export-stdout | dripp3r COM3 -". The keyboard is then read
+from the terminal instead. Note that Ctrl-C also interrupts the program that is
+writing to the pipe.
+
+Gzip-compressed GCode (e.g. part.gcode.gz) is decompressed on the fly, and the
+GCode inside sliced .3mf and .ufp packages (as exported by PrusaSlicer or Cura)
+is printed without unzipping them first. The model's metadata is logged and
+-thumbnail saves its preview image.
+
+Every line is sent with a line number and checksum, so the printer can ask
+for a line that was garbled on the wire to be sent again. Numbers and checksums
+already in the file, e.g. from GCode captured from another host, are replaced.
+Use -no-checksum for firmware that does not understand them.
+
+dripp3r sends lines ahead of their answers, so the printer never waits on
+the host between moves, as far as the link has earned: the window starts at
+one line, grows by a line after every 32 answered cleanly and halves when
+the printer asks for a line again. When Marlin is built with ADVANCED_OK, its
+oks say how many more lines its buffer has room for ("ok N12 P15 B3"), and no
+more than that are in flight. Otherwise a second line goes ahead only when
+answers are slow on average (over 10ms, e.g. through a network serial
+bridge). While lines are sent again after a resend request, they go one at a
+time.
+
+Where the oks can't be relied on to come back promptly, -stream chars sends
+lines ahead the way Grbl is streamed, counting the bytes the printer has yet
+to answer against its 128 byte receive buffer; -stream lines goes back to
+counting lines answered. Either way, -firmware picks the one it calls for.
+
+For firmware built with other buffer sizes than its defaults, the [firmware]
+section of the config file gives them: rx_buffer for Marlin's
+RX_BUFFER_SIZE, which -stream chars counts against, bufsize for BUFSIZE,
+which caps how many lines go ahead, and max_cmd_size for MAX_CMD_SIZE. A line
+too long for the printer, once numbered, is sent without its spaces, unless it
+is a string command like M117; if it still doesn't fit, the job stops with an
+error rather than have the printer cut the line short.
+
+Other firmware is driven with -firmware. With -firmware grbl, CNC routers and
+laser engravers running Grbl are reset when dripp3r starts and it waits for
+their greeting. Lines are sent without numbers, as many ahead as fit in Grbl's
+128 byte buffer, and error:N answers a line like ok. Pausing holds the feed at
+once (!) and resuming starts the cycle again (~), and the daemon polls the
+position with ? rather than M105.
+
+With -firmware reprap, the daemon polls RepRapFirmware (Duet boards over USB)
+with M408 S0 rather than M105, and reads the temperatures, position and the
+firmware's own state out of its JSON. The object model M409 answers with on
+RepRapFirmware 3 is read the same way, whenever it is sent. Unless the
+firmware is idle, its state shows in the status, e.g. when it prints from its
+own SD card.
+
+Give a Duet's URL in place of the COM port, e.g. "dripp3r -firmware reprap
+http://duet.local job.gcode", to drive it over the network with its HTTP
+interface rather than USB. The password is the [duet] password in the config
+file, if it isn't the default. Lines go without checksums, and each is
+answered once the Duet has room for more.
+
+With -firmware smoothie, lines go to Smoothieware boards without numbers. When
+Smoothieware halts, e.g. on a limit switch or the kill button, the daemon
+cancels the job and stays connected rather than exiting; the status shows
+"halted" and no job starts until M999 is sent to clear it, e.g. with
+"dripp3r gcode M999". Run from the terminal, dripp3r exits as for any
+firmware error.
+
+With -firmware klipper, dripp3r drives Klipper through its virtual serial
+port, e.g. "dripp3r -firmware klipper /tmp/printer job.gcode". Lines go
+without checksums. A "!!" line is an error with the line it answers, which
+is shown but doesn't stop the job; action commands sent with RESPOND, e.g.
+"// action:pause", work as from Marlin. When Klipper shuts down, the daemon
+rides it out as for Smoothieware until FIRMWARE_RESTART is sent.
+
+With -firmware repetier, Repetier-Firmware's numbered oks and skips answer
+lines, and its wait, said when it has had nothing to do for a second, stands
+in for an ok that got lost on the way rather than leaving the job stuck. A
+fatal: error halts the job as for Smoothieware, until M999.
+
+-firmware marlin1 and -firmware prusa talk to Marlin 1.x and Prusa-Firmware,
+whose screen reports ("LCD status changed") are kept out of the replies they
+come between. With -firmware auto, the printer's answer to M115 in the handshake
+below says what it runs, and it is talked to as Marlin, Marlin 1.x, Prusa,
+Repetier, Smoothieware, Klipper or RepRapFirmware from then on; firmware it
+doesn't know is talked to as Marlin. Grbl has to be named, since it is reset
+before anything is sent. When firmware that greets the host on starting up
+(e.g. Marlin's "start") does so after it has answered a line, it was reset,
+e.g. by a brownout, and a job underway stops, since the lines in its buffer
+are lost.
+
+Before the job starts, dripp3r makes sure the printer is listening. Firmware
+that greets the host on starting up is given 2 seconds to do so, in case
+opening the port reset the board, and a second after its greeting to finish
+its start-up messages, e.g. reading the SD card, before anything is sent.
+Then M110 N0 and M115 have to be answered before the first line of the file
+goes. Grbl's greeting after it is reset does the same. If the printer says
+nothing for 10 seconds, or as long as -connect-timeout says, dripp3r gives
+up, asking whether it is switched on, on the right port and at the right
+baud rate, and exits with status 5. Once connected, -read-timeout gives up
+the same way on a printer that says nothing that makes sense for so long
+with a line unanswered, e.g. "-read-timeout 2m"; mind long heat-ups and
+moves on firmware without busy messages.
+
+A serial port that is busy, or not there yet, e.g. while the printer's USB is
+still enumerating after switching it on, is tried again after 250ms, then
+twice as long each time up to 5 seconds, for 10 seconds in all or as long as
+-retry says (e.g. -retry 1m, or -retry 0 to give up at once).
+
+Before printing a file, dripp3r reads it through once to count the lines it
+will send and the layers the slicer marked, and to estimate how long it
+takes as a timed dry run would (see -timed below), e.g. "-- 5120 lines, 214
+layers, about 2h10m", so that progress goes by lines sent rather than bytes
+read, which comments and thumbnails skew. The status line shows it as e.g.
+"42% layer 37/214 1h03m left", and "dripp3r status" as "line 2048 of 5120".
+The time left is what the estimate has left of the job, and once a minute
+of it has been printed, slowed or sped up by how the printer has kept up
+with it, leaving out waiting for the heaters. A file piped in can only be
+read once, and its progress isn't known. The terminal's title shows
+progress too, e.g. "dripp3r 42% 1h03m left - benchy.gcode", to keep an eye
+on with the window minimized or in another tmux pane; the title it had is
+put back at exit, where the terminal can.
+
+While M109 or M190 waits for the hotend or bed, the temperatures the
+printer reports every second are not printed one by one: the status line
+shows how far the heater has got and how long it has left, going by the
+last 10 seconds, e.g. "heating hotend 178/210°C, 12s left". Once there,
+Marlin waits for the temperature to hold for a while (TEMP_RESIDENCY_TIME),
+counting down in its reports (W:), which shows as "settling 7s" rather than
+looking stuck at the target. The console says when the wait starts, settles
+and ends, and how far it has got every 30 seconds. -v shows the reports as
+they come.
+
+With -parallel-heat, start code that waits for the bed (M190) before it
+sets the hotend's temperature, or the other way round, has the other heater
+set first (M104 or M140, to the temperature it later waits for), so both
+heat at once, which saves minutes on every print. Start code that sets the
+other heater before waiting, e.g. to a lower temperature while probing, is
+left as it is.
+
+Marlin and Prusa-Firmware are asked to say "busy" every 2 seconds while a
+line takes long (M113 S2), e.g. homing or a long move. If the printer then
+says nothing at all for five times as long with a line unanswered, it has
+hung or its answer was lost: dripp3r says so, and the error hook runs. The
+[firmware] keepalive setting gives the interval in seconds, or 0 to leave
+M113 alone for firmware built without HOST_KEEPALIVE_FEATURE:
+
+	[firmware ender3]
+	keepalive = 5
+
+Use -machine laser or -machine cnc when the machine is not a 3D printer.
+Stopping a job turns the laser or spindle off with M5, and the coolant with M9
+on a CNC machine, rather than the heaters and steppers, and an abort turns it
+off at once (Grbl is reset). Pausing turns a laser off and resuming lights it
+again. There are no temperatures to poll or show, and heating, extruding and
+filament changes are refused, as are -first-layer, -level, -check-mesh,
+-check-footprint, -parallel-heat, -prime and -host-m600.
+
+Comments starting with "@" are commands for dripp3r rather than the printer,
+so slicer scripts can drive it from within the file:
+
+	;@pause          pause until continued from the menu or control channel
+	;@snapshot       run the -snapshot command, e.g. to take a picture
+	;@run <command>  run a shell command; needs -allow-run, since anyone
+	                 who hands you a GCode file could use it
+
+Commands run in the background and their output is printed. The same
+commands can be typed in hacker mode or sent with "dripp3r gcode" without the
+";".
+
+Files post-processed for OctoPrint work too: bare @pause, @resume and
+@cancel (or @abort) lines pause, resume and cancel the job instead of being
+sent to the printer.
+
+Settings that don't fit on the command line live in a config file, by default
+dripp3r/dripp3r.conf in the user's config directory (~/.config on Linux,
+%AppData% on Windows), or wherever -config says. It is made of sections of
+key = value lines; a value in double quotes may contain \n escapes. See
+contrib/dripp3r.conf.
+
+The [console] section keeps repetitive lines off the screen. hide and
+collapse list kinds of line: sent (every >> line), ok, temps, wait and busy,
+or a /regular expression/ matched against the line as shown. Hidden lines
+are never shown; of a run of collapsed lines only the first is, followed by
+how many more there were. Monitors still see every line, and so does the
+file log names, if set:
+
+	[console]
+	hide = sent busy
+	collapse = temps /^<< echo:/
+	log = /var/log/dripp3r.log
+
+With -timestamps clock, each line to and from the printer (>> and <<) is
+shown and logged after the time of day, to the millisecond; with -timestamps
+job, after the time since the job began, e.g. "+0:12:03.250 << ok", to tell
+what the printer was doing when something went wrong.
+
+By default the console shows the lines sent and the printer's answers, but
+not the polling for temperatures, bare oks or Repetier's waits. -q shows none
+of the traffic, only the status line and dripp3r's own messages; -v shows all
+of it; -trace shows all of it and hex-dumps the bytes as they go over the
+wire, < before those read and > before those written, for debugging the
+protocol.
+
+-json is for other programs driving dripp3r, e.g. a front end: stdout
+carries every event as a JSON object on a line of its own, with its "event"
+and "time": "sent" and "response" for all the traffic (as with -v),
+"console" for dripp3r's own messages, "temps" for each temperature report,
+"status" whenever the status changes (with "state", "progress" and so on,
+as "dripp3r status" would give), and the events the hooks are given, e.g.
+"job_start", "layer_change", "pause" and "error" (see [hooks] below).
+There is no menu: Ctrl-C stops the job with the stop GCodes, as SIGTERM
+does.
+
+	{"event":"sent","time":"2024-05-01T12:00:01.5Z","line":"G28"}
+	{"event":"response","time":"2024-05-01T12:00:09.2Z","line":"ok"}
+
+-dump-raw file records the exact bytes sent to and received from the
+printer, a line for each read or write with the seconds since the dump
+started, the direction and the bytes as a quoted string:
+
+	0.031250 > "N1 G28*18\n"
+	0.052083 < "ok\n"
+
+The daemon records one printer this way, not several.
+
+A dump plays the printer in its place with "dripp3r replay -as-printer
+printer.dump job.gcode", taking the same flags as a print. After each write
+that matches up with one in the dump, in order, the printer's bytes that
+followed it come back as long after as they did then, so a capture from the
+field reproduces the session through the same code. The first write that
+differs from the dump is logged, as from there on the printer may be
+answering something else.
+
+"dripp3r -dry-run job.gcode" runs a job without a printer, against a
+stand-in that answers every line with ok at once. Everything else happens as
+it would on the printer: the file is read and transformed, progress is shown,
+and hooks, plugins and scripts run, so they can be tried out before the
+printer is tied up.
+
+With -timed n, a dry run answers each line once the printer would have
+carried it out, going by an estimate of its moves, n times faster than the
+printer would. At the end it shows the schedule the estimate makes of the
+job: when each layer would start and how long it would take. The estimate
+speeds moves up and down at the acceleration the file sets with M204, or
+1000mm/s², and takes homing to take 10s.
+
+-mock drives a virtual Marlin printer in place of a real one, e.g.
+"dripp3r -mock job.gcode" or "dripp3r daemon -mock", where the printer is
+named mock, for demos, screenshots and finding one's way around dripp3r. It
+greets the host, moves at the pace of the same estimate, heats and cools at
+a steady rate, reporting the temperatures while M109 and M190 wait like
+Marlin, and answers M105, M114 and M115. -timed n makes it n times faster.
+
+"dripp3r preview job.gcode -layer 3" draws what a layer of a file extrudes
+in the terminal, in braille dots, or in ASCII with -ascii, to check the part
+before printing it. Layers are counted from 1 by the slicer's comments, as
+when printing, or else by the height of the extruding moves.
+With -bed, it draws the box the whole part takes up on the bed instead, with
+how far it is from the center, to catch a part that is off center or too big
+before printing it. The bed and its keep-out zones are those of
+-check-footprint below. It exits with status 1 if the part goes off the bed
+or into a keep-out zone, which is drawn in red.
+
+The [hooks] section runs shell commands on events, e.g. to switch on a light
+or ping a phone. The event is passed on stdin as JSON, such as
+{"event":"layer_change","time":"...","job":"part.gcode","layer":12}:
+
+	job_start        a file started printing
+	job_end          the whole file was dripped
+	job_fail         the job was cancelled, stopped or failed ("error" says why)
+	pause            the job was paused
+	filament_change  the filament is being changed
+	runout           the printer ran out of filament
+	layer_change     a new layer started, going by the slicer's comments
+	error            something went wrong, e.g. the printer halted
+
+The [macros] section names sequences of GCode:
+
+	[macros]
+	LEVEL = "G28\nG29\nM500"
+	PURGE = "G92 E0\nG1 E30 F200"
+
+Typing a macro's name in hacker mode, sending it with "dripp3r gcode", or
+choosing it from the menu sends its lines ahead of the job. Macros can use
+other macros. "dripp3r macro LEVEL" and the "macro {name}" control method do
+the same but fail for an unknown name.
+
+The [aliases] section gives short names to commands. Unlike a macro, an alias
+can be followed by parameters, which are added to its last line:
+
+	[aliases]
+	home = G28
+	off = "M104 S0\nM140 S0"
+
+so "home X" sends "G28 X". Aliases work wherever macros do.
+
+For more than running a command, -script loads a Starlark (a small dialect of
+Python) script that handles the same events on the spot and can send GCode:
+
+	def fan_down(ev):
+	    if ev["layer"] == 50:
+	        gcode("M106 S204")
+	on("layer_change", fan_down)
+
+Scripts can call on(event, fn), gcode(line, ...), pause(), resume(), cancel(),
+status() and print(). Handlers are given the event as a dict and are stopped
+if they run too long. As usual in Starlark, they cannot change globals.
+
+Plugins are programs in any language, started for each printer from the
+[plugins] section of the config file:
+
+	[plugins]
+	lights = /usr/local/bin/printer-lights --port 8080
+
+Each event is written to the plugin's stdin as a JSON-RPC notification,
+{"jsonrpc":"2.0","method":"event","params":{"event":"pause",...}}, and the
+plugin can write the same requests to its stdout as to the control channel,
+e.g. {"jsonrpc":"2.0","id":1,"method":"gcode","params":{"gcode":"M106"}}. The
+replies come back on its stdin, mixed in with the events. A plugin should exit
+when its stdin is closed.
+
 The GCode sent to the printer is printed as it is sent. Any response other than
-ok is printed as well. This is spammy yet also, in a strange way, soothing.  On
-Windows, you can pause printing by pressing the "pause" button on your
-keyboard.  I recall that Ctrl-S & Ctrl-Q traditionally perform the same
-function in UNIX/Linux (untested).
+ok is printed as well. This is spammy yet also, in a strange way, soothing.
+
+Press p (or space) to pause printing, and again to resume. So the nozzle
+doesn't stop in the middle of a line and leave a blob, the job carries on up
+to the next travel move and pauses after it. The [pause] section of the config
+file can wait for the next layer change instead, or pause straight away, once
+the line in flight is done. Pressing p while waiting pauses at once, and
+"dripp3r resume" forgets the pause. If the head was moved while paused or in
+hacker mode, it goes back to where the job left off before carrying on,
+lifting the nozzle clear of the print on the way, and the job's speed and fan
+are put back as they were.
+
+The [pause] section can also park the nozzle off the print while paused: it
+pulls the filament back by retract mm, lifts the nozzle by lift mm and moves
+it to park_x and park_y. On resuming, prime mm of filament (as much as was
+retracted, unless set) is pushed out before the job carries on. A section
+named for a printer, e.g. [pause ender3], overrides [pause] for that printer
+only; the printer's name is the one given to the daemon, or the serial
+port's, like ttyUSB0.
+
+Other keys tune the print as it runs, as on the printer's own screen:
+
+	[ ]  babystep the nozzle 0.02mm down or up (M290), e.g. to tune the
+	     squish of the first layer
+	+ -  speed up or slow down by 10% (M220)
+	< >  extrude 5% less or more (M221), for over- or under-extrusion
+	f    turn the part cooling fan up by 25%, or off from full speed
+	h    home all axes, when paused or not printing; the result is shown
+	     once the printer is done
+	z    set the probe's Z offset, when paused or not printing (see below)
+
+The bottom line of the terminal shows the state of the job, the temperatures,
+the position of the head like a DRO and the overrides in use, unless a line is
+being typed. The position is followed from the GCode sent, and asked for with
+M114 after homing and while idle. In "dripp3r attach", the keys
+act on the daemon's job and the status line shows its state.
+
+With -first-layer, the first layer (going by the slicer's layer comments) is
+printed quietly for tuning it: the GCode and responses are hidden, except for
+errors and what the keys send, and the status line shows only the babystep,
+flow, speed and temperatures. From the second layer on it is back to normal.
 
 A simple menu can be accessed by pressing Ctrl-C. While the menu is shown,
 sending GCode to the printer is paused. Press Ctrl-C a second time to exit the
-program abruptly. This will stop sending instructions to the printer.
+program abruptly. This will stop sending instructions to the printer. Choose
+an option by pressing its key; there is no need to press Enter unless the
+keyboard is redirected from a file or pipe.
 
 The "continue" option will continue sending GCodes from the file. If you were
 previously sending GCodes from another source, it will resume sending GCodes
@@ -28,18 +386,363 @@ time the option is chosen.
 The "abort" option will stop sending any GCodes and exit the program.
 
 The "hacker mode" option will allow you stop sending GCodes from the file and
-instead type in GCodes manually.
+instead type in GCodes manually. The line can be edited as in a shell: the
+arrow keys move along it and recall earlier lines, and Ctrl-R searches them.
+The history is kept between runs. Tab completes common GCodes and macros, or
+lists the choices, and the parameters of the GCode being typed are hinted
+after the line.
+
+In hacker mode, ":run warmup.gcode" sends a whole file, waiting for each ok
+as usual, and then goes back to the keyboard. It works with "dripp3r gcode"
+too.
+
+The "macros" option lists the [macros] from the config file to run one.
+
+The "preheat" option heats the hotend and bed for a material, without
+waiting, and says when they are hot. PLA, PETG and ABS are built in; the
+[presets] section of the config file changes them or adds more, as the hotend
+and bed temperatures:
+
+	[presets]
+	PLA = 210 60
+	TPU = 225 50
+
+"dripp3r preheat pla" and the "preheat {name}" control method do the same on
+the daemon.
+
+The "cool down" option turns off the heaters and the part cooling fan and
+goes on as before, e.g. to keep the console open after stopping a job.
+"dripp3r cooldown" does the same on the daemon.
+
+The "endstops" option asks the printer for the state of its endstops (M119) and
+shows which are triggered, e.g. to find out why homing fails.
+
+The "settings" option shows the printer's settings (M503), grouped into steps,
+feedrates, acceleration, PID, offsets and so on, with the values labelled.
+The same happens whenever M503 is sent, e.g. from hacker mode.
+
+"dripp3r eeprom dump ender.gcode" backs the settings up to a file (or stdout)
+as the GCode that sets them, which can be read and edited. "dripp3r eeprom
+restore ender.gcode" sends them back and saves them with M500, e.g. after
+flashing new firmware.
+
+"dripp3r probe-test" checks that a bed probe is repeatable: the printer homes
+and probes the same point several times (M48), and the standard deviation and
+range of the readings are shown. It exits with status 1 if the deviation is
+over 0.01mm, or the limit in the daemon's config file:
+
+	[probe]
+	max_deviation = 0.005
+
+With -check-mesh, the printer is asked for its bed leveling mesh (M420 V)
+before each job, and the job is paused if there is none, if its points are
+more than 1mm apart, or if dripp3r last saw it probed (G29) over 7 days ago.
+Resuming prints anyway. The limits are set in the config file:
+
+	[mesh]
+	max_deviation = 0.5
+	max_age = 14
+
+With -check-footprint, the first layer of each job is drawn on the bed
+before it starts, and the job is paused if any of it goes off the bed or into
+a zone to keep out of, such as the clips holding the plate down, which are
+drawn in red. Resuming prints anyway. The [bed] section of the config file
+gives the bed size, or else the size in [prime], and the keep-out zones as
+rectangles from one corner to the other, x0,y0,x1,y1 in mm:
+
+	[bed]
+	x = 235
+	y = 235
+	keep_out = 0,0,20,10 215,0,235,10
+
+With -level, each job starts by homing and probing a new mesh (G28, G29)
+ahead of the file's own start code, for slicer profiles that leave leveling
+out. The [level] section of the config file says how to level each printer
+instead, by name (the name given to the daemon, or the base name of the COM
+port), e.g. to load the mesh saved in EEPROM:
+
+	[level]
+	ender = M420 S1
+	prusa = "G80\nG81"
+
+With -prime, each job starts with a prime line along the front of the bed,
+drawn there and back after heating up, so small parts need no skirt. The
+[prime] section of the config file gives the bed size and temperatures
+(220x220mm and the PLA preset by default), or GCode of its own, where
+{bed_x}, {bed_y}, {temp} and {bed_temp} are filled in:
+
+	[prime]
+	bed_x = 235
+	bed_y = 235
+	temp = 210
+
+The "jog mode" option pauses the job and moves the head with the keyboard, for
+setting up a printer without a screen: the arrow keys move X and Y, PgUp and
+PgDn move Z, 1 to 4 set the step to 0.1, 1, 10 or 100mm, h homes, x, y and z
+home one axis, and q leaves jog mode. Pressing j does the same when nothing is
+printing or the job is paused, including in "dripp3r attach".
+
+In jog mode, e and r extrude and retract filament to load or unload it, once
+the hotend is at least 170°C. They move 50mm at 300mm/min, unless the config
+file says otherwise:
+
+	[jog]
+	extrude_length = 100
+	extrude_feed = 180
+
+Pressing z sets the Z offset of a bed probe with a sheet of paper, as on the
+printer's screen but easier: the printer homes and puts the nozzle where it
+thinks Z0 is. Down and Up lower and raise it by 0.01mm, PgDn and PgUp by
+0.1mm, until the paper just drags. Then s adds the distance moved to the
+offset (M851) and saves it to EEPROM (M500), and q leaves.
+
+The "filament" option changes the filament: the job is paused, the head is
+lifted 10mm and parked at X0 Y0, and the printer beeps (M300). Then u unloads
+100mm of filament, l loads 100mm of the new one, e purges 10mm more, and c
+carries on with the job where it left off. With -host-m600, the same happens
+when the file says M600, for firmware built without ADVANCED_PAUSE, which
+would not understand it. The [change] section of the config file moves the
+parking spot and the lengths:
+
+	[change]
+	lift = 20
+	park_x = 10
+	park_y = 200
+	unload_length = 400
+	load_length = 380
+
+When the printer's filament sensor trips, firmware with host action commands
+says so (//action:out_of_filament) and the runout hook runs. If the firmware
+leaves the pause to the host (//action:pause), the filament is changed as
+above. If it pauses by itself (//action:paused), it waits for the new filament
+to be loaded from its screen, and dripp3r says so until it carries on.
+
+The "skip object" option stops printing one object of a job, e.g. one that
+came off the bed, while the others carry on. It needs a file with labelled
+objects: PrusaSlicer's "; printing object" comments, Cura's ";MESH:" comments,
+Klipper's EXCLUDE_OBJECT commands or Marlin's M486. From then on the object's
+moves are left out, and after each of its parts the printer is put at the
+height and extruder position the job expects. "dripp3r exclude" lists the
+objects of the daemon's job, and "dripp3r exclude NAME" skips one.
+
+The "e-steps" option calibrates the extruder's steps per mm. It pauses the
+job, heats the hotend for PLA and asks for the filament to be marked 120mm
+above the extruder. Pressing g extrudes 100mm; then type how far is left to
+the mark and press Enter. The new steps per mm are set (M92 E) and s saves them
+to EEPROM (M500), and q leaves.
 
 The "list" option will list all known COM ports in an obscure fashion.
 
+With -repeat N, each job is printed N times over, or with -repeat 0 until it
+is stopped, e.g. on a belt printer or one that pushes its parts off the bed.
+The [repeat] section of the config file gives the GCode sent between runs:
+
+	[repeat]
+	between = "M190 R30\nG28\nG1 Z1 F600\nG1 Y220 F3000"
+
+With -timelapse dir, a frame is taken at each layer change, for a timelapse of
+the print without OctoPrint. Each job's frames are saved as frame-00001.jpg
+and so on in a directory of their own under dir, named for the job and when it
+started. The [timelapse] section of the config file says how to take them:
+from a webcam's snapshot url, or with a command, given the path of the frame
+as {frame} and the layer number as {layer}:
+
+	[timelapse]
+	url = http://octopi.local/webcam/?action=snapshot
+	# command = "fswebcam -q --no-banner {frame}"
+
+So the head is out of the way in every frame, as with Octolapse, it can be
+parked for each one with the same settings as [pause] above: retract, prime,
+lift, park_x and park_y. The job waits while the frame is taken, then the head
+goes back and carries on.
+
+When a job is done, the render command turns its frames into a video named
+for their directory, with {frames} replaced by the directory and {video} by
+the video's path. Where the video went is logged with the job's summary.
+
+	render = "ffmpeg -y -loglevel error -framerate 25 -i {frames}/frame-%05d.jpg -pix_fmt yuv420p {video}"
+
+Sending SIGTERM (as systemd and Docker do) shuts down gracefully: the GCode in
+flight is finished, the stop sequence is dripped, and then the program exits.
+A second SIGTERM exits without waiting for the stop sequence.
+
 At the end of execution, the elapsed time it took to send GCode over the
-serial port is shown.
+serial port is shown, and the exit status tells how the print ended:
+
+	0  completed: the whole file was dripped
+	1  failed for another reason, e.g. the GCode file could not be read
+	2  bad arguments
+	3  aborted: stopped or aborted from the menu, or by SIGTERM
+	4  firmware error: the printer halted, e.g. on thermal runaway
+	5  serial error: the port could not be opened, read or written
+
+The daemon exits with 0 when stopped by SIGTERM.
+
+Running "dripp3r daemon [COM port]" keeps the printer connected and listens on
+a local control channel instead of reading a file. This is a Unix domain socket
+in $XDG_RUNTIME_DIR (or a dripp3r-<uid> directory of the user's own in the
+temp dir) on Linux/macOS and the named pipe \\.\pipe\dripp3r on Windows,
+unless -socket is given. Only the user who started the daemon may use it. The
+channel speaks JSON-RPC 2.0, one message per line:
+
+	{"jsonrpc":"2.0","id":1,"method":"print","params":{"path":"/tmp/part.gcode"}}
+	{"jsonrpc":"2.0","id":1,"result":"printing /tmp/part.gcode"}
+
+The methods are:
+
+	print {path}   start printing the file, or queue it behind the current job
+	queue {path}   same as print
+	gcode {gcode}  send a single GCode ahead of the job
+	raw {gcode}    send it as it is, without macros, aliases or : and @ commands
+	macro {name}   run a macro from the config file
+	preheat {name} heat up to a preset's temperatures
+	cooldown       turn off the heaters and the fan
+	settings       list the printer's settings as GCode, from M503
+	probe-test     home and test the probe's repeatability with M48
+	objects        list the labelled objects of the job so far
+	exclude {name} stop printing an object for the rest of the job
+	key {name}     press a hotkey, e.g. "p" to pause or resume
+	pause          pause the job at the next travel move (see above)
+	resume         continue a paused job
+	cancel         stop the job and drip the stop GCodes
+	status         report the state, current job, queue length, temperatures
+	               and overrides
+	printers       list the names of the printers
+	monitor        stream "console", "temps" and "status" notifications
+	               (read-only)
+	attach         stream notifications and keep accepting requests
+
+The same commands can be run from scripts or cron jobs as subcommands, which
+print the reply and exit with status 1 if the daemon answered with an error:
+
+	dripp3r status
+	dripp3r pause
+	dripp3r resume
+	dripp3r cancel
+	dripp3r queue add part.gcode
+	dripp3r gcode M104 S0
+	dripp3r preheat petg
+
+Any number of terminals can watch the daemon with "dripp3r monitor", which
+prints the console and temperatures but cannot send anything to the printer.
+In a terminal, the status line (state, progress, temperatures and any
+overrides) stays at the bottom while the console scrolls above it, as it
+does when printing or attached, and as it also does with the keyboard
+redirected.
+The daemon polls temperatures with M105 every few seconds.
+
+"dripp3r attach" is the interactive client for a daemon. It shows the console
+like a monitor, and pressing Ctrl-C brings up a menu to pause, continue or stop
+the job, enter hacker mode, or detach. Detaching, closing the terminal or
+losing an SSH connection leaves the job running; attach again at any time.
+
+One daemon can drive several printers: "dripp3r daemon prusa=COM3 ender=COM4".
+Each console line is then prefixed with the printer name, and requests need a
+"printer" param (-p on the command line) to say which printer they are for.
+"dripp3r status" without -p lists every printer. "dripp3r monitor -split"
+gives each printer its own pane instead of interleaving their output.
+
+With -moonraker, e.g. "dripp3r daemon -moonraker :7125 COM3", the daemon also
+serves a small part of Moonraker's API, so that Mainsail, Fluidd and other
+Klipper front ends can watch a Marlin printer and start, pause, resume and
+cancel its jobs. Printer objects can be queried over HTTP
+(/printer/objects/query) or subscribed to over the WebSocket (/websocket),
+which also carries the console's responses. Jobs are started by file name
+from the [moonraker] gcodes directory in the config file, which
+/server/files/list lists; nothing outside it can be printed. Over HTTP,
+the methods that start, pause, resume or cancel a job or send GCode must be
+POSTed, and /printer/gcode/script takes only GCode, not dripp3r's own : and
+@ commands. WebSockets are only taken from web pages served by the daemon
+at its own IP address or localhost, or from the [moonraker] origins, e.g.
+where Mainsail is served from, or the daemon's own under a host name.
+When the daemon drives several printers, name the one to serve: -moonraker
+prusa=:7125.
+
+With -grpc, e.g. "dripp3r daemon -grpc :50051 COM3", the daemon also serves
+a gRPC API, for native front ends that would rather call typed methods than
+speak JSON-RPC on the control socket: StartJob, Pause, Resume, Cancel,
+GetStatus and StreamConsole, which streams the console, temperatures and
+status as a monitor gets them. Each request names its printer when the
+daemon drives several. The service is defined in dripp3rpb/dripp3r.proto,
+and Go programs can import the package generated from it,
+github.com/juster/dripp3r/dripp3rpb. StartJob prints files from the
+[http] uploads directory, such as those sent with -http, by their paths
+there.
+
+With -http, e.g. "dripp3r daemon -http :8080 COM3", the daemon takes GCode
+files over HTTP: POST /print saves the file to the [http] uploads directory
+(by default in the user's cache directory) and prints it, or queues it if a
+job is printing, so that slicers' post-processing scripts and network
+shares can send jobs straight to the printer. The file is the request's
+body, named by ?name=, or the first file of a multipart form; ?printer=
+names the printer when the daemon drives several. A file already there
+under the same name is only replaced with ?overwrite=1; otherwise the
+upload is refused with 409 Conflict. Files over [http] max_upload, 256MB
+unless set, are refused with 413:
+
+	curl -H "X-Api-Key: $KEY" -F file=@part.gcode http://printer:8080/print
+	curl -H "X-Api-Key: $KEY" --data-binary @part.gcode \
+		"http://printer:8080/print?name=part.gcode"
+
+With -prusalink, e.g. "dripp3r daemon -prusalink :8081 COM3", the daemon
+also serves the part of PrusaLink's API that PrusaSlicer uses, so a
+"PrusaLink" physical printer in PrusaSlicer, with the [api] key as its API
+key, can send it jobs with "Upload and Print". Uploads go to the [http]
+uploads directory. The status (/api/v1/status), the job (/api/v1/job),
+pausing, resuming and stopping it, and the storage list are there too, for
+other PrusaLink clients. Only API keys are taken, not PrusaLink's
+passwords. When the daemon drives several printers, name the one to serve:
+-prusalink mk3=:8081.
+
+The [api] section of the config file protects all four APIs. Without a key,
+they are served only on the loopback address, e.g. -http localhost:8080,
+and only to watch the printer, since any web page open on the machine could
+reach them: starting, pausing, resuming or cancelling jobs and sending
+GCode take a key. With one, every request must send a key: as the
+X-Api-Key header over HTTP, as x-api-key metadata over gRPC, and as the
+api_key of server.connection.identify on Moonraker's WebSocket, before
+anything else.
+The [api keys] section names more keys, each with its role: "control" keys
+can do anything the [api] key can, while "monitor" keys, e.g. for a status
+display on the wall, can watch the printer but not start, pause or cancel
+jobs or send it GCode:
+
+	[api keys]
+	wall = monitor 5d41402abc4b2a76
+	slicer = control 7d793037a0760186
+
+Every request that uploads a file, starts, pauses, resumes or cancels a
+job or sends GCode is shown on the printer's console, and so goes to its log file and
+monitors, with who made it, from where and when:
+
+	-- API pause by slicer@192.168.1.20 over gRPC at 2024-05-04 18:30:12
+
+Each client, by key and address, may make five such requests at once and
+then one every two seconds, or as many a minute as [api] rate says; more are
+turned away with 429 Too Many Requests over HTTP, or ResourceExhausted over
+gRPC, so that a client gone haywire can't tie up the printer.
+
+For TLS, give the cert and cert_key files, or set tls = self-signed to have
+the daemon make a certificate once and keep it in the user's config
+directory. The daemon logs the certificate's SHA-256 fingerprint at start,
+to check against what clients are shown.
+
+The daemon runs happily as a systemd service: it stays in the foreground,
+reports readiness and answers the watchdog when started from a Type=notify
+unit, and leaves timestamps to journald. See contrib/dripp3r.service.
+
+On Windows, "dripp3r service install COM3" registers the daemon as a service
+that starts with Windows, and "dripp3r service remove" unregisters it. The
+service logs to %ProgramData%\dripp3r\dripp3r.log, and any interactive user
+can reach it with "dripp3r attach" over the named pipe.
 */
 package main
 
 import (
 	"bufio"
 	"bytes"
+	"errors"
+	"flag"
 	"fmt"
 	"go.bug.st/serial"
 	"go.bug.st/serial/enumerator"
@@ -47,6 +750,11 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -62,6 +770,42 @@ M84 Z E
 `)
 )
 
+// Exit codes, so wrapper scripts can tell how a print ended.
+const (
+	exitCompleted = 0 // the file was dripped to the end
+	exitFailed    = 1 // anything else, e.g. the GCode file could not be read
+	exitUsage     = 2 // bad arguments
+	exitAborted   = 3 // the user stopped or aborted the job
+	exitFirmware  = 4 // the printer reported a fatal error
+	exitSerial    = 5 // the serial port could not be opened, read or written
+)
+
+var (
+	errAborted  = errors.New("aborted")
+	errFirmware = errors.New("firmware error")
+	errSerial   = errors.New("serial error")
+)
+
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitCompleted
+	case errors.Is(err, errAborted):
+		return exitAborted
+	case errors.Is(err, errFirmware):
+		return exitFirmware
+	case errors.Is(err, errSerial):
+		return exitSerial
+	}
+	return exitFailed
+}
+
+// die logs err and exits with code.
+func die(code int, err error) {
+	log.Print(err)
+	os.Exit(code)
+}
+
 type ctrlChoice int
 
 const (
@@ -69,36 +813,239 @@ const (
 	ctrlStop
 	ctrlAbort
 	ctrlHackerMode
+	ctrlMacro
+	ctrlPreheat
+	ctrlCooldown
+	ctrlEndstops
+	ctrlSettings
+	ctrlJog
+	ctrlESteps
+	ctrlChange
+	ctrlExclude
 )
 
 func usage() {
-	fmt.Printf("usage: %s [COM port] [Gcode path]\n", os.Args[0])
-	os.Exit(2)
+	fmt.Printf("usage: %s [flags] [COM port] [Gcode path or -]\n", os.Args[0])
+	fmt.Printf("       %s daemon [-socket path] [-moonraker [name=]address] [-grpc address] [-http address] [-prusalink [name=]address] [-mock [-timed n]] [flags] [[name=]COM port ...]\n", os.Args[0])
+	fmt.Printf("       %s -dry-run|-mock [-timed n] [flags] [Gcode path or -]\n", os.Args[0])
+	fmt.Printf("       %s replay -as-printer dump [flags] [Gcode path or -]\n", os.Args[0])
+	fmt.Printf("       %s preview [-layer n | -bed] [-ascii] [-config path] [Gcode path]\n", os.Args[0])
+	fmt.Printf("       %s service install [daemon args] | service remove\n", os.Args[0])
+	fmt.Printf("       %s status|pause|resume|cancel|cooldown [-socket path] [-p name]\n", os.Args[0])
+	fmt.Printf("       %s monitor [-socket path] [-p name] [-split]\n", os.Args[0])
+	fmt.Printf("       %s attach [-socket path] [-p name]\n", os.Args[0])
+	fmt.Printf("       %s queue [-socket path] [-p name] add [Gcode path]\n", os.Args[0])
+	fmt.Printf("       %s gcode [-socket path] [-p name] [Gcode line]\n", os.Args[0])
+	fmt.Printf("       %s macro [-socket path] [-p name] [macro name]\n", os.Args[0])
+	fmt.Printf("       %s preheat [-socket path] [-p name] [preset]\n", os.Args[0])
+	fmt.Printf("       %s eeprom [-socket path] [-p name] dump [file] | restore [file]\n", os.Args[0])
+	fmt.Printf("       %s probe-test [-socket path] [-p name]\n", os.Args[0])
+	fmt.Printf("       %s exclude [-socket path] [-p name] [object name]\n", os.Args[0])
+	fmt.Println("flags:")
+	flag.PrintDefaults()
+	os.Exit(exitUsage)
+}
+
+// dripFlags adds the flags for how lines are dripped, which the daemon
+// shares.
+func dripFlags(flags *flag.FlagSet) {
+	flags.StringVar(&config_path, "config", config_path,
+		"read settings from the config file at `path`")
+	flags.DurationVar(&retry_for, "retry", 10*time.Second,
+		"keep trying to open a busy or missing serial port for `duration`")
+	flags.DurationVar(&connect_timeout, "connect-timeout", 10*time.Second,
+		"give up on a printer that doesn't answer for `duration` on connecting")
+	flags.DurationVar(&read_timeout, "read-timeout", 0,
+		"give up on a printer that says nothing for `duration` with a line "+
+			"unanswered")
+	flags.BoolVar(&no_checksum, "no-checksum", false,
+		"send lines without line numbers and checksums")
+	flags.StringVar(&firmware, "firmware", firmware,
+		"talk to the printer as `firmware` does: marlin, marlin1, prusa, grbl, "+
+			"klipper, reprap, repetier or smoothie, or auto to ask it with M115")
+	flags.StringVar(&stream, "stream", "",
+		"send lines ahead by counting `chars` against the printer's buffer, "+
+			"or by lines answered")
+	flags.StringVar(&machine, "machine", machine,
+		"the `kind` of machine: printer, laser or cnc")
+	flags.BoolVar(&terse, "q", false,
+		"show none of the traffic with the printer, only the status and messages")
+	flags.BoolVar(&verbose, "v", false,
+		"show all the traffic with the printer, even polling and oks")
+	flags.BoolVar(&json_out, "json", false,
+		"write every event to stdout as a line of JSON, for other programs")
+	flags.BoolVar(&trace, "trace", false,
+		"show all the traffic, and hex-dump the bytes on the wire")
+	flags.StringVar(&dump_raw, "dump-raw", "",
+		"record the bytes to and from the printer in `file`")
+	flags.StringVar(&timestamps, "timestamps", "",
+		"stamp lines to and from the printer with the `clock` time, or the job's")
+	flags.StringVar(&snapshot_cmd, "snapshot", "",
+		"shell `command` run for @snapshot")
+	flags.BoolVar(&first_layer, "first-layer", false,
+		"hide the GCode on the first layer, to adjust it with the hotkeys")
+	flags.BoolVar(&level_bed, "level", false,
+		"home and level the bed before each job")
+	flags.BoolVar(&prime, "prime", false,
+		"draw a prime line before each job")
+	flags.BoolVar(&host_m600, "host-m600", false,
+		"change filament here on M600, for firmware without ADVANCED_PAUSE")
+	flags.BoolVar(&check_mesh, "check-mesh", false,
+		"check the bed leveling mesh before each job")
+	flags.BoolVar(&parallel_heat, "parallel-heat", false,
+		"heat the bed and hotend at once when the start code waits for one first")
+	flags.BoolVar(&check_footprint, "check-footprint", false,
+		"check the first layer of each job stays on the bed")
+	flags.IntVar(&repeat, "repeat", 1,
+		"print each job `n` times, or endlessly with 0")
+	flags.StringVar(&timelapse_dir, "timelapse", "",
+		"take a timelapse frame at each layer change, saved under `dir`")
+	flags.BoolVar(&allow_run, "allow-run", false,
+		"let @run in GCode files run shell commands")
+	flags.StringVar(&script_path, "script", "",
+		"load event handlers from the Starlark script at `path`")
 }
 
 func main() {
-	if len(os.Args) != 3 {
+	if len(os.Args) > 1 {
+		switch cmd := os.Args[1]; cmd {
+		case "daemon":
+			os.Exit(daemonMain(os.Args[2:]))
+		case "service":
+			serviceMain(os.Args[2:])
+			return
+		case "preview":
+			previewMain(os.Args[2:])
+			return
+		case "status", "pause", "resume", "cancel", "queue", "gcode",
+			"macro", "preheat", "cooldown", "eeprom", "probe-test", "exclude",
+			"monitor", "attach":
+			ctlMain(cmd, os.Args[2:])
+			return
+		}
+	}
+	flags, args := flag.CommandLine, os.Args[1:]
+	if len(args) > 0 && args[0] == "replay" {
+		// The printer is played back from a dump, in place of the COM port.
+		flags = flag.NewFlagSet("replay", flag.ExitOnError)
+		flags.StringVar(&replay_dump, "as-printer", "",
+			"play the printer's side of the -dump-raw `file`")
+		args = args[1:]
+	} else {
+		flags.StringVar(&thumbnail_path, "thumbnail", "",
+			"save the thumbnail of a 3MF/UFP file to `path`")
+		flags.BoolVar(&dry_run, "dry-run", false,
+			"run the job against a stand-in that answers ok, without a printer")
+		flags.Float64Var(&timed, "timed", 0,
+			"on a dry run or -mock, answer lines as the printer would, `n` times faster")
+		flags.BoolVar(&mock, "mock", false,
+			"drive a virtual Marlin printer, for demos and trying things out")
+	}
+	dripFlags(flags)
+	flags.Usage = usage
+	flags.Parse(args)
+	if err := readConfig(); err != nil {
+		die(exitUsage, err)
+	}
+	args = flags.Args()
+	switch {
+	case replay_dump != "":
+		args = append([]string{replay_dump}, args...)
+	case dry_run:
+		args = append([]string{"dry-run"}, args...)
+	case mock:
+		args = append([]string{mock_port}, args...)
+	}
+	if len(args) != 2 {
 		usage()
 	}
+	if err := checkRepeat(args[1]); err != nil {
+		die(exitUsage, err)
+	}
+	if err := checkTimelapse(); err != nil {
+		die(exitUsage, err)
+	}
+	if err := checkConsole(); err != nil {
+		die(exitUsage, err)
+	}
+	if err := checkDump(); err != nil {
+		die(exitUsage, err)
+	}
+	if err := checkTimed(); err != nil {
+		die(exitUsage, err)
+	}
+	if err := checkFirmware(); err != nil {
+		die(exitUsage, err)
+	}
+	if err := checkMachine(); err != nil {
+		die(exitUsage, err)
+	}
 
-	port, err := serial.Open(os.Args[1], serial_mode)
+	f, err := openGCode(args[1])
 	if err != nil {
-		log.Fatal(err)
+		die(exitFailed, err)
+	}
+	// GCode can be piped in, in which case the keyboard is the terminal.
+	// With -json there is no one at the keyboard.
+	keyboard := os.Stdin
+	if json_out {
+		keyboard = nil
+	} else if args[1] == "-" {
+		if keyboard, err = openTerminal(); err != nil {
+			log.Print("No keyboard, the menu is unavailable: ", err)
+		}
 	}
-	defer port.Close()
 
-	f, err := os.Open(os.Args[2])
+	var port io.ReadWriteCloser
+	switch {
+	case replay_dump != "":
+		port, err = openReplay(replay_dump)
+	case dry_run:
+		port = newAckPort()
+	default:
+		port, err = openPort(args[0])
+	}
 	if err != nil {
-		log.Fatal(err)
+		die(exitSerial, err)
 	}
 
-	d := newDripper(port, f)
+	d := newDripper(port, newConsole())
+	d.name = filepath.Base(args[0])
+	if err := d.readProfile(); err != nil {
+		die(exitUsage, err)
+	}
+	if err := d.startExtensions(); err != nil {
+		die(exitUsage, err)
+	}
+	d.job_name = args[1]
+	d.jobs++
+	d.scanTotals()
+	d.gcode_file, d.gcode_err = d.jobLines(f)
+	d.gcode = d.gcode_file
+	if keyboard != nil {
+		d.user_input, d.input_err = userInput(keyboard)
+	} else {
+		newStatusLine()
+	}
 	d.loop()
+	sim.report(d.con)
+	port.Close()
+	closeEditor()
+	os.Exit(exitCode(d.err))
 }
 
-func userInput(f *os.File) <-chan string {
+// userInput reads lines typed on f, with the line editor if f is a
+// terminal. If reading fails the error is sent on the second channel and the
+// first is closed.
+func userInput(f *os.File) (<-chan string, <-chan error) {
+	if e := newEditor(f); e != nil {
+		return e.run(f)
+	}
+	newStatusLine()
 	out := make(chan string)
+	errc := make(chan error, 1)
 	go func() {
+		defer close(out)
 		// On Windows/cmd.exe, Stdin will give io.EOF when CTRL-C is used
 		// So we need to make a new Scanner.
 		for {
@@ -107,11 +1054,12 @@ func userInput(f *os.File) <-chan string {
 				out <- s.Text()
 			}
 			if err := s.Err(); err != nil {
-				log.Fatal(err)
+				errc <- err
+				return
 			}
 		}
 	}()
-	return out
+	return out, errc
 }
 
 func flushUserInput(in <-chan string) {
@@ -125,42 +1073,91 @@ Loop:
 	}
 }
 
-func gcodeLines(f *os.File) <-chan []byte {
+// gcodeLines sends each GCode in f without comments or blank lines, except
+// for host commands. If
+// reading fails the error is sent on the second channel before the first is
+// closed.
+func gcodeLines(f io.ReadCloser) (<-chan []byte, <-chan error) {
 	r := bufio.NewReader(f)
 	out := make(chan []byte)
+	errc := make(chan error, 1)
 	go func() {
 		var s []byte
 		var err error
 		defer f.Close()
 		defer close(out)
+		// Some editors start files with a UTF-8 byte order mark.
+		if bom, _ := r.Peek(3); bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+			r.Discard(3)
+		}
 		for err == nil {
+			// ReadBytes has no line length limit, unlike a Scanner.
 			s, err = r.ReadBytes('\n')
 			if len(s) == 0 {
 				break
 			}
-			if i := bytes.IndexByte(s, ';'); i >= 0 {
-				s = s[:i]
-			}
-			s = bytes.TrimSpace(s)
-			if len(s) == 0 {
-				continue
+			// Old Mac files end lines with a lone CR; TrimSpace
+			// takes care of the CR in DOS CRLF endings.
+			for _, ln := range bytes.Split(s, []byte{'\r'}) {
+				ln = bytes.TrimSpace(ln)
+				if cmd := hostComment(ln); cmd != nil {
+					out <- cmd
+					continue
+				}
+				if i := bytes.IndexByte(ln, ';'); i >= 0 {
+					ln = ln[:i]
+				}
+				ln = stripLineNumber(bytes.TrimSpace(ln))
+				if len(ln) == 0 {
+					continue
+				}
+				out <- ln
 			}
-			out <- s
 		}
 		if err != io.EOF {
-			log.Fatal(err)
+			errc <- err
 		}
 	}()
-	return out
+	return out, errc
+}
+
+// jobLines reads the lines of a job's file, as gcodeLines does, with the
+// heaters set in parallel if -parallel-heat says.
+func (d *dripper) jobLines(f io.ReadCloser) (<-chan []byte, <-chan error) {
+	lines, errc := gcodeLines(f)
+	if parallel_heat {
+		lines = heatInParallel(lines, d.con)
+	}
+	return lines, errc
 }
 
-func serialRecv(scan *bufio.Scanner) (lines []string, err error) {
+// Longest line the printer is expected to send. Longer lines, e.g. garbage
+// at the wrong baud rate, are split instead of stopping the Scanner.
+const max_recv_line = 4096
+
+func scanRecvLines(data []byte, atEOF bool) (int, []byte, error) {
+	adv, tok, err := bufio.ScanLines(data, atEOF)
+	if adv == 0 && err == nil && len(data) >= max_recv_line {
+		return max_recv_line, data[:max_recv_line], nil
+	}
+	return adv, tok, err
+}
+
+func serialRecv(scan *bufio.Scanner, fw *dialect, action func(string)) (lines []string, err error) {
 	for scan.Scan() {
-		ln := scan.Text()
-		switch ln {
-		case "ok":
+		// Line noise can leave NULs and stray whitespace around an ok.
+		ln := strings.Trim(scan.Text(), " \t\r\x00")
+		switch {
+		case isAction(ln) || fw.isReport(ln):
+			// The printer may be waiting for us, so this can't wait for the
+			// ok.
+			action(ln)
+		case ln == "ok":
 			return lines, nil
-		case "":
+		case fw.endsReply(ln):
+			// Replies like "ok T:210.0 /210.0" carry data after the ok.
+			return append(lines, ln), nil
+		case ln == "":
 		default:
 			lines = append(lines, ln)
 		}
@@ -172,74 +1169,437 @@ func serialRecv(scan *bufio.Scanner) (lines []string, err error) {
 	return lines, err
 }
 
-func serialRecvChan(r io.Reader) <-chan error {
-	out := make(chan error)
+// response holds the lines the printer sent up to and including an ok, or
+// an action line or report, which comes on its own.
+type response struct {
+	lines  []string
+	err    error
+	action string
+}
+
+// serialRecvChan reads responses from the printer and publishes each line,
+// quiet if quiet is set because they will be shown some other way.
+func serialRecvChan(r io.Reader, bus *eventBus, quiet *atomic.Bool, talk *atomic.Pointer[dialect]) <-chan response {
+	out := make(chan response)
 	go func() {
 		scan := bufio.NewScanner(r)
+		scan.Split(scanRecvLines)
 		defer close(out)
 		// prime the pump
-		out <- nil
+		out <- response{}
+		action := func(ln string) {
+			// Repetier says wait every second while idle, and the
+			// temperatures reported go on the status line.
+			bus.publish(responseReceived{ln, ln == "wait" || strings.HasPrefix(ln, "T:")})
+			out <- response{action: ln}
+		}
 		var err error
 		for err == nil {
 			var res []string
-			res, err = serialRecv(scan)
-			if len(res) > 0 {
-				for _, ln := range res {
-					fmt.Printf("<< %s\n", ln)
-				}
+			// -firmware auto may change the dialect between responses.
+			fw := talk.Load()
+			res, err = serialRecv(scan, fw, action)
+			// Errors are shown even when the response is not.
+			for _, ln := range res {
+				bus.publish(responseReceived{ln, quiet.Load() &&
+					!strings.HasPrefix(ln, "Error:") && !strings.HasPrefix(ln, "!!")})
 			}
-			out <- err
+			// A bare ok isn't among the lines.
+			if err == nil && (len(res) == 0 || !fw.endsReply(res[len(res)-1])) {
+				bus.publish(responseReceived{"ok", true})
+			}
+			out <- response{lines: res, err: err}
 		}
 	}()
 	return out
 }
 
-func serialSendChan(port io.Writer) chan<- []byte {
+// serialSendChan writes each line sent on the returned channel to port,
+// publishing it first, quiet if hush is set. The first write error is sent on
+// the second channel; later lines are dropped.
+func serialSendChan(port io.Writer, bus *eventBus, hush *atomic.Bool) (chan<- []byte, <-chan error) {
 	// Port reads are buffered but writes do not use bufio.
 	// Give chan a buffer of 1 to avoid blocking in drip loop.
 	in := make(chan []byte, 1)
+	errc := make(chan error, 1)
 	go func() {
+		var err error
 		for {
 			line, ok := <-in
 			if !ok {
 				return
 			}
-			fmt.Printf(">> %s\n", line)
-			port.Write(line)
-			port.Write([]byte{'\n'})
+			if err != nil {
+				continue
+			}
+			bus.publish(lineSent{string(line), hush.Load()})
+			if _, err = port.Write(append(line, '\n')); err != nil {
+				errc <- err
+			}
 		}
 	}()
-	return in
+	return in, errc
 }
 
 type dripper struct {
-	gcode_file   <-chan []byte
-	serial_send  chan<- []byte
-	serial_ready <-chan error
-	user_input   <-chan string
-	sig_chan     chan os.Signal
-	hack_queue   []string
-	ready        bool
+	name          string // the printer's name, or its port's
+	gcode_file    <-chan []byte
+	gcode_err     <-chan error
+	gcode         <-chan []byte // current source: the file, stop codes, or nil
+	side          <-chan []byte // a file sent with :run, ahead of gcode
+	side_err      <-chan error
+	port          io.Writer // for real-time bytes; lines go by serial_send
+	fw            *dialect
+	serial_send   chan<- []byte
+	send_err      <-chan error
+	serial_ready  <-chan response
+	user_input    <-chan string
+	input_err     <-chan error
+	sig_chan      chan os.Signal
+	ctl_chan      chan ctlRequest
+	waiting       map[string][]chan ctlReply // calls waiting for a GCode's answer
+	stopped       chan struct{}              // closed when the loop ends
+	temp_tick     <-chan time.Time
+	con           *console
+	bus           *eventBus // the printer's events, for con and the hooks
+	temps         temps
+	heat          *heatWait      // while M109 or M190 waits
+	totals        jobTotals      // the job's lines and layers, if known
+	scan          <-chan jobScan // while the job's file is being scanned
+	jobs          int            // jobs started, the last one's id
+	est           *estimator     // follows the lines sent, for the time left
+	est_done      time.Duration
+	est_heat      time.Duration // waited for the heaters since the first line
+	printing_at   time.Time     // when the first line of the file was sent
+	heating       string        // preset being preheated to, if any
+	heat_to       temps         // its targets
+	heat_ticker   *time.Ticker  // polls temperatures while preheating
+	hack_queue    []string
+	job_name      string
+	job_queue     []string
+	sent          int    // lines of the current file sent
+	layer         int    // layer of the current file being printed
+	runs          int    // times the current file was printed, with -repeat
+	frames        int    // timelapse frames taken of the current job
+	frames_dir    string // where they are saved
+	snapping      bool   // parked for a timelapse frame
+	snap_done     chan error
+	object        string // the object being printed, if labelled
+	objects       []string
+	excluded      map[string]bool // objects not to print
+	jogging       bool
+	jog_step      float64
+	mesh_check    bool    // waiting for M420 V before the job
+	changing      bool    // changing filament
+	runout        bool    // the printer said the filament ran out
+	fw_paused     bool    // the printer is waiting for its button
+	fw_state      string  // the state the firmware reports, if it does
+	first_layer   bool    // on the first layer, with -first-layer
+	zoffsetting   bool    // setting the probe's Z offset
+	zoffset       float64 // the probe's Z offset (M851)
+	zoffset_known bool
+	zoffset_moved float64 // since the offset was last saved
+	zoffset_saved bool
+	esteps        int     // step of the e-steps calibration, or estepsOff
+	esteps_old    float64 // the extruder's steps per mm (M92)
+	esteps_new    float64
+	babystep      float64 // total babystepped this run
+	feedrate      int     // feedrate override in percent (M220)
+	flow          int     // flow override in percent (M221)
+	fan           int     // part cooling fan speed, 0 to 255
+	tool          string  // the M3 or M4 the laser or spindle is on with
+	relative_e    bool    // extruder moves are relative (M83)
+	relative_xyz  bool    // moves are relative (G91)
+	feed          float64 // speed of the last move, in mm/min
+	pos           position
+	hold          *held // where the job left off while the head is moved
+	last_status   string
+	checksum      bool
+	wco           position       // Grbl's work coordinate offset
+	line_no       int            // number of the last line sent
+	in_flight     [][]byte       // lines waiting for their ok, unnumbered
+	rx_buffer     int            // bytes sent ahead when counting characters
+	cmd_size      int            // bytes the longest line takes, or 0
+	keepalive     int            // seconds between busy messages, or 0
+	heard_at      time.Time      // when the printer last said anything
+	silent        bool           // warned that the printer said nothing
+	rx            []int          // sizes of the lines in the printer's buffer
+	backlog       [][]byte       // lines waiting for room in it
+	quiet         atomic.Bool    // the response is shown by answered
+	hush          atomic.Bool    // the line sent isn't shown
+	history       map[int][]byte // recent numbered lines, for resends
+	resend_from   int            // next line to send again, or 0
+	sent_at       time.Time      // when the last line was sent
+	win           window         // how many lines are sent ahead
+	running       sync.WaitGroup // host commands run in the background
+	script        *script
+	plugins       []*plugin
+	ready         bool
+	paused        bool
+	holding       bool                    // in a feed hold
+	booting       bool                    // waiting for the firmware to greet us
+	settling      bool                    // waiting for its start-up messages to end
+	shaking       bool                    // waiting for M115's answer before the job
+	boot_at       time.Time               // when the handshake's current step began
+	greeted_at    time.Time               // when the firmware greeted us
+	heard         bool                    // the printer has answered a line
+	identifying   bool                    // waiting for M115 to tell the firmware's dialect
+	recv_fw       atomic.Pointer[dialect] // the dialect responses are read in
+	halted        bool                    // waiting for the firmware's halt to be cleared
+	pause_at      string                  // pausing at the next "travel" move or "layer"
+	daemon        bool
+	stopping      bool  // shutting down after the stop GCodes
+	err           error // why the loop stopped, if it failed
+}
+
+func newDripper(port io.ReadWriter, con *console) *dripper {
+	d := &dripper{
+		sig_chan: make(chan os.Signal, 1),
+		ctl_chan: make(chan ctlRequest),
+		stopped:  make(chan struct{}),
+		con:      con,
+		bus:      &eventBus{},
+		checksum: !no_checksum && startDialect().checksum,
+		jog_step: 1,
+		win:      window{size: 1, most: max_window},
+		feedrate: 100,
+		flow:     100,
+		history:  make(map[int][]byte),
+		waiting:  make(map[string][]chan ctlReply),
+		ready:    false,
+	}
+	d.snap_done = make(chan error, 1)
+	// Lines sent over HTTP can't be garbled on the way.
+	if _, ok := port.(*duetConn); ok {
+		d.checksum = false
+	}
+	if dump_file != nil {
+		port = newDumper(port)
+	}
+	if trace {
+		port = tracer{port, con}
+	}
+	d.port = port
+	d.fw = startDialect()
+	d.recv_fw.Store(d.fw)
+	d.bus.subscribe(con.showEvent)
+	d.bus.subscribe(d.runHooks)
+	d.serial_ready = serialRecvChan(port, d.bus, &d.quiet, &d.recv_fw)
+	d.serial_send, d.send_err = serialSendChan(port, d.bus, &d.hush)
+	return d
+}
+
+// send sends a line, numbered and checksummed unless that is turned off.
+// M110 goes out as is, since it sets the number of the next line. A line too
+// long for the printer is shortened if it can be (see fitLine). With hush,
+// neither the line nor its response is shown, unless it is an error.
+func (d *dripper) send(line []byte, hush bool) {
+	short := d.fitLine(line)
+	if short == nil {
+		d.fail(fmt.Errorf("line too long for the printer's %d byte buffer: %s",
+			d.cmd_size, line))
+		return
+	}
+	d.in_flight = append(d.in_flight, line)
+	d.sent_at = time.Now()
+	d.hush.Store(hush)
+	d.quiet.Store(isGCode(line, "M503") || hush)
+	d.track(line)
+	// Lines are followed with their spaces, which fitLine may have dropped.
+	line = short
+	if d.checksum {
+		if n, ok := parseM110(line); ok {
+			d.line_no = n
+		} else {
+			d.line_no++
+			line = numberLine(d.line_no, line)
+			d.history[d.line_no] = line
+			delete(d.history, d.line_no-resend_history)
+		}
+	}
+	if d.rx_buffer == 0 {
+		d.ready = d.roomForMore()
+		d.serial_send <- line
+		return
+	}
+	d.backlog = append(d.backlog, line)
+	d.fillBuffer()
+}
+
+// fillBuffer sends the lines waiting for room in the printer's receive
+// buffer, on firmware that takes lines ahead of answering them, counting
+// their bytes so as not to overrun it.
+func (d *dripper) fillBuffer() {
+	for len(d.backlog) > 0 {
+		size := len(d.backlog[0]) + 1
+		if len(d.rx) > 0 && d.buffered()+size > d.rx_buffer {
+			break
+		}
+		d.rx = append(d.rx, size)
+		d.serial_send <- d.backlog[0]
+		d.backlog = d.backlog[1:]
+	}
+	d.ready = len(d.backlog) == 0 && d.buffered() < d.rx_buffer
+}
+
+// buffered returns how many bytes of lines the printer has yet to answer.
+func (d *dripper) buffered() int {
+	n := 0
+	for _, size := range d.rx {
+		n += size
+	}
+	return n
+}
+
+// roomForMore tells whether another line can be sent before the ones in
+// flight are answered, within the window (see window.go). Marlin built with
+// ADVANCED_OK says how many lines its buffer has room for, counting those in
+// flight, which it may not have had yet. While lines are being sent again,
+// those in flight are answered first.
+func (d *dripper) roomForMore() bool {
+	if len(d.in_flight) == 0 {
+		return !d.booting
+	}
+	// Nothing goes ahead of M115 while the printer may not be listening, or
+	// it tells how to talk to the printer.
+	return d.resend_from == 0 && !d.shaking &&
+		len(d.in_flight) < d.win.limit()
 }
 
-func newDripper(port serial.Port, gcode *os.File) *dripper {
-	return &dripper{
-		serial_ready: serialRecvChan(port),
-		serial_send:  serialSendChan(port),
-		gcode_file:   gcodeLines(gcode),
-		user_input:   userInput(os.Stdin),
-		sig_chan:     make(chan os.Signal),
-		ready:        false,
+// answer takes the oldest line waiting off the lines in flight, now the
+// printer has answered it, and returns it.
+func (d *dripper) answer() []byte {
+	if len(d.in_flight) == 0 {
+		d.ready = !d.booting
+		return nil
+	}
+	line := d.in_flight[0]
+	d.in_flight = d.in_flight[1:]
+	if d.rx_buffer == 0 {
+		d.win.answered(len(d.in_flight) == 0, time.Since(d.sent_at))
+		d.ready = d.roomForMore()
+		return line
 	}
+	if len(d.rx) > 0 {
+		d.rx = d.rx[1:]
+	}
+	d.fillBuffer()
+	return line
 }
 
-func (d *dripper) send(line []byte) {
+// resend sends the next line the printer asked to have again.
+func (d *dripper) resend() {
 	d.ready = false
-	d.serial_send <- line
+	d.in_flight = append(d.in_flight, nil)
+	if d.rx_buffer != 0 {
+		d.rx = append(d.rx, len(d.history[d.resend_from])+1)
+	}
+	d.serial_send <- d.history[d.resend_from]
+	d.resend_from++
+	if d.resend_from > d.line_no {
+		d.resend_from = 0
+	}
+}
+
+// readResponse picks up printer state reported in a response. It returns an
+// error if the firmware has halted, e.g. after a thermal runaway.
+func (d *dripper) readResponse(lines []string) error {
+	for _, ln := range lines {
+		if d.rrfReport(ln) {
+			continue
+		}
+		if t, ok := parseTemps(ln); ok {
+			d.setTemps(t)
+		}
+		d.trackResponse(ln)
+		if d.fw.isFatal(ln) {
+			return fmt.Errorf("%w: %s", errFirmware, ln)
+		}
+		if n, ok := parseAdvancedOK(ln); ok && d.rx_buffer == 0 {
+			d.win.report(n)
+			d.ready = d.roomForMore()
+		}
+		if n, ok := parseResend(ln); ok && d.checksum {
+			if d.history[n] == nil {
+				// Marlin would turn down every line after it.
+				d.fail(fmt.Errorf("%w: printer asked for line %d, which is no longer kept", errSerial, n))
+				continue
+			}
+			d.resend_from = n
+			d.win.resent()
+			d.ready = d.roomForMore()
+		}
+	}
+	return nil
+}
+
+// answered reports how a command the printer has just answered went, for
+// commands whose outcome is worth more than the raw response.
+func (d *dripper) answered(line []byte, resp []string) {
+	switch {
+	case isGCode(line, "M115") && d.shaking:
+		d.shook(resp)
+	case isGCode(line, "M109"), isGCode(line, "M190"):
+		d.heated()
+	case isGCode(line, "G28"):
+		d.homed(line, resp)
+	case isGCode(line, "M119"):
+		showEndstops(d.con, resp)
+	case isGCode(line, "M503"):
+		settings := parseSettings(resp)
+		showSettings(d.con, settings)
+		var lines []string
+		for _, s := range settings {
+			lines = append(lines, s.String())
+		}
+		d.reply("M503", lines, nil)
+	case isGCode(line, "M48"):
+		d.probeTested(resp)
+	case isGCode(line, "M420") && d.mesh_check &&
+		bytes.ContainsAny(line, "Vv"):
+		d.meshChecked(resp)
+	case isGCode(line, "G29"):
+		d.meshProbed(resp)
+	case isGCode(line, "M400") && d.snapping:
+		d.takeFrame(d.snap_done)
+	case d.halted && isGCode(line, d.fw.recover):
+		d.recovered()
+	}
+}
+
+func (d *dripper) homed(line []byte, resp []string) {
+	for _, ln := range resp {
+		if strings.HasPrefix(ln, "Error:") {
+			d.con.Println("-- HOMING FAILED:", ln)
+			return
+		}
+	}
+	axes := "all axes"
+	if f := bytes.Fields(line); len(f) > 1 {
+		axes = string(bytes.Join(f[1:], []byte(" ")))
+	}
+	d.con.Println("-- HOMED", axes)
+	// Find out where home is.
+	d.hack_queue = append(d.hack_queue, "M114")
+}
+
+// isFatal recognizes the messages firmware sends when it stops for good and
+// needs a reset, as opposed to recoverable errors like checksum mismatches.
+func isFatal(ln string) bool {
+	if strings.HasPrefix(ln, "!!") || strings.HasPrefix(ln, "ALARM:") {
+		return true
+	}
+	if !strings.HasPrefix(ln, "Error:") {
+		return false
+	}
+	ln = strings.ToLower(ln)
+	return strings.Contains(ln, "halted") ||
+		strings.Contains(ln, "kill()") ||
+		strings.Contains(ln, "stopped")
 }
 
 func (d *dripper) catchSig() {
-	signal.Notify(d.sig_chan, os.Interrupt)
+	signal.Notify(d.sig_chan, os.Interrupt, syscall.SIGTERM)
 }
 
 func (d *dripper) dropSig() {
@@ -250,73 +1610,401 @@ func (d *dripper) loop() {
 	d.catchSig()
 	defer d.dropSig()
 
-	var hack_queue []string
 	var hack_mode bool
 
-	gcode := d.gcode_file
+	// Grbl's handshake is its greeting, once boot resets it.
+	if d.fw.reset == 0 {
+		d.shaking = true
+		d.identifying = firmware == "auto"
+		d.hack_queue = append([]string{"M115"}, d.hack_queue...)
+	}
+	if d.checksum {
+		d.hack_queue = append([]string{"M110 N0"}, d.hack_queue...)
+	}
+	if !d.identifying {
+		d.keepAlive()
+	}
+	hang_tick := time.NewTicker(time.Second)
+	defer hang_tick.Stop()
+
+	d.boot()
 	start := time.Now()
 	log.Print("Start drip.")
+	if d.job_name != "" {
+		d.con.jobBegan()
+		d.bus.publish(jobStarted{d.job_name})
+		d.beforeJob()
+	}
 Loop:
 	for {
+		d.showStatus()
+		d.feedHold()
+		// Lines the printer missed go before anything else.
+		if d.ready && d.resend_from > 0 {
+			d.resend()
+			continue
+		}
+		// Manually entered GCodes jump ahead of the file.
+		if d.ready && len(d.hack_queue) > 0 {
+			line := d.hack_queue[0]
+			d.hack_queue = d.hack_queue[1:]
+			if path, ok := strings.CutPrefix(line, ":run "); ok {
+				d.runFile(strings.TrimSpace(path))
+				continue
+			}
+			if strings.HasPrefix(line, "@") {
+				d.hostCommand(line[1:])
+				continue
+			}
+			d.send([]byte(line), false)
+			// With room left in the firmware's buffer, the rest of the
+			// queue still goes before the file.
+			continue
+		}
+		var next, side <-chan []byte
+		if d.ready && !hack_mode && !d.paused && !d.jogging && !d.snapping {
+			next = d.gcode
+		}
+		if d.ready {
+			side = d.side
+		}
+
 		select {
-		case line := <-d.user_input:
+		case s := <-d.scan:
+			d.scanned(s)
+		case line, ok := <-d.user_input:
+			if !ok {
+				d.user_input = nil
+				continue
+			}
 			if hack_mode {
-				if d.ready {
-					d.send([]byte(line))
-				} else {
-					d.hack_queue = append(d.hack_queue, line)
+				d.queueLine(line)
+			} else {
+				// Keys that aren't hotkeys are ignored.
+				_, err := d.hotkey(line)
+				if err != nil && !errors.Is(err, errNoHotkey) {
+					d.con.Println("--", err)
+				}
+			}
+		case sig := <-d.sig_chan:
+			// With -json there is no menu to bring up.
+			if sig == syscall.SIGTERM || json_out {
+				// A second SIGTERM gives up on the stop GCodes.
+				if d.stopping {
+					d.con.Println("-- ABORT")
+					d.toolOff()
+					d.err = errAborted
+					break Loop
 				}
+				d.con.Println("-- SHUTDOWN: Dripping stop GCodes.")
+				hack_mode = false
+				setPrompt("")
+				d.shutdown(errAborted)
+				// The daemon's job is to stop on SIGTERM.
+				if !d.daemon {
+					d.err = errAborted
+				}
+				continue
+			}
+			if d.daemon {
+				d.con.Println("-- ABORT")
+				d.toolOff()
+				d.err = errAborted
+				break Loop
 			}
-			// O/W discard user input but keep reading it to flush stdin.
-		case <-d.sig_chan:
 			// Drop SIGINT handler so ^C twice will exit.
 			d.dropSig()
-			// Reset hacker mode in case we are in it.
+			// Reset hacker, jog, Z offset, e-steps or filament change mode in
+			// case we are in it.
 			hack_mode = false
-			switch controlMenu(d.user_input) {
+			d.jogging = false
+			d.esteps = estepsOff
+			d.changing = false
+			if d.zoffsetting {
+				d.zoffsetting = false
+				d.hack_queue = append(d.hack_queue, "M211 S1")
+			}
+			setPrompt("")
+			choice, err := controlMenu(d.user_input)
+			if err != nil {
+				d.fail(err)
+				d.catchSig()
+				continue
+			}
+			switch choice {
 			case ctrlContinue:
-				fmt.Println("-- DRIP FILE")
-				gcode = d.gcode_file
+				d.con.Println("-- DRIP FILE")
+				d.gcode = d.gcode_file
+				d.paused = false
+				d.err = nil
+				d.returnToJob()
 			case ctrlStop:
-				fmt.Println("-- DRIP JOB STOP CODES")
+				d.con.Println("-- DRIP JOB STOP CODES")
 				// XXX: this restarts the stop sequence each time
-				gcode = stopGCode()
+				d.gcode = stopGCode()
+				d.err = errAborted
+				d.jobFailed(d.err)
+				d.job_name = ""
+				d.hold = nil
 			case ctrlAbort:
-				fmt.Println("-- ABORT")
+				d.con.Println("-- ABORT")
+				d.toolOff()
+				d.err = errAborted
+				d.jobFailed(d.err)
 				break Loop
 			case ctrlHackerMode:
-				fmt.Println("-- HACKER MODE: Type Gcodes now.")
+				d.con.Println("-- HACKER MODE: Type Gcodes now.")
 				hack_mode = true
+				d.holdPosition()
+				setPrompt("gcode> ")
+			case ctrlJog:
+				// Jogging mid-print would ruin it.
+				d.paused = true
+				d.holdPosition()
+				d.hotkey("j")
+			case ctrlChange:
+				if _, err := d.startChange(); err != nil {
+					d.con.Println("--", err)
+				}
+			case ctrlExclude:
+				name, err := d.objectMenu(d.user_input)
+				if err != nil {
+					d.fail(err)
+				} else if name != "" {
+					if _, err := d.excludeObject(name); err != nil {
+						d.con.Println("--", err)
+					}
+				}
+			case ctrlESteps:
+				d.paused = true
+				d.holdPosition()
+				if _, err := d.startESteps(); err != nil {
+					d.con.Println("--", err)
+				}
+			case ctrlMacro:
+				name, err := macroMenu(d.user_input)
+				if err != nil {
+					d.fail(err)
+				} else if name != "" {
+					d.runMacro(name)
+				}
+			case ctrlPreheat:
+				name, err := preheatMenu(d.user_input)
+				if err != nil {
+					d.fail(err)
+				} else if name != "" {
+					d.preheat(name)
+				}
+			case ctrlCooldown:
+				d.cooldown()
+			case ctrlEndstops:
+				d.hack_queue = append(d.hack_queue, "M119")
+			case ctrlSettings:
+				d.hack_queue = append(d.hack_queue, "M503")
 			}
 			d.catchSig()
-		case err, ok := <-d.serial_ready:
-			d.ready = true
+		case req := <-d.ctl_chan:
+			if d.controlLater(req) {
+				continue
+			}
+			res, err := d.control(req.method, req.params)
+			req.reply <- ctlReply{res, err}
+		case err := <-d.snap_done:
+			d.snapped(err)
+		case err := <-d.input_err:
+			d.fail(fmt.Errorf("reading keyboard: %w", err))
+		case err := <-d.send_err:
+			d.err = fmt.Errorf("%w: %v", errSerial, err)
+			log.Println(d.err)
+			d.emitError(d.err)
+			d.jobFailed(d.err)
+			break Loop
+		case <-hang_tick.C:
+			err := d.checkHandshake()
+			if err == nil {
+				err = d.checkReadTimeout()
+			}
+			if err != nil {
+				d.err = fmt.Errorf("%w: %v", errSerial, err)
+				log.Println(d.err)
+				d.emitError(d.err)
+				d.jobFailed(d.err)
+				break Loop
+			}
+			d.checkHang()
+		case <-d.temp_tick:
+			if d.fw.status != 0 {
+				d.realtime(d.fw.status)
+			} else if d.fw.poll != "" {
+				if len(d.hack_queue) == 0 {
+					d.hack_queue = append(d.hack_queue, d.fw.poll)
+				}
+			} else if len(d.hack_queue) == 0 {
+				if isPrinter() {
+					d.hack_queue = append(d.hack_queue, "M105")
+				}
+				// Moves are tracked while printing.
+				if d.gcode == nil {
+					d.hack_queue = append(d.hack_queue, "M114")
+				}
+			}
+		case resp, ok := <-d.serial_ready:
+			d.heard_at = time.Now()
+			if d.silent {
+				d.silent = false
+				d.con.Println("-- PRINTER ANSWERING AGAIN")
+			}
 			switch {
-			case err != nil:
-				log.Println(err)
+			case resp.err != nil:
+				d.err = fmt.Errorf("%w: %v", errSerial, resp.err)
+				log.Println(d.err)
+				d.emitError(d.err)
+				d.jobFailed(d.err)
 				break Loop
 			case !ok:
 				break Loop
-			case hack_mode:
-				if len(d.hack_queue) > 0 {
-					d.send([]byte(hack_queue[0]))
-					d.hack_queue = d.hack_queue[1:]
+			case resp.action != "":
+				d.printerAction(resp.action)
+				continue
+			}
+			line := d.answer()
+			d.heard = d.heard || line != nil
+			d.fw_paused = false
+			if err := d.readResponse(resp.lines); err != nil {
+				if d.halt(err) {
+					continue
+				}
+				d.con.Println("-- HALTED:", err)
+				d.err = err
+				d.emitError(err)
+				d.jobFailed(err)
+				break Loop
+			}
+			d.answered(line, resp.lines)
+		case line, ok := <-side:
+			if !ok {
+				if len(d.side_err) > 0 {
+					d.con.Println("-- ERROR: reading GCode:", <-d.side_err)
 				}
-			default:
-				line, ok := <-gcode
-				if !ok {
+				d.con.Println("-- END OF FILE")
+				d.side = nil
+				continue
+			}
+			if line[0] == '@' {
+				d.hostCommand(string(line[1:]))
+				continue
+			}
+			d.send(line, false)
+		case line, ok := <-next:
+			if d.gcode == d.gcode_file {
+				// Host commands aren't sent, and aren't counted.
+				if ok {
+					if line[0] != '@' {
+						d.counted(line)
+					}
+				} else if len(d.gcode_err) > 0 {
+					d.fail(fmt.Errorf("reading GCode: %w", <-d.gcode_err))
+					continue
+				}
+			}
+			if !ok {
+				// Wait for the printer to answer the lines in its buffer.
+				if len(d.in_flight) > 0 {
+					d.ready = false
+					continue
+				}
+				if d.gcode == d.gcode_file && d.job_name != "" {
+					d.bus.publish(jobEnded{d.job_name, nil})
+					d.renderTimelapse()
+					if !d.stopping && d.repeatJob() {
+						continue
+					}
+				}
+				if d.stopping || !d.daemon {
 					break Loop
 				}
-				d.send(line)
+				d.jobDone()
+				continue
+			}
+			if line[0] == '@' {
+				d.hostCommand(string(line[1:]))
+				continue
+			}
+			if d.skipLine(line) {
+				continue
+			}
+			if host_m600 && isGCode(line, "M600") {
+				_, err := d.startChange()
+				if err == nil {
+					continue
+				}
+				d.con.Println("-- cannot change filament here:", err)
+			}
+			// On the first layer only what the keys send is shown.
+			d.send(line, d.onFirstLayer())
+			if isTravel(line) {
+				d.pauseAt("travel")
 			}
 		}
 	}
 
 	close(d.serial_send)
-	log.Println("Stop drip. Elapsed:", time.Since(start).Round(time.Second))
+	close(d.stopped)
+	for code := range d.waiting {
+		d.reply(code, nil, errors.New("printer is stopped"))
+	}
+	for _, p := range d.plugins {
+		p.close()
+	}
+	d.running.Wait()
+	d.con.endRun()
+	log.Printf("Stop drip. Elapsed: %v, %d lines of the file sent.",
+		time.Since(start).Round(time.Second), d.sent)
+}
+
+// fail runs the abort path for an error outside the serial link: the job is
+// abandoned and the stop GCodes turn the heaters off. A daemon only loses the
+// job; otherwise the loop ends after the stop GCodes.
+func (d *dripper) fail(err error) {
+	d.con.Println("-- ERROR:", err)
+	d.emitError(err)
+	if d.daemon {
+		d.cancelJob(err)
+		return
+	}
+	d.err = err
+	d.shutdown(err)
+}
+
+// shutdown abandons the job and drips the stop GCodes, after which the loop
+// ends. The line in flight is finished first like any other.
+func (d *dripper) shutdown(reason error) {
+	d.jobFailed(reason)
+	if d.gcode_file != nil {
+		drainLines(d.gcode_file)
+		d.gcode_file = nil
+	}
+	if d.side != nil {
+		drainLines(d.side)
+		d.side = nil
+	}
+	d.job_name = ""
+	d.job_queue = nil
+	d.paused = false
+	d.hold = nil
+	d.stopping = true
+	d.gcode = stopGCode()
+}
+
+// drainLines discards the rest of a line source so its reader can finish.
+func drainLines(lines <-chan []byte) {
+	go func() {
+		for range lines {
+		}
+	}()
 }
 
-func controlMenu(userin <-chan string) ctrlChoice {
+func controlMenu(userin <-chan string) (ctrlChoice, error) {
 	// discard buffered input
 	flushUserInput(userin)
 
@@ -326,21 +2014,48 @@ c) continue    (drip GCode file)
 s) stop job    (drip stop GCode)
 a) hard abort  (exits program)
 h) hacker mode (enter GCodes on keyboard)
+m) macros      (run a macro from the config file)
+p) preheat     (heat up for a material)
+o) cool down   (heaters and fan off)
+e) endstops    (show which endstops are triggered)
+i) settings    (show the printer's settings)
+j) jog mode    (move the head with the arrow keys)
+x) e-steps     (calibrate the extruder)
+f) filament    (change filament)
+k) skip object (stop printing one of the objects)
 l) list ports  (list COM ports)
 `)
 		ans, ok := <-userin
 		if !ok {
-			log.Fatal("cannot read from stdin")
+			return ctrlContinue, errors.New("cannot read from stdin")
 		}
 		switch ans {
 		case "c":
-			return ctrlContinue
+			return ctrlContinue, nil
 		case "s":
-			return ctrlStop
+			return ctrlStop, nil
 		case "a":
-			return ctrlAbort
+			return ctrlAbort, nil
 		case "h":
-			return ctrlHackerMode
+			return ctrlHackerMode, nil
+		case "m":
+			return ctrlMacro, nil
+		case "p":
+			return ctrlPreheat, nil
+		case "o":
+			return ctrlCooldown, nil
+		case "e":
+			return ctrlEndstops, nil
+		case "i":
+			return ctrlSettings, nil
+		case "j":
+			return ctrlJog, nil
+		case "x":
+			return ctrlESteps, nil
+		case "f":
+			return ctrlChange, nil
+		case "k":
+			return ctrlExclude, nil
 		case "l":
 			listPorts()
 		default:
@@ -352,7 +2067,7 @@ l) list ports  (list COM ports)
 func stopGCode() <-chan []byte {
 	out := make(chan []byte)
 	go func() {
-		buf := bytes.NewBuffer(stop_gcode)
+		buf := bytes.NewBuffer(stopCodes())
 		var err error
 		for err == nil {
 			var ln []byte
@@ -0,0 +1,5 @@
+// Package dripp3rpb is the daemon's gRPC API, generated from dripp3r.proto,
+// for Go front ends to import.
+package dripp3rpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative dripp3r.proto
@@ -0,0 +1,866 @@
+// The daemon's gRPC API, served with -grpc, for front ends that would rather
+// call typed methods than speak JSON-RPC on the control socket or scrape the
+// Moonraker API. Each request names the printer when the daemon runs several.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.31.0
+// 	protoc        (unknown)
+// source: dripp3r.proto
+
+package dripp3rpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type PrinterRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Printer string `protobuf:"bytes,1,opt,name=printer,proto3" json:"printer,omitempty"`
+}
+
+func (x *PrinterRequest) Reset() {
+	*x = PrinterRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_dripp3r_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PrinterRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PrinterRequest) ProtoMessage() {}
+
+func (x *PrinterRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_dripp3r_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PrinterRequest.ProtoReflect.Descriptor instead.
+func (*PrinterRequest) Descriptor() ([]byte, []int) {
+	return file_dripp3r_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *PrinterRequest) GetPrinter() string {
+	if x != nil {
+		return x.Printer
+	}
+	return ""
+}
+
+type StartJobRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Printer string `protobuf:"bytes,1,opt,name=printer,proto3" json:"printer,omitempty"`
+	Path    string `protobuf:"bytes,2,opt,name=path,proto3" json:"path,omitempty"`
+}
+
+func (x *StartJobRequest) Reset() {
+	*x = StartJobRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_dripp3r_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StartJobRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StartJobRequest) ProtoMessage() {}
+
+func (x *StartJobRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_dripp3r_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StartJobRequest.ProtoReflect.Descriptor instead.
+func (*StartJobRequest) Descriptor() ([]byte, []int) {
+	return file_dripp3r_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *StartJobRequest) GetPrinter() string {
+	if x != nil {
+		return x.Printer
+	}
+	return ""
+}
+
+func (x *StartJobRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+// Reply is what the daemon says it did, e.g. "queued 2".
+type Reply struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Message string `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+}
+
+func (x *Reply) Reset() {
+	*x = Reply{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_dripp3r_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Reply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Reply) ProtoMessage() {}
+
+func (x *Reply) ProtoReflect() protoreflect.Message {
+	mi := &file_dripp3r_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Reply.ProtoReflect.Descriptor instead.
+func (*Reply) Descriptor() ([]byte, []int) {
+	return file_dripp3r_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Reply) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+type Temps struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Hotend       float64 `protobuf:"fixed64,1,opt,name=hotend,proto3" json:"hotend,omitempty"`
+	HotendTarget float64 `protobuf:"fixed64,2,opt,name=hotend_target,json=hotendTarget,proto3" json:"hotend_target,omitempty"`
+	Bed          float64 `protobuf:"fixed64,3,opt,name=bed,proto3" json:"bed,omitempty"`
+	BedTarget    float64 `protobuf:"fixed64,4,opt,name=bed_target,json=bedTarget,proto3" json:"bed_target,omitempty"`
+}
+
+func (x *Temps) Reset() {
+	*x = Temps{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_dripp3r_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Temps) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Temps) ProtoMessage() {}
+
+func (x *Temps) ProtoReflect() protoreflect.Message {
+	mi := &file_dripp3r_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Temps.ProtoReflect.Descriptor instead.
+func (*Temps) Descriptor() ([]byte, []int) {
+	return file_dripp3r_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Temps) GetHotend() float64 {
+	if x != nil {
+		return x.Hotend
+	}
+	return 0
+}
+
+func (x *Temps) GetHotendTarget() float64 {
+	if x != nil {
+		return x.HotendTarget
+	}
+	return 0
+}
+
+func (x *Temps) GetBed() float64 {
+	if x != nil {
+		return x.Bed
+	}
+	return 0
+}
+
+func (x *Temps) GetBedTarget() float64 {
+	if x != nil {
+		return x.BedTarget
+	}
+	return 0
+}
+
+type Position struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	X float64 `protobuf:"fixed64,1,opt,name=x,proto3" json:"x,omitempty"`
+	Y float64 `protobuf:"fixed64,2,opt,name=y,proto3" json:"y,omitempty"`
+	Z float64 `protobuf:"fixed64,3,opt,name=z,proto3" json:"z,omitempty"`
+	E float64 `protobuf:"fixed64,4,opt,name=e,proto3" json:"e,omitempty"`
+}
+
+func (x *Position) Reset() {
+	*x = Position{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_dripp3r_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Position) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Position) ProtoMessage() {}
+
+func (x *Position) ProtoReflect() protoreflect.Message {
+	mi := &file_dripp3r_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Position.ProtoReflect.Descriptor instead.
+func (*Position) Descriptor() ([]byte, []int) {
+	return file_dripp3r_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Position) GetX() float64 {
+	if x != nil {
+		return x.X
+	}
+	return 0
+}
+
+func (x *Position) GetY() float64 {
+	if x != nil {
+		return x.Y
+	}
+	return 0
+}
+
+func (x *Position) GetZ() float64 {
+	if x != nil {
+		return x.Z
+	}
+	return 0
+}
+
+func (x *Position) GetE() float64 {
+	if x != nil {
+		return x.E
+	}
+	return 0
+}
+
+// Status is what "dripp3r status" shows.
+type Status struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	State      string    `protobuf:"bytes,1,opt,name=state,proto3" json:"state,omitempty"` // idle, printing, pausing, paused, stopping or halted
+	Job        string    `protobuf:"bytes,2,opt,name=job,proto3" json:"job,omitempty"`
+	Queued     int32     `protobuf:"varint,3,opt,name=queued,proto3" json:"queued,omitempty"`
+	Temps      *Temps    `protobuf:"bytes,4,opt,name=temps,proto3" json:"temps,omitempty"`
+	Feedrate   int32     `protobuf:"varint,5,opt,name=feedrate,proto3" json:"feedrate,omitempty"` // percent
+	Flow       int32     `protobuf:"varint,6,opt,name=flow,proto3" json:"flow,omitempty"`         // percent
+	Fan        int32     `protobuf:"varint,7,opt,name=fan,proto3" json:"fan,omitempty"`           // percent
+	Position   *Position `protobuf:"bytes,8,opt,name=position,proto3" json:"position,omitempty"`
+	Babystep   float64   `protobuf:"fixed64,9,opt,name=babystep,proto3" json:"babystep,omitempty"`
+	FirstLayer bool      `protobuf:"varint,10,opt,name=first_layer,json=firstLayer,proto3" json:"first_layer,omitempty"`
+	Machine    string    `protobuf:"bytes,11,opt,name=machine,proto3" json:"machine,omitempty"`     // unless a 3D printer
+	Firmware   string    `protobuf:"bytes,12,opt,name=firmware,proto3" json:"firmware,omitempty"`   // its own state, unless idle
+	Heating    string    `protobuf:"bytes,13,opt,name=heating,proto3" json:"heating,omitempty"`     // while M109 or M190 waits
+	Progress   float64   `protobuf:"fixed64,14,opt,name=progress,proto3" json:"progress,omitempty"` // 0 to 1, by lines sent
+	Line       int32     `protobuf:"varint,15,opt,name=line,proto3" json:"line,omitempty"`
+	Lines      int32     `protobuf:"varint,16,opt,name=lines,proto3" json:"lines,omitempty"`
+	Layer      int32     `protobuf:"varint,17,opt,name=layer,proto3" json:"layer,omitempty"`
+	Layers     int32     `protobuf:"varint,18,opt,name=layers,proto3" json:"layers,omitempty"`
+	Left       int32     `protobuf:"varint,19,opt,name=left,proto3" json:"left,omitempty"` // seconds the job has left
+}
+
+func (x *Status) Reset() {
+	*x = Status{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_dripp3r_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Status) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Status) ProtoMessage() {}
+
+func (x *Status) ProtoReflect() protoreflect.Message {
+	mi := &file_dripp3r_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Status.ProtoReflect.Descriptor instead.
+func (*Status) Descriptor() ([]byte, []int) {
+	return file_dripp3r_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Status) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *Status) GetJob() string {
+	if x != nil {
+		return x.Job
+	}
+	return ""
+}
+
+func (x *Status) GetQueued() int32 {
+	if x != nil {
+		return x.Queued
+	}
+	return 0
+}
+
+func (x *Status) GetTemps() *Temps {
+	if x != nil {
+		return x.Temps
+	}
+	return nil
+}
+
+func (x *Status) GetFeedrate() int32 {
+	if x != nil {
+		return x.Feedrate
+	}
+	return 0
+}
+
+func (x *Status) GetFlow() int32 {
+	if x != nil {
+		return x.Flow
+	}
+	return 0
+}
+
+func (x *Status) GetFan() int32 {
+	if x != nil {
+		return x.Fan
+	}
+	return 0
+}
+
+func (x *Status) GetPosition() *Position {
+	if x != nil {
+		return x.Position
+	}
+	return nil
+}
+
+func (x *Status) GetBabystep() float64 {
+	if x != nil {
+		return x.Babystep
+	}
+	return 0
+}
+
+func (x *Status) GetFirstLayer() bool {
+	if x != nil {
+		return x.FirstLayer
+	}
+	return false
+}
+
+func (x *Status) GetMachine() string {
+	if x != nil {
+		return x.Machine
+	}
+	return ""
+}
+
+func (x *Status) GetFirmware() string {
+	if x != nil {
+		return x.Firmware
+	}
+	return ""
+}
+
+func (x *Status) GetHeating() string {
+	if x != nil {
+		return x.Heating
+	}
+	return ""
+}
+
+func (x *Status) GetProgress() float64 {
+	if x != nil {
+		return x.Progress
+	}
+	return 0
+}
+
+func (x *Status) GetLine() int32 {
+	if x != nil {
+		return x.Line
+	}
+	return 0
+}
+
+func (x *Status) GetLines() int32 {
+	if x != nil {
+		return x.Lines
+	}
+	return 0
+}
+
+func (x *Status) GetLayer() int32 {
+	if x != nil {
+		return x.Layer
+	}
+	return 0
+}
+
+func (x *Status) GetLayers() int32 {
+	if x != nil {
+		return x.Layers
+	}
+	return 0
+}
+
+func (x *Status) GetLeft() int32 {
+	if x != nil {
+		return x.Left
+	}
+	return 0
+}
+
+type ConsoleEvent struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Printer string `protobuf:"bytes,1,opt,name=printer,proto3" json:"printer,omitempty"`
+	// Types that are assignable to Event:
+	//	*ConsoleEvent_Line
+	//	*ConsoleEvent_Temps
+	//	*ConsoleEvent_Status
+	Event isConsoleEvent_Event `protobuf_oneof:"event"`
+}
+
+func (x *ConsoleEvent) Reset() {
+	*x = ConsoleEvent{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_dripp3r_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ConsoleEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConsoleEvent) ProtoMessage() {}
+
+func (x *ConsoleEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_dripp3r_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConsoleEvent.ProtoReflect.Descriptor instead.
+func (*ConsoleEvent) Descriptor() ([]byte, []int) {
+	return file_dripp3r_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ConsoleEvent) GetPrinter() string {
+	if x != nil {
+		return x.Printer
+	}
+	return ""
+}
+
+func (m *ConsoleEvent) GetEvent() isConsoleEvent_Event {
+	if m != nil {
+		return m.Event
+	}
+	return nil
+}
+
+func (x *ConsoleEvent) GetLine() string {
+	if x, ok := x.GetEvent().(*ConsoleEvent_Line); ok {
+		return x.Line
+	}
+	return ""
+}
+
+func (x *ConsoleEvent) GetTemps() *Temps {
+	if x, ok := x.GetEvent().(*ConsoleEvent_Temps); ok {
+		return x.Temps
+	}
+	return nil
+}
+
+func (x *ConsoleEvent) GetStatus() *Status {
+	if x, ok := x.GetEvent().(*ConsoleEvent_Status); ok {
+		return x.Status
+	}
+	return nil
+}
+
+type isConsoleEvent_Event interface {
+	isConsoleEvent_Event()
+}
+
+type ConsoleEvent_Line struct {
+	Line string `protobuf:"bytes,2,opt,name=line,proto3,oneof"` // a line of the console, e.g. ">> G28" or "<< ok"
+}
+
+type ConsoleEvent_Temps struct {
+	Temps *Temps `protobuf:"bytes,3,opt,name=temps,proto3,oneof"`
+}
+
+type ConsoleEvent_Status struct {
+	Status *Status `protobuf:"bytes,4,opt,name=status,proto3,oneof"`
+}
+
+func (*ConsoleEvent_Line) isConsoleEvent_Event() {}
+
+func (*ConsoleEvent_Temps) isConsoleEvent_Event() {}
+
+func (*ConsoleEvent_Status) isConsoleEvent_Event() {}
+
+var File_dripp3r_proto protoreflect.FileDescriptor
+
+var file_dripp3r_proto_rawDesc = []byte{
+	0x0a, 0x0d, 0x64, 0x72, 0x69, 0x70, 0x70, 0x33, 0x72, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12,
+	0x07, 0x64, 0x72, 0x69, 0x70, 0x70, 0x33, 0x72, 0x22, 0x2a, 0x0a, 0x0e, 0x50, 0x72, 0x69, 0x6e,
+	0x74, 0x65, 0x72, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x70, 0x72,
+	0x69, 0x6e, 0x74, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x70, 0x72, 0x69,
+	0x6e, 0x74, 0x65, 0x72, 0x22, 0x3f, 0x0a, 0x0f, 0x53, 0x74, 0x61, 0x72, 0x74, 0x4a, 0x6f, 0x62,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x70, 0x72, 0x69, 0x6e, 0x74,
+	0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x70, 0x72, 0x69, 0x6e, 0x74, 0x65,
+	0x72, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x61, 0x74, 0x68, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x04, 0x70, 0x61, 0x74, 0x68, 0x22, 0x21, 0x0a, 0x05, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x18,
+	0x0a, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x22, 0x75, 0x0a, 0x05, 0x54, 0x65, 0x6d, 0x70,
+	0x73, 0x12, 0x16, 0x0a, 0x06, 0x68, 0x6f, 0x74, 0x65, 0x6e, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x01, 0x52, 0x06, 0x68, 0x6f, 0x74, 0x65, 0x6e, 0x64, 0x12, 0x23, 0x0a, 0x0d, 0x68, 0x6f, 0x74,
+	0x65, 0x6e, 0x64, 0x5f, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01,
+	0x52, 0x0c, 0x68, 0x6f, 0x74, 0x65, 0x6e, 0x64, 0x54, 0x61, 0x72, 0x67, 0x65, 0x74, 0x12, 0x10,
+	0x0a, 0x03, 0x62, 0x65, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x01, 0x52, 0x03, 0x62, 0x65, 0x64,
+	0x12, 0x1d, 0x0a, 0x0a, 0x62, 0x65, 0x64, 0x5f, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x01, 0x52, 0x09, 0x62, 0x65, 0x64, 0x54, 0x61, 0x72, 0x67, 0x65, 0x74, 0x22,
+	0x42, 0x0a, 0x08, 0x50, 0x6f, 0x73, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x0c, 0x0a, 0x01, 0x78,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x01, 0x52, 0x01, 0x78, 0x12, 0x0c, 0x0a, 0x01, 0x79, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x01, 0x52, 0x01, 0x79, 0x12, 0x0c, 0x0a, 0x01, 0x7a, 0x18, 0x03, 0x20, 0x01,
+	0x28, 0x01, 0x52, 0x01, 0x7a, 0x12, 0x0c, 0x0a, 0x01, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x01,
+	0x52, 0x01, 0x65, 0x22, 0xf4, 0x03, 0x0a, 0x06, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x14,
+	0x0a, 0x05, 0x73, 0x74, 0x61, 0x74, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x73,
+	0x74, 0x61, 0x74, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x6a, 0x6f, 0x62, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x03, 0x6a, 0x6f, 0x62, 0x12, 0x16, 0x0a, 0x06, 0x71, 0x75, 0x65, 0x75, 0x65, 0x64,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x06, 0x71, 0x75, 0x65, 0x75, 0x65, 0x64, 0x12, 0x24,
+	0x0a, 0x05, 0x74, 0x65, 0x6d, 0x70, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e,
+	0x64, 0x72, 0x69, 0x70, 0x70, 0x33, 0x72, 0x2e, 0x54, 0x65, 0x6d, 0x70, 0x73, 0x52, 0x05, 0x74,
+	0x65, 0x6d, 0x70, 0x73, 0x12, 0x1a, 0x0a, 0x08, 0x66, 0x65, 0x65, 0x64, 0x72, 0x61, 0x74, 0x65,
+	0x18, 0x05, 0x20, 0x01, 0x28, 0x05, 0x52, 0x08, 0x66, 0x65, 0x65, 0x64, 0x72, 0x61, 0x74, 0x65,
+	0x12, 0x12, 0x0a, 0x04, 0x66, 0x6c, 0x6f, 0x77, 0x18, 0x06, 0x20, 0x01, 0x28, 0x05, 0x52, 0x04,
+	0x66, 0x6c, 0x6f, 0x77, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x61, 0x6e, 0x18, 0x07, 0x20, 0x01, 0x28,
+	0x05, 0x52, 0x03, 0x66, 0x61, 0x6e, 0x12, 0x2d, 0x0a, 0x08, 0x70, 0x6f, 0x73, 0x69, 0x74, 0x69,
+	0x6f, 0x6e, 0x18, 0x08, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x11, 0x2e, 0x64, 0x72, 0x69, 0x70, 0x70,
+	0x33, 0x72, 0x2e, 0x50, 0x6f, 0x73, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x08, 0x70, 0x6f, 0x73,
+	0x69, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x1a, 0x0a, 0x08, 0x62, 0x61, 0x62, 0x79, 0x73, 0x74, 0x65,
+	0x70, 0x18, 0x09, 0x20, 0x01, 0x28, 0x01, 0x52, 0x08, 0x62, 0x61, 0x62, 0x79, 0x73, 0x74, 0x65,
+	0x70, 0x12, 0x1f, 0x0a, 0x0b, 0x66, 0x69, 0x72, 0x73, 0x74, 0x5f, 0x6c, 0x61, 0x79, 0x65, 0x72,
+	0x18, 0x0a, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0a, 0x66, 0x69, 0x72, 0x73, 0x74, 0x4c, 0x61, 0x79,
+	0x65, 0x72, 0x12, 0x18, 0x0a, 0x07, 0x6d, 0x61, 0x63, 0x68, 0x69, 0x6e, 0x65, 0x18, 0x0b, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x07, 0x6d, 0x61, 0x63, 0x68, 0x69, 0x6e, 0x65, 0x12, 0x1a, 0x0a, 0x08,
+	0x66, 0x69, 0x72, 0x6d, 0x77, 0x61, 0x72, 0x65, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08,
+	0x66, 0x69, 0x72, 0x6d, 0x77, 0x61, 0x72, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x68, 0x65, 0x61, 0x74,
+	0x69, 0x6e, 0x67, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x68, 0x65, 0x61, 0x74, 0x69,
+	0x6e, 0x67, 0x12, 0x1a, 0x0a, 0x08, 0x70, 0x72, 0x6f, 0x67, 0x72, 0x65, 0x73, 0x73, 0x18, 0x0e,
+	0x20, 0x01, 0x28, 0x01, 0x52, 0x08, 0x70, 0x72, 0x6f, 0x67, 0x72, 0x65, 0x73, 0x73, 0x12, 0x12,
+	0x0a, 0x04, 0x6c, 0x69, 0x6e, 0x65, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x05, 0x52, 0x04, 0x6c, 0x69,
+	0x6e, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x6c, 0x69, 0x6e, 0x65, 0x73, 0x18, 0x10, 0x20, 0x01, 0x28,
+	0x05, 0x52, 0x05, 0x6c, 0x69, 0x6e, 0x65, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x6c, 0x61, 0x79, 0x65,
+	0x72, 0x18, 0x11, 0x20, 0x01, 0x28, 0x05, 0x52, 0x05, 0x6c, 0x61, 0x79, 0x65, 0x72, 0x12, 0x16,
+	0x0a, 0x06, 0x6c, 0x61, 0x79, 0x65, 0x72, 0x73, 0x18, 0x12, 0x20, 0x01, 0x28, 0x05, 0x52, 0x06,
+	0x6c, 0x61, 0x79, 0x65, 0x72, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x6c, 0x65, 0x66, 0x74, 0x18, 0x13,
+	0x20, 0x01, 0x28, 0x05, 0x52, 0x04, 0x6c, 0x65, 0x66, 0x74, 0x22, 0x9a, 0x01, 0x0a, 0x0c, 0x43,
+	0x6f, 0x6e, 0x73, 0x6f, 0x6c, 0x65, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x70,
+	0x72, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x70, 0x72,
+	0x69, 0x6e, 0x74, 0x65, 0x72, 0x12, 0x14, 0x0a, 0x04, 0x6c, 0x69, 0x6e, 0x65, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x09, 0x48, 0x00, 0x52, 0x04, 0x6c, 0x69, 0x6e, 0x65, 0x12, 0x26, 0x0a, 0x05, 0x74,
+	0x65, 0x6d, 0x70, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x64, 0x72, 0x69,
+	0x70, 0x70, 0x33, 0x72, 0x2e, 0x54, 0x65, 0x6d, 0x70, 0x73, 0x48, 0x00, 0x52, 0x05, 0x74, 0x65,
+	0x6d, 0x70, 0x73, 0x12, 0x29, 0x0a, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x64, 0x72, 0x69, 0x70, 0x70, 0x33, 0x72, 0x2e, 0x53, 0x74,
+	0x61, 0x74, 0x75, 0x73, 0x48, 0x00, 0x52, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x42, 0x07,
+	0x0a, 0x05, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x32, 0xd1, 0x02, 0x0a, 0x07, 0x44, 0x72, 0x69, 0x70,
+	0x70, 0x65, 0x72, 0x12, 0x34, 0x0a, 0x08, 0x53, 0x74, 0x61, 0x72, 0x74, 0x4a, 0x6f, 0x62, 0x12,
+	0x18, 0x2e, 0x64, 0x72, 0x69, 0x70, 0x70, 0x33, 0x72, 0x2e, 0x53, 0x74, 0x61, 0x72, 0x74, 0x4a,
+	0x6f, 0x62, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0e, 0x2e, 0x64, 0x72, 0x69, 0x70,
+	0x70, 0x33, 0x72, 0x2e, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x30, 0x0a, 0x05, 0x50, 0x61, 0x75,
+	0x73, 0x65, 0x12, 0x17, 0x2e, 0x64, 0x72, 0x69, 0x70, 0x70, 0x33, 0x72, 0x2e, 0x50, 0x72, 0x69,
+	0x6e, 0x74, 0x65, 0x72, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0e, 0x2e, 0x64, 0x72,
+	0x69, 0x70, 0x70, 0x33, 0x72, 0x2e, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x31, 0x0a, 0x06, 0x52,
+	0x65, 0x73, 0x75, 0x6d, 0x65, 0x12, 0x17, 0x2e, 0x64, 0x72, 0x69, 0x70, 0x70, 0x33, 0x72, 0x2e,
+	0x50, 0x72, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0e,
+	0x2e, 0x64, 0x72, 0x69, 0x70, 0x70, 0x33, 0x72, 0x2e, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x31,
+	0x0a, 0x06, 0x43, 0x61, 0x6e, 0x63, 0x65, 0x6c, 0x12, 0x17, 0x2e, 0x64, 0x72, 0x69, 0x70, 0x70,
+	0x33, 0x72, 0x2e, 0x50, 0x72, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x1a, 0x0e, 0x2e, 0x64, 0x72, 0x69, 0x70, 0x70, 0x33, 0x72, 0x2e, 0x52, 0x65, 0x70, 0x6c,
+	0x79, 0x12, 0x35, 0x0a, 0x09, 0x47, 0x65, 0x74, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x17,
+	0x2e, 0x64, 0x72, 0x69, 0x70, 0x70, 0x33, 0x72, 0x2e, 0x50, 0x72, 0x69, 0x6e, 0x74, 0x65, 0x72,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0f, 0x2e, 0x64, 0x72, 0x69, 0x70, 0x70, 0x33,
+	0x72, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x41, 0x0a, 0x0d, 0x53, 0x74, 0x72, 0x65,
+	0x61, 0x6d, 0x43, 0x6f, 0x6e, 0x73, 0x6f, 0x6c, 0x65, 0x12, 0x17, 0x2e, 0x64, 0x72, 0x69, 0x70,
+	0x70, 0x33, 0x72, 0x2e, 0x50, 0x72, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x15, 0x2e, 0x64, 0x72, 0x69, 0x70, 0x70, 0x33, 0x72, 0x2e, 0x43, 0x6f, 0x6e,
+	0x73, 0x6f, 0x6c, 0x65, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x30, 0x01, 0x42, 0x25, 0x5a, 0x23, 0x67,
+	0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6a, 0x75, 0x73, 0x74, 0x65, 0x72,
+	0x2f, 0x64, 0x72, 0x69, 0x70, 0x70, 0x33, 0x72, 0x2f, 0x64, 0x72, 0x69, 0x70, 0x70, 0x33, 0x72,
+	0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_dripp3r_proto_rawDescOnce sync.Once
+	file_dripp3r_proto_rawDescData = file_dripp3r_proto_rawDesc
+)
+
+func file_dripp3r_proto_rawDescGZIP() []byte {
+	file_dripp3r_proto_rawDescOnce.Do(func() {
+		file_dripp3r_proto_rawDescData = protoimpl.X.CompressGZIP(file_dripp3r_proto_rawDescData)
+	})
+	return file_dripp3r_proto_rawDescData
+}
+
+var file_dripp3r_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_dripp3r_proto_goTypes = []interface{}{
+	(*PrinterRequest)(nil),  // 0: dripp3r.PrinterRequest
+	(*StartJobRequest)(nil), // 1: dripp3r.StartJobRequest
+	(*Reply)(nil),           // 2: dripp3r.Reply
+	(*Temps)(nil),           // 3: dripp3r.Temps
+	(*Position)(nil),        // 4: dripp3r.Position
+	(*Status)(nil),          // 5: dripp3r.Status
+	(*ConsoleEvent)(nil),    // 6: dripp3r.ConsoleEvent
+}
+var file_dripp3r_proto_depIdxs = []int32{
+	3,  // 0: dripp3r.Status.temps:type_name -> dripp3r.Temps
+	4,  // 1: dripp3r.Status.position:type_name -> dripp3r.Position
+	3,  // 2: dripp3r.ConsoleEvent.temps:type_name -> dripp3r.Temps
+	5,  // 3: dripp3r.ConsoleEvent.status:type_name -> dripp3r.Status
+	1,  // 4: dripp3r.Dripper.StartJob:input_type -> dripp3r.StartJobRequest
+	0,  // 5: dripp3r.Dripper.Pause:input_type -> dripp3r.PrinterRequest
+	0,  // 6: dripp3r.Dripper.Resume:input_type -> dripp3r.PrinterRequest
+	0,  // 7: dripp3r.Dripper.Cancel:input_type -> dripp3r.PrinterRequest
+	0,  // 8: dripp3r.Dripper.GetStatus:input_type -> dripp3r.PrinterRequest
+	0,  // 9: dripp3r.Dripper.StreamConsole:input_type -> dripp3r.PrinterRequest
+	2,  // 10: dripp3r.Dripper.StartJob:output_type -> dripp3r.Reply
+	2,  // 11: dripp3r.Dripper.Pause:output_type -> dripp3r.Reply
+	2,  // 12: dripp3r.Dripper.Resume:output_type -> dripp3r.Reply
+	2,  // 13: dripp3r.Dripper.Cancel:output_type -> dripp3r.Reply
+	5,  // 14: dripp3r.Dripper.GetStatus:output_type -> dripp3r.Status
+	6,  // 15: dripp3r.Dripper.StreamConsole:output_type -> dripp3r.ConsoleEvent
+	10, // [10:16] is the sub-list for method output_type
+	4,  // [4:10] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_dripp3r_proto_init() }
+func file_dripp3r_proto_init() {
+	if File_dripp3r_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_dripp3r_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PrinterRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_dripp3r_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StartJobRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_dripp3r_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Reply); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_dripp3r_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Temps); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_dripp3r_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Position); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_dripp3r_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Status); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_dripp3r_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ConsoleEvent); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_dripp3r_proto_msgTypes[6].OneofWrappers = []interface{}{
+		(*ConsoleEvent_Line)(nil),
+		(*ConsoleEvent_Temps)(nil),
+		(*ConsoleEvent_Status)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_dripp3r_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_dripp3r_proto_goTypes,
+		DependencyIndexes: file_dripp3r_proto_depIdxs,
+		MessageInfos:      file_dripp3r_proto_msgTypes,
+	}.Build()
+	File_dripp3r_proto = out.File
+	file_dripp3r_proto_rawDesc = nil
+	file_dripp3r_proto_goTypes = nil
+	file_dripp3r_proto_depIdxs = nil
+}
@@ -0,0 +1,338 @@
+// The daemon's gRPC API, served with -grpc, for front ends that would rather
+// call typed methods than speak JSON-RPC on the control socket or scrape the
+// Moonraker API. Each request names the printer when the daemon runs several.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: dripp3r.proto
+
+package dripp3rpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Dripper_StartJob_FullMethodName      = "/dripp3r.Dripper/StartJob"
+	Dripper_Pause_FullMethodName         = "/dripp3r.Dripper/Pause"
+	Dripper_Resume_FullMethodName        = "/dripp3r.Dripper/Resume"
+	Dripper_Cancel_FullMethodName        = "/dripp3r.Dripper/Cancel"
+	Dripper_GetStatus_FullMethodName     = "/dripp3r.Dripper/GetStatus"
+	Dripper_StreamConsole_FullMethodName = "/dripp3r.Dripper/StreamConsole"
+)
+
+// DripperClient is the client API for Dripper service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DripperClient interface {
+	// StartJob prints a GCode file in the daemon's uploads directory, named by
+	// its path there, e.g. one sent with -http, or queues it if a job is
+	// printing.
+	StartJob(ctx context.Context, in *StartJobRequest, opts ...grpc.CallOption) (*Reply, error)
+	Pause(ctx context.Context, in *PrinterRequest, opts ...grpc.CallOption) (*Reply, error)
+	Resume(ctx context.Context, in *PrinterRequest, opts ...grpc.CallOption) (*Reply, error)
+	// Cancel stops the job and drips the stop GCodes.
+	Cancel(ctx context.Context, in *PrinterRequest, opts ...grpc.CallOption) (*Reply, error)
+	GetStatus(ctx context.Context, in *PrinterRequest, opts ...grpc.CallOption) (*Status, error)
+	// StreamConsole streams the console, the temperatures and the status as
+	// they change, for one printer or, without a name, all of them.
+	StreamConsole(ctx context.Context, in *PrinterRequest, opts ...grpc.CallOption) (Dripper_StreamConsoleClient, error)
+}
+
+type dripperClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDripperClient(cc grpc.ClientConnInterface) DripperClient {
+	return &dripperClient{cc}
+}
+
+func (c *dripperClient) StartJob(ctx context.Context, in *StartJobRequest, opts ...grpc.CallOption) (*Reply, error) {
+	out := new(Reply)
+	err := c.cc.Invoke(ctx, Dripper_StartJob_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dripperClient) Pause(ctx context.Context, in *PrinterRequest, opts ...grpc.CallOption) (*Reply, error) {
+	out := new(Reply)
+	err := c.cc.Invoke(ctx, Dripper_Pause_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dripperClient) Resume(ctx context.Context, in *PrinterRequest, opts ...grpc.CallOption) (*Reply, error) {
+	out := new(Reply)
+	err := c.cc.Invoke(ctx, Dripper_Resume_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dripperClient) Cancel(ctx context.Context, in *PrinterRequest, opts ...grpc.CallOption) (*Reply, error) {
+	out := new(Reply)
+	err := c.cc.Invoke(ctx, Dripper_Cancel_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dripperClient) GetStatus(ctx context.Context, in *PrinterRequest, opts ...grpc.CallOption) (*Status, error) {
+	out := new(Status)
+	err := c.cc.Invoke(ctx, Dripper_GetStatus_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dripperClient) StreamConsole(ctx context.Context, in *PrinterRequest, opts ...grpc.CallOption) (Dripper_StreamConsoleClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Dripper_ServiceDesc.Streams[0], Dripper_StreamConsole_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &dripperStreamConsoleClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Dripper_StreamConsoleClient interface {
+	Recv() (*ConsoleEvent, error)
+	grpc.ClientStream
+}
+
+type dripperStreamConsoleClient struct {
+	grpc.ClientStream
+}
+
+func (x *dripperStreamConsoleClient) Recv() (*ConsoleEvent, error) {
+	m := new(ConsoleEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DripperServer is the server API for Dripper service.
+// All implementations must embed UnimplementedDripperServer
+// for forward compatibility
+type DripperServer interface {
+	// StartJob prints a GCode file in the daemon's uploads directory, named by
+	// its path there, e.g. one sent with -http, or queues it if a job is
+	// printing.
+	StartJob(context.Context, *StartJobRequest) (*Reply, error)
+	Pause(context.Context, *PrinterRequest) (*Reply, error)
+	Resume(context.Context, *PrinterRequest) (*Reply, error)
+	// Cancel stops the job and drips the stop GCodes.
+	Cancel(context.Context, *PrinterRequest) (*Reply, error)
+	GetStatus(context.Context, *PrinterRequest) (*Status, error)
+	// StreamConsole streams the console, the temperatures and the status as
+	// they change, for one printer or, without a name, all of them.
+	StreamConsole(*PrinterRequest, Dripper_StreamConsoleServer) error
+	mustEmbedUnimplementedDripperServer()
+}
+
+// UnimplementedDripperServer must be embedded to have forward compatible implementations.
+type UnimplementedDripperServer struct {
+}
+
+func (UnimplementedDripperServer) StartJob(context.Context, *StartJobRequest) (*Reply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartJob not implemented")
+}
+func (UnimplementedDripperServer) Pause(context.Context, *PrinterRequest) (*Reply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Pause not implemented")
+}
+func (UnimplementedDripperServer) Resume(context.Context, *PrinterRequest) (*Reply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Resume not implemented")
+}
+func (UnimplementedDripperServer) Cancel(context.Context, *PrinterRequest) (*Reply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Cancel not implemented")
+}
+func (UnimplementedDripperServer) GetStatus(context.Context, *PrinterRequest) (*Status, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatus not implemented")
+}
+func (UnimplementedDripperServer) StreamConsole(*PrinterRequest, Dripper_StreamConsoleServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamConsole not implemented")
+}
+func (UnimplementedDripperServer) mustEmbedUnimplementedDripperServer() {}
+
+// UnsafeDripperServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DripperServer will
+// result in compilation errors.
+type UnsafeDripperServer interface {
+	mustEmbedUnimplementedDripperServer()
+}
+
+func RegisterDripperServer(s grpc.ServiceRegistrar, srv DripperServer) {
+	s.RegisterService(&Dripper_ServiceDesc, srv)
+}
+
+func _Dripper_StartJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DripperServer).StartJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dripper_StartJob_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DripperServer).StartJob(ctx, req.(*StartJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dripper_Pause_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PrinterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DripperServer).Pause(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dripper_Pause_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DripperServer).Pause(ctx, req.(*PrinterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dripper_Resume_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PrinterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DripperServer).Resume(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dripper_Resume_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DripperServer).Resume(ctx, req.(*PrinterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dripper_Cancel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PrinterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DripperServer).Cancel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dripper_Cancel_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DripperServer).Cancel(ctx, req.(*PrinterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dripper_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PrinterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DripperServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dripper_GetStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DripperServer).GetStatus(ctx, req.(*PrinterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dripper_StreamConsole_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PrinterRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DripperServer).StreamConsole(m, &dripperStreamConsoleServer{stream})
+}
+
+type Dripper_StreamConsoleServer interface {
+	Send(*ConsoleEvent) error
+	grpc.ServerStream
+}
+
+type dripperStreamConsoleServer struct {
+	grpc.ServerStream
+}
+
+func (x *dripperStreamConsoleServer) Send(m *ConsoleEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Dripper_ServiceDesc is the grpc.ServiceDesc for Dripper service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Dripper_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "dripp3r.Dripper",
+	HandlerType: (*DripperServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "StartJob",
+			Handler:    _Dripper_StartJob_Handler,
+		},
+		{
+			MethodName: "Pause",
+			Handler:    _Dripper_Pause_Handler,
+		},
+		{
+			MethodName: "Resume",
+			Handler:    _Dripper_Resume_Handler,
+		},
+		{
+			MethodName: "Cancel",
+			Handler:    _Dripper_Cancel_Handler,
+		},
+		{
+			MethodName: "GetStatus",
+			Handler:    _Dripper_GetStatus_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamConsole",
+			Handler:       _Dripper_StreamConsole_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "dripp3r.proto",
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Set by -dry-run: the job goes to a stand-in for the printer rather than a
+// COM port, to try out the file, its transforms and the hooks and scripts
+// before the printer is tied up with it.
+var dry_run bool
+
+// Set by -timed: how many times faster than the printer a dry run goes. Each
+// line is answered once the printer would have carried it out, going by the
+// estimator (see estimate.go), rather than at once.
+var timed float64
+
+// ackPort stands in for the printer on a dry run, answering every line with
+// ok, and greeting the host when firmware that is reset on starting is.
+type ackPort struct {
+	fw      *dialect
+	est     *estimator
+	replies chan ackReply
+	r       *io.PipeReader
+	w       *io.PipeWriter
+}
+
+type ackReply struct {
+	data []byte
+	took time.Duration // how long the printer takes before answering
+}
+
+func newAckPort() *ackPort {
+	p := &ackPort{
+		fw:      startDialect(),
+		est:     newEstimator(),
+		replies: make(chan ackReply, 256),
+	}
+	p.r, p.w = io.Pipe()
+	go func() {
+		for r := range p.replies {
+			if timed > 0 {
+				time.Sleep(time.Duration(float64(r.took) / timed))
+				sim.advance(r.took)
+			}
+			if _, err := p.w.Write(r.data); err != nil {
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// Write answers each line, without waiting for the answer to be read, which
+// the host only does once it has sent the line.
+func (p *ackPort) Write(b []byte) (int, error) {
+	for _, ln := range bytes.SplitAfter(b, []byte("\n")) {
+		switch {
+		case len(ln) == 0:
+		case len(ln) == 1 && ln[0] == p.fw.reset:
+			p.replies <- ackReply{data: []byte("Grbl 1.1h ['$' for help]\n")}
+		case ln[len(ln)-1] != '\n':
+			// Other real-time bytes have nothing to answer.
+		default:
+			p.replies <- ackReply{[]byte("ok\n"), p.est.took(ln)}
+		}
+	}
+	return len(b), nil
+}
+
+func (p *ackPort) Read(b []byte) (int, error) {
+	return p.r.Read(b)
+}
+
+func (p *ackPort) Close() error {
+	return p.r.Close()
+}
+
+// simClock is how far a timed dry run has got, in the printer's time, and
+// when each layer started.
+type simClock struct {
+	mu     sync.Mutex
+	now    time.Duration
+	layers []simLayer
+}
+
+type simLayer struct {
+	n  int
+	at time.Duration
+}
+
+// The clock of a timed dry run, or nil.
+var sim *simClock
+
+func (s *simClock) advance(d time.Duration) {
+	s.mu.Lock()
+	s.now += d
+	s.mu.Unlock()
+}
+
+// layer notes that a layer started.
+func (s *simClock) layer(n int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.layers = append(s.layers, simLayer{n, s.now})
+	s.mu.Unlock()
+}
+
+// report shows when each layer would start and how long it would take.
+func (s *simClock) report(con *console) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	con.Printf("-- SCHEDULE: %v in all, going by the estimate\n",
+		s.now.Round(time.Second))
+	for i, l := range s.layers {
+		end := s.now
+		if i+1 < len(s.layers) {
+			end = s.layers[i+1].at
+		}
+		con.Printf("   layer %-4d at %-10v %v\n", l.n, l.at.Round(time.Second),
+			(end - l.at).Round(time.Second))
+	}
+}
+
+// checkTimed makes sure -timed goes with -dry-run or -mock, and starts the
+// dry run's clock.
+func checkTimed() error {
+	switch {
+	case timed < 0:
+		return fmt.Errorf("-timed %v: want how many times faster", timed)
+	case timed > 0 && !dry_run && !mock:
+		return errors.New("-timed goes with -dry-run or -mock")
+	case timed > 0 && dry_run:
+		sim = &simClock{}
+	}
+	return nil
+}
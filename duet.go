@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.bug.st/serial"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// openPort opens the serial port at path, or connects to a Duet over HTTP
+// if path is its URL, or starts the virtual printer with -mock.
+func openPort(path string) (io.ReadWriteCloser, error) {
+	if mock && path == mock_port {
+		return newMockPrinter(), nil
+	}
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return openDuet(path)
+	}
+	port, err := serial.Open(path, serial_mode)
+	deadline := time.Now().Add(retry_for)
+	for wait := retry_first; err != nil && mayClear(err); wait *= 2 {
+		left := time.Until(deadline)
+		if left <= 0 {
+			break
+		}
+		if wait > retry_most {
+			wait = retry_most
+		}
+		if wait > left {
+			wait = left
+		}
+		log.Printf("Opening %s: %v, trying again in %v", path, err,
+			wait.Round(time.Millisecond))
+		time.Sleep(wait)
+		port, err = serial.Open(path, serial_mode)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return port, nil
+}
+
+// Set by -retry: how long to keep trying to open a serial port that is busy
+// or not there yet, e.g. while the printer's USB is still enumerating.
+var retry_for time.Duration
+
+// How long to wait before trying to open the port again, doubling each time
+// up to the most.
+const (
+	retry_first = 250 * time.Millisecond
+	retry_most  = 5 * time.Second
+)
+
+// mayClear tells whether opening a port failed for a reason that may clear
+// up by itself: another program has it, it isn't there yet, or it is but udev
+// has yet to let us use it.
+func mayClear(err error) bool {
+	var perr *serial.PortError
+	if errors.As(err, &perr) {
+		switch perr.Code() {
+		case serial.PortBusy, serial.PortNotFound, serial.PermissionDenied:
+			return true
+		}
+		return false
+	}
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// How often an idle Duet is asked for its replies, which also keeps the
+// session from timing out.
+const duet_poll = 2 * time.Second
+
+// The free space a Duet's GCode buffer needs before another line is sent,
+// enough for the longest lines.
+const duet_min_buffer = 100
+
+// duetConn drives a Duet over its HTTP interface as if it were a serial port:
+// each line written goes to rr_gcode, and is answered with whatever rr_reply
+// has to say followed by an ok, which the Duet's HTTP interface doesn't send.
+type duetConn struct {
+	base   string
+	client http.Client
+	key    string // the session key, on RepRapFirmware 3.5 and up
+	mu     sync.Mutex
+	r      *io.PipeReader
+	w      *io.PipeWriter
+	done   chan struct{}
+}
+
+// openDuet logs in to the Duet at base, with the [duet] password from the
+// config file if it has one.
+func openDuet(base string) (*duetConn, error) {
+	c := &duetConn{
+		base:   strings.TrimSuffix(base, "/"),
+		client: http.Client{Timeout: 10 * time.Second},
+		done:   make(chan struct{}),
+	}
+	var resp struct {
+		Err        int    `json:"err"`
+		SessionKey uint32 `json:"sessionKey"`
+	}
+	password := conf.get("duet", "password")
+	if password == "" {
+		password = "reprap"
+	}
+	err := c.get("rr_connect", url.Values{
+		"password": {password},
+		"time":     {time.Now().Format("2006-01-02T15:04:05")},
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.Err {
+	case 0:
+	case 1:
+		return nil, fmt.Errorf("%s: wrong password", base)
+	case 2:
+		return nil, fmt.Errorf("%s: no more sessions", base)
+	default:
+		return nil, fmt.Errorf("%s: rr_connect failed with %d", base, resp.Err)
+	}
+	if resp.SessionKey != 0 {
+		c.key = fmt.Sprint(resp.SessionKey)
+	}
+	c.r, c.w = io.Pipe()
+	go c.poll()
+	return c, nil
+}
+
+// get calls one of the Duet's rr_ requests, decoding its JSON into v, or
+// returning the text it answered with if v is a *string.
+func (c *duetConn) get(req string, args url.Values, v interface{}) error {
+	r, err := http.NewRequest("GET", c.base+"/"+req+"?"+args.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if c.key != "" {
+		r.Header.Set("X-Session-Key", c.key)
+	}
+	resp, err := c.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", req, resp.Status)
+	}
+	if s, ok := v.(*string); ok {
+		b, err := io.ReadAll(resp.Body)
+		*s = string(b)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Write sends each line to the Duet, once there is room for it in its
+// buffer, and answers it.
+func (c *duetConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if err := c.send(line); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (c *duetConn) send(line string) error {
+	var resp struct {
+		Buff int `json:"buff"`
+	}
+	if err := c.get("rr_gcode", url.Values{"gcode": {line}}, &resp); err != nil {
+		return err
+	}
+	for resp.Buff < duet_min_buffer {
+		time.Sleep(100 * time.Millisecond)
+		if err := c.get("rr_gcode", url.Values{"gcode": {""}}, &resp); err != nil {
+			return err
+		}
+	}
+	if err := c.reply(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(c.w, "ok\n")
+	return err
+}
+
+// reply passes on what the Duet has said since it was last asked.
+func (c *duetConn) reply() error {
+	var s string
+	if err := c.get("rr_reply", nil, &s); err != nil {
+		return err
+	}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	_, err := io.WriteString(c.w, s+"\n")
+	return err
+}
+
+// poll passes on what the Duet says while no lines are sent, and keeps the
+// session alive.
+func (c *duetConn) poll() {
+	t := time.NewTicker(duet_poll)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+		}
+		c.mu.Lock()
+		err := c.reply()
+		c.mu.Unlock()
+		if err != nil {
+			c.w.CloseWithError(err)
+			return
+		}
+	}
+}
+
+func (c *duetConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// Close logs out of the Duet.
+func (c *duetConn) Close() error {
+	close(c.done)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.w.CloseWithError(errors.New("disconnected"))
+	var resp struct {
+		Err int `json:"err"`
+	}
+	return c.get("rr_disconnect", nil, &resp)
+}
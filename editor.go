@@ -0,0 +1,534 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// How many lines of history are loaded from the history file.
+const max_history = 500
+
+// editor reads the keyboard when it is a terminal, so lines typed in hacker
+// mode can be edited and recalled. The line being typed stays at the bottom
+// of the screen: everything printed to stdout goes through the editor, which
+// prints it above the line.
+//
+//	Left/Right, Home/End     move along the line (also Ctrl-B/F, Ctrl-A/E)
+//	Up/Down                  recall earlier lines (also Ctrl-P/N)
+//	Backspace, Delete        delete a character (also Ctrl-D)
+//	Ctrl-U, Ctrl-K, Ctrl-W   delete to the start, to the end, a word
+//	Ctrl-R                   search the history, again for older lines
+//	Tab                      complete a GCode or macro, or list the choices
+//
+// In hacker mode, the parameters of the GCode being typed are hinted after
+// the line.
+type editor struct {
+	mu      sync.Mutex
+	out     *os.File // the terminal, stdout is a pipe to copyOutput
+	prompt  string
+	status  string // shown dimmed when there is no prompt
+	title   string // the terminal's title, once set
+	buf     []rune
+	pos     int
+	partial bool // output is in the middle of a line
+	shown   bool // the line is on the screen
+
+	history  []string
+	hist_i   int    // line of history shown, len(history) for a new line
+	draft    []rune // the new line, while browsing history
+	search   []rune // Ctrl-R search, nil when not searching
+	found    int    // line of history the search matched, or -1
+	hist_out *os.File
+
+	restore func()
+	output  *os.File // write end of the stdout pipe
+	done    chan struct{}
+}
+
+// The running editor, if any.
+var edit *editor
+
+// newEditor starts editing input from f, if both f and stdout are
+// terminals. It returns nil otherwise.
+func newEditor(f *os.File) *editor {
+	if _, _, err := termSize(os.Stdout); err != nil {
+		return nil
+	}
+	restore, err := cbreak(f)
+	if err != nil {
+		return nil
+	}
+	e := startEditor(restore)
+	if e == nil {
+		restore()
+		return nil
+	}
+	e.loadHistory()
+	return e
+}
+
+// newStatusLine keeps the status line at the bottom of the screen when
+// stdout is a terminal but there is no keyboard to edit lines from, e.g. for
+// "dripp3r monitor" or with the keyboard redirected. It returns nil
+// otherwise.
+func newStatusLine() *editor {
+	if edit != nil {
+		return edit
+	}
+	if json_out {
+		return nil
+	}
+	if _, _, err := termSize(os.Stdout); err != nil {
+		return nil
+	}
+	return startEditor(func() {})
+}
+
+// startEditor sends stdout through the editor. restore puts the keyboard
+// back.
+func startEditor(restore func()) *editor {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil
+	}
+	e := &editor{
+		out:     os.Stdout,
+		restore: restore,
+		output:  w,
+		done:    make(chan struct{}),
+	}
+	os.Stdout = w
+	if _, _, err := termSize(os.Stderr); err == nil {
+		log.SetOutput(w)
+	}
+	go e.copyOutput(r)
+	edit = e
+	return e
+}
+
+// closeEditor puts the terminal back the way it was, once the output has
+// been printed.
+func closeEditor() {
+	e := edit
+	if e == nil {
+		return
+	}
+	edit = nil
+	os.Stdout = e.out
+	log.SetOutput(os.Stderr)
+	e.output.Close()
+	<-e.done
+	e.mu.Lock()
+	if e.shown {
+		e.out.WriteString("\r\x1b[K")
+	}
+	if e.title != "" {
+		// Put back the title the terminal had.
+		e.out.WriteString("\x1b[23;0t")
+	}
+	e.mu.Unlock()
+	e.restore()
+	if e.hist_out != nil {
+		e.hist_out.Close()
+	}
+}
+
+// setPrompt sets the prompt shown in front of the line being typed, e.g. in
+// hacker mode. Without a prompt there is no line: each key is sent as soon as
+// it is pressed, for menus and hotkeys.
+func setPrompt(prompt string) {
+	e := edit
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.prompt = prompt
+	e.draw()
+	e.mu.Unlock()
+}
+
+// setStatus sets the status line, shown at the bottom of the screen while
+// there is no line being typed.
+func setStatus(status string) {
+	e := edit
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.status = status
+	e.draw()
+	e.mu.Unlock()
+}
+
+// setTitle sets the terminal's title, e.g. of the window or tmux pane. The
+// title it had is saved the first time, where the terminal can, and put
+// back by closeEditor.
+func setTitle(title string) {
+	e := edit
+	if e == nil {
+		return
+	}
+	// A file's name could end the title early.
+	title = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, title)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if title == e.title {
+		return
+	}
+	if e.title == "" {
+		e.out.WriteString("\x1b[22;0t")
+	}
+	e.title = title
+	fmt.Fprintf(e.out, "\x1b]0;%s\x07", title)
+}
+
+func historyPath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "dripp3r", "history")
+}
+
+// loadHistory reads the history file, and opens it to add new lines.
+func (e *editor) loadHistory() {
+	path := historyPath()
+	if path == "" {
+		return
+	}
+	if f, err := os.Open(path); err == nil {
+		scan := bufio.NewScanner(f)
+		for scan.Scan() {
+			e.history = append(e.history, scan.Text())
+		}
+		f.Close()
+		if len(e.history) > max_history {
+			e.history = e.history[len(e.history)-max_history:]
+		}
+	}
+	e.hist_i = len(e.history)
+	os.MkdirAll(filepath.Dir(path), 0700)
+	e.hist_out, _ = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+}
+
+// copyOutput prints what is written to stdout above the line being typed.
+func (e *editor) copyOutput(r *os.File) {
+	defer close(e.done)
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			e.mu.Lock()
+			if e.shown {
+				e.out.WriteString("\r\x1b[K")
+				e.shown = false
+			}
+			e.out.Write(buf[:n])
+			e.partial = buf[n-1] != '\n'
+			e.draw()
+			e.mu.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// draw redraws the line being typed. The caller holds e.mu.
+func (e *editor) draw() {
+	// Wait for the rest of a line of output.
+	if e.partial {
+		return
+	}
+	prompt, line, pos := e.prompt, e.buf, e.pos
+	if e.search != nil {
+		prompt = fmt.Sprintf("(reverse-i-search)`%s': ", string(e.search))
+		line, pos = nil, 0
+		if e.found >= 0 {
+			line = []rune(e.history[e.found])
+			pos = len(line)
+		}
+	}
+	if prompt == "" && len(line) == 0 {
+		if e.status != "" {
+			fmt.Fprintf(e.out, "\r\x1b[K\x1b[2m%s\x1b[0m", e.fit(e.status, 0))
+			e.shown = true
+		} else if e.shown {
+			e.out.WriteString("\r\x1b[K")
+			e.shown = false
+		}
+		return
+	}
+	fmt.Fprintf(e.out, "\r\x1b[K%s%s", prompt, string(line))
+	e.shown = true
+	back := len(line) - pos
+	if hint := e.hint(); hint != "" && back == 0 {
+		// Dimmed, and cut to fit on the line.
+		if hint = e.fit(hint, len(prompt)+len(line)+2); len(hint) > 3 {
+			fmt.Fprintf(e.out, "  \x1b[2m%s\x1b[0m", hint)
+			back = len(hint) + 2
+		}
+	}
+	if back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+// fit cuts s to fit on the screen after used columns, leaving the last
+// column free so the terminal doesn't wrap.
+func (e *editor) fit(s string, used int) string {
+	cols, _, _ := termSize(e.out)
+	if cols == 0 {
+		cols = 80
+	}
+	room := cols - used - 1
+	if room < 0 {
+		room = 0
+	}
+	if len(s) > room {
+		s = s[:room]
+	}
+	return s
+}
+
+// hint returns the hint for the command being typed in hacker mode.
+func (e *editor) hint() string {
+	if e.prompt == "" || e.search != nil {
+		return ""
+	}
+	f := strings.Fields(string(e.buf))
+	if len(f) == 0 {
+		return ""
+	}
+	return gcodeHint(f[0])
+}
+
+// complete completes the command at the start of the line, as far as the
+// choices agree. If it can't go further the choices are listed.
+func (e *editor) complete() {
+	if e.prompt == "" {
+		return
+	}
+	word := string(e.buf[:e.pos])
+	if strings.ContainsRune(word, ' ') {
+		return
+	}
+	found := completions(word)
+	if len(found) == 0 {
+		return
+	}
+	common := found[0]
+	for _, c := range found[1:] {
+		for !strings.HasPrefix(strings.ToUpper(c), strings.ToUpper(common)) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(found) == 1 {
+		common += " "
+	}
+	if len(common) > len(word) {
+		e.buf = append([]rune(common), e.buf[e.pos:]...)
+		e.pos = len(common)
+		return
+	}
+	if e.shown {
+		e.out.WriteString("\r\x1b[K")
+		e.shown = false
+	}
+	for _, c := range found {
+		fmt.Fprintf(e.out, "%-10s %s\n", c, gcodeHint(c))
+	}
+}
+
+// run reads keys from f and edits lines until Enter sends them, or sends
+// keys one at a time when there is no prompt.
+func (e *editor) run(f *os.File) (<-chan string, <-chan error) {
+	out := make(chan string)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(out)
+		r := bufio.NewReader(f)
+		for {
+			k, err := readKey(r)
+			if err != nil {
+				errc <- err
+				return
+			}
+			e.mu.Lock()
+			var line string
+			var enter bool
+			if e.prompt == "" {
+				line, enter = e.hotKey(k)
+			} else {
+				line, enter = e.key(k)
+			}
+			e.draw()
+			e.mu.Unlock()
+			if enter {
+				out <- line
+			}
+		}
+	}()
+	return out, errc
+}
+
+// key edits the line for a key. It returns the line when Enter is pressed.
+func (e *editor) key(k key) (string, bool) {
+	if e.search != nil && !e.searchKey(k) {
+		return "", false
+	}
+	switch k {
+	case '\r', '\n':
+		return e.enter(), true
+	case keyBackspace, ctrl('h'):
+		if e.pos > 0 {
+			e.buf = append(e.buf[:e.pos-1], e.buf[e.pos:]...)
+			e.pos--
+		}
+	case keyDelete, ctrl('d'):
+		if e.pos < len(e.buf) {
+			e.buf = append(e.buf[:e.pos], e.buf[e.pos+1:]...)
+		}
+	case keyLeft, ctrl('b'):
+		if e.pos > 0 {
+			e.pos--
+		}
+	case keyRight, ctrl('f'):
+		if e.pos < len(e.buf) {
+			e.pos++
+		}
+	case keyHome, ctrl('a'):
+		e.pos = 0
+	case keyEnd, ctrl('e'):
+		e.pos = len(e.buf)
+	case ctrl('u'):
+		e.buf = append([]rune(nil), e.buf[e.pos:]...)
+		e.pos = 0
+	case ctrl('k'):
+		e.buf = e.buf[:e.pos]
+	case ctrl('w'):
+		i := e.pos
+		for i > 0 && e.buf[i-1] == ' ' {
+			i--
+		}
+		for i > 0 && e.buf[i-1] != ' ' {
+			i--
+		}
+		e.buf = append(e.buf[:i], e.buf[e.pos:]...)
+		e.pos = i
+	case keyUp, ctrl('p'):
+		if e.hist_i == len(e.history) {
+			e.draft = e.buf
+		}
+		if e.hist_i > 0 {
+			e.hist_i--
+			e.setLine(e.history[e.hist_i])
+		}
+	case keyDown, ctrl('n'):
+		if e.hist_i < len(e.history) {
+			e.hist_i++
+			if e.hist_i == len(e.history) {
+				e.setLine(string(e.draft))
+			} else {
+				e.setLine(e.history[e.hist_i])
+			}
+		}
+	case ctrl('r'):
+		e.search = []rune{}
+		e.found = -1
+	case '\t':
+		e.complete()
+	default:
+		if k >= ' ' {
+			e.buf = append(e.buf[:e.pos], append([]rune{rune(k)}, e.buf[e.pos:]...)...)
+			e.pos++
+		}
+	}
+	return "", false
+}
+
+// hotKey returns a key pressed when there is no line as the line, by name
+// if it isn't a rune.
+func (e *editor) hotKey(k key) (string, bool) {
+	if _, ok := key_names[k]; ok || k >= ' ' && k != keyBackspace {
+		return k.String(), true
+	}
+	return "", false
+}
+
+// searchKey handles a key during a Ctrl-R search. It returns true if the
+// search is over and the key should edit the line as usual.
+func (e *editor) searchKey(k key) bool {
+	switch k {
+	case ctrl('r'):
+		e.find(e.found - 1)
+		return false
+	case keyBackspace, ctrl('h'):
+		if len(e.search) > 0 {
+			e.search = e.search[:len(e.search)-1]
+			e.find(len(e.history) - 1)
+		}
+		return false
+	case ctrl('g'), keyEsc:
+		e.search = nil
+		return false
+	}
+	if k >= ' ' {
+		e.search = append(e.search, rune(k))
+		if e.found < 0 {
+			e.find(len(e.history) - 1)
+		} else {
+			e.find(e.found)
+		}
+		return false
+	}
+	// Any other key takes the match and carries on.
+	if e.found >= 0 {
+		e.hist_i = e.found
+		e.setLine(e.history[e.found])
+	}
+	e.search = nil
+	return true
+}
+
+// find searches the history backwards from line i.
+func (e *editor) find(i int) {
+	for ; i >= 0; i-- {
+		if strings.Contains(e.history[i], string(e.search)) {
+			e.found = i
+			return
+		}
+	}
+}
+
+func (e *editor) setLine(s string) {
+	e.buf = []rune(s)
+	e.pos = len(e.buf)
+}
+
+// enter leaves the typed line on the screen, adds it to the history and
+// starts a new one.
+func (e *editor) enter() string {
+	line := string(e.buf)
+	fmt.Fprintf(e.out, "\r\x1b[K%s%s\n", e.prompt, line)
+	e.shown = false
+	if line != "" && (len(e.history) == 0 || e.history[len(e.history)-1] != line) {
+		e.history = append(e.history, line)
+		if e.hist_out != nil {
+			fmt.Fprintln(e.hist_out, line)
+		}
+	}
+	e.hist_i = len(e.history)
+	e.buf, e.pos, e.draft = nil, 0, nil
+	return line
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ctlEEPROM backs up the printer's settings to a file, or stdout, or
+// restores them from one, e.g. after flashing new firmware:
+//
+//	dripp3r eeprom dump ender.gcode
+//	dripp3r eeprom restore ender.gcode
+//
+// The backup is the GCode M503 reports, so it can be read and edited.
+func ctlEEPROM(sock, printer string, args []string) error {
+	params := ctlParams{Printer: printer}
+	switch {
+	case len(args) >= 1 && len(args) <= 2 && args[0] == "dump":
+		var settings []string
+		if err := ctlCall(sock, "settings", params, &settings); err != nil {
+			return err
+		}
+		if len(args) == 1 {
+			writeSettings(os.Stdout, settings)
+			return nil
+		}
+		f, err := os.Create(args[1])
+		if err != nil {
+			return err
+		}
+		writeSettings(f, settings)
+		return f.Close()
+	case len(args) == 2 && args[0] == "restore":
+		f, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		lines, err := readSettings(f)
+		if err != nil {
+			return err
+		}
+		for _, ln := range append(lines, "M500") {
+			params.GCode = ln
+			if err := ctlCall(sock, "gcode", params, new(string)); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("restored %d settings and saved them with M500\n", len(lines))
+		return nil
+	}
+	usage()
+	return nil
+}
+
+func writeSettings(w io.Writer, settings []string) {
+	fmt.Fprintf(w, "; dripp3r eeprom dump, %s\n", time.Now().Format(time.RFC1123))
+	for _, s := range settings {
+		fmt.Fprintln(w, s)
+	}
+}
+
+// readSettings reads the GCode lines of a backup, leaving out comments.
+func readSettings(r io.Reader) ([]string, error) {
+	var lines []string
+	scan := bufio.NewScanner(r)
+	for scan.Scan() {
+		ln, _, _ := strings.Cut(scan.Text(), ";")
+		if ln = strings.TrimSpace(ln); ln != "" {
+			lines = append(lines, ln)
+		}
+	}
+	return lines, scan.Err()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Steps of the extruder calibration.
+const (
+	estepsOff = iota
+	estepsHeating
+	estepsMeasuring
+	estepsSaving
+)
+
+// The filament is marked esteps_mark mm above the extruder and
+// esteps_length mm is pushed through, slowly so it doesn't slip.
+const (
+	esteps_mark   = 120
+	esteps_length = 100
+	esteps_feed   = 100
+)
+
+// startESteps calibrates the extruder's steps per mm: heat up, extrude
+// 100mm, measure how much really went through, and set M92 E to match.
+func (d *dripper) startESteps() (string, error) {
+	if !isPrinter() {
+		return "", errNotPrinter
+	}
+	if d.gcode != nil && !d.paused {
+		return "", errors.New("pause the job before calibrating")
+	}
+	_, t, err := findPreset("PLA")
+	if err != nil {
+		return "", err
+	}
+	d.esteps = estepsHeating
+	d.esteps_old = 0
+	d.hack_queue = append(d.hack_queue, "M92",
+		fmt.Sprintf("M109 S%g", t.HotendTarget))
+	d.con.Printf("-- E-STEPS: Heating to %g. Mark the filament %dmm above "+
+		"the extruder, then press g to extrude %dmm, or q to stop.\n",
+		t.HotendTarget, esteps_mark, esteps_length)
+	return "calibrating e-steps", nil
+}
+
+// eStepsKey handles a key, or the length typed, while calibrating.
+func (d *dripper) eStepsKey(k string) (string, error) {
+	if k == "q" || k == "esc" {
+		d.esteps = estepsOff
+		setPrompt("")
+		d.con.Println("-- END E-STEPS")
+		return "done calibrating", nil
+	}
+	switch d.esteps {
+	case estepsHeating:
+		if k != "g" {
+			break
+		}
+		if d.esteps_old == 0 {
+			return "", errors.New("the printer has not reported its steps per mm (M92)")
+		}
+		d.esteps = estepsMeasuring
+		d.hack_queue = append(d.hack_queue, "M83",
+			fmt.Sprintf("G1 E%d F%d", esteps_length, esteps_feed))
+		if !d.relative_e {
+			d.hack_queue = append(d.hack_queue, "M82")
+		}
+		d.con.Printf("-- E-STEPS: Extruding %dmm. When it stops, measure from "+
+			"the extruder to the mark and enter the mm left.\n", esteps_length)
+		setPrompt("mm left> ")
+		return "extruding", nil
+	case estepsMeasuring:
+		left, err := strconv.ParseFloat(k, 64)
+		if err != nil || left < 0 || left >= esteps_mark {
+			return "", fmt.Errorf("enter the mm left to the mark, not %q, or q to stop", k)
+		}
+		setPrompt("")
+		d.esteps = estepsSaving
+		d.esteps_new = d.esteps_old * esteps_length / (esteps_mark - left)
+		d.hack_queue = append(d.hack_queue, fmt.Sprintf("M92 E%.2f", d.esteps_new))
+		d.con.Printf("-- E-STEPS %.2f, were %.2f: %gmm went through. Press s "+
+			"to save, or q to keep them until the printer is reset.\n",
+			d.esteps_new, d.esteps_old, esteps_mark-left)
+		return fmt.Sprintf("e-steps %.2f", d.esteps_new), nil
+	case estepsSaving:
+		if k != "s" {
+			break
+		}
+		d.esteps = estepsOff
+		d.hack_queue = append(d.hack_queue, "M500")
+		d.con.Printf("-- E-STEPS SAVED %.2f\n", d.esteps_new)
+		return "saved", nil
+	}
+	return "", fmt.Errorf("%w %q calibrating", errNoHotkey, k)
+}
+
+// trackESteps picks up the extruder's steps per mm from the reply to M92
+// or M503.
+func (d *dripper) trackESteps(ln string) {
+	for _, s := range parseSettings([]string{ln}) {
+		if s.code != "M92" {
+			continue
+		}
+		for _, p := range s.params {
+			if p[0] != 'E' {
+				continue
+			}
+			if e, err := strconv.ParseFloat(p[1:], 64); err == nil {
+				d.esteps_old = e
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// estimator works out how long the printer takes over each line, for a
+// timed dry run: moves speed up and slow down at a constant acceleration,
+// from and to a standstill, which errs on the slow side.
+type estimator struct {
+	pos        position
+	feed       float64 // mm/s
+	accel      float64 // mm/s²
+	relative   bool
+	relative_e bool
+}
+
+// How fast moves go before the file sets a feedrate, and how fast they speed
+// up before it sets an acceleration (M204), as in Marlin's defaults.
+const (
+	default_feed  = 50.0
+	default_accel = 1000.0
+)
+
+// How long homing is taken to take.
+const home_time = 10 * time.Second
+
+func newEstimator() *estimator {
+	return &estimator{feed: default_feed, accel: default_accel}
+}
+
+// gcodeWords splits a line into its command, e.g. G1, and its parameters by
+// letter, with or without spaces between them.
+func gcodeWords(line string) (string, map[byte]float64) {
+	var cmd string
+	args := make(map[byte]float64)
+	for i := 0; i < len(line); {
+		c := line[i] &^ 0x20
+		j := i + 1
+		for j < len(line) && strings.IndexByte("+-.0123456789", line[j]) >= 0 {
+			j++
+		}
+		if c >= 'A' && c <= 'Z' {
+			if cmd == "" {
+				cmd = string(c) + line[i+1:j]
+			} else if v, err := strconv.ParseFloat(line[i+1:j], 64); err == nil {
+				args[c] = v
+			}
+		}
+		i = j
+	}
+	return cmd, args
+}
+
+// took returns how long the printer would take over a line, and follows it.
+func (e *estimator) took(line []byte) time.Duration {
+	cmd, args := gcodeWords(string(stripLineNumber(line)))
+	switch cmd {
+	// Arcs are taken as the straight line to where they end.
+	case "G0", "G1", "G2", "G3":
+		if f, ok := args['F']; ok && f > 0 {
+			e.feed = f / 60
+		}
+		var d [4]float64
+		for i, c := range []byte("XYZE") {
+			v, ok := args[c]
+			if !ok {
+				continue
+			}
+			p := e.pos.axis(c)
+			relative := e.relative
+			if c == 'E' {
+				relative = e.relative_e
+			}
+			if relative {
+				v += *p
+			}
+			d[i], *p = v-*p, v
+		}
+		dist := math.Sqrt(d[0]*d[0] + d[1]*d[1] + d[2]*d[2])
+		if dist == 0 {
+			dist = math.Abs(d[3])
+		}
+		return e.moveTime(dist)
+	case "G4":
+		return time.Duration(args['P']*float64(time.Millisecond) +
+			args['S']*float64(time.Second))
+	case "G28":
+		// Homing leaves the axes it names, or X, Y and Z, at 0. The names
+		// have no values, so gcodeWords drops them.
+		_, axes, _ := strings.Cut(strings.ToUpper(string(stripLineNumber(line))), "G28")
+		if !strings.ContainsAny(axes, "XYZ") {
+			axes = "XYZ"
+		}
+		for _, c := range []byte("XYZ") {
+			if strings.IndexByte(axes, c) >= 0 {
+				*e.pos.axis(c) = 0
+			}
+		}
+		return home_time
+	case "G90":
+		e.relative, e.relative_e = false, false
+	case "G91":
+		e.relative, e.relative_e = true, true
+	case "M82":
+		e.relative_e = false
+	case "M83":
+		e.relative_e = true
+	case "G92":
+		for c, v := range args {
+			if p := e.pos.axis(c); p != nil {
+				*p = v
+			}
+		}
+	case "M204":
+		for _, c := range []byte("PS") {
+			if a, ok := args[c]; ok && a > 0 {
+				e.accel = a
+			}
+		}
+	}
+	return 0
+}
+
+// moveTime is how long a move of dist mm takes, speeding up to the feedrate
+// if it is long enough to get there.
+func (e *estimator) moveTime(dist float64) time.Duration {
+	var s float64
+	if ramp := e.feed * e.feed / e.accel; dist >= ramp {
+		s = dist/e.feed + e.feed/e.accel
+	} else {
+		s = 2 * math.Sqrt(dist/e.accel)
+	}
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,70 @@
+package main
+
+import "sync"
+
+// An event is something that happened on a printer. The dripper publishes
+// each on its eventBus, and the console, the hooks and plugins, and anything
+// else that wants to know subscribe to it, rather than the code where it
+// happens calling each of them in turn.
+type event interface{}
+
+// The events a printer publishes, besides the hookEvents with no type of
+// their own, such as pause and runout.
+type (
+	jobStarted struct{ Job string }
+
+	// Err says why a job did not finish, or is nil if it did.
+	jobEnded struct {
+		Job string
+		Err error
+	}
+
+	// Quiet lines are hushed: they are shown only with -v.
+	lineSent struct {
+		Line  string // as sent, with any line number and checksum
+		Quiet bool
+	}
+	responseReceived struct {
+		Line  string
+		Quiet bool
+	}
+
+	layerChanged struct{ Layer int }
+	tempsUpdated struct{ temps }
+)
+
+// eventBus passes a printer's events to its subscribers, in the order they
+// subscribed, on the goroutine that publishes them. Subscribers must not
+// block: the serial link waits on them.
+type eventBus struct {
+	mu   sync.Mutex
+	subs []*func(event)
+}
+
+// subscribe calls fn with each event published from now on, until
+// unsubscribe is called.
+func (b *eventBus) subscribe(fn func(event)) (unsubscribe func()) {
+	sub := &fn
+	b.mu.Lock()
+	b.subs = append(b.subs, sub)
+	b.mu.Unlock()
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i, s := range b.subs {
+			if s == sub {
+				b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (b *eventBus) publish(ev event) {
+	b.mu.Lock()
+	subs := b.subs
+	b.mu.Unlock()
+	for _, fn := range subs {
+		(*fn)(ev)
+	}
+}
@@ -0,0 +1,594 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Set by -firmware: the kind of firmware the printer or machine runs, or
+// "auto" to tell from its answer to M115.
+var firmware = "marlin"
+
+// dialect is how a kind of firmware talks over the serial port.
+type dialect struct {
+	checksum  bool     // takes line numbers and checksums
+	rx_buffer int      // bytes its receive buffer holds, if known
+	counts    bool     // streamed by counting characters, not answers
+	cmd_size  int      // bytes its longest line takes, numbered, if known
+	replies   []string // prefixes of lines that end a reply, besides "ok"
+	reports   []string // prefixes of lines it sends by itself, not in a reply
+	reset     byte     // real-time reset, after which it greets the host
+	status    byte     // real-time status request, polled instead of M105
+	poll      string   // GCode polled for the status instead of M105
+	hold      byte     // real-time feed hold, sent on pausing
+	cycle     byte     // real-time cycle start, sent on resuming
+	recover   string   // GCode that clears a halt, if it stays up meanwhile
+	halts     string   // what it says on halting, when "!!" is only an error
+	greeting  string   // what it says first on starting up, e.g. when reset
+	busy      string   // what its busy messages start with
+	keepalive int      // seconds between them, set with M113, or 0
+}
+
+var dialects = map[string]*dialect{
+	// Marlin's receive buffer holds 128 bytes and its command buffer lines
+	// of 96, unless built otherwise.
+	"marlin": {
+		checksum:  true,
+		rx_buffer: 128,
+		cmd_size:  96,
+		reports:   []string{"T:"},
+		greeting:  "start",
+		busy:      "echo:busy:",
+		keepalive: 2,
+	},
+	// Marlin 1.x talks the same way as far as streaming goes; it is told
+	// apart so that what it lacks, e.g. busy messages before 1.1, can be.
+	"marlin1": {
+		checksum:  true,
+		rx_buffer: 128,
+		cmd_size:  96,
+		reports:   []string{"T:"},
+		greeting:  "start",
+		busy:      "echo:busy:",
+		keepalive: 2,
+	},
+	// Prusa-Firmware is Marlin 1.x underneath, but tells of its screen's
+	// state by itself, between the lines of a reply.
+	"prusa": {
+		checksum:  true,
+		rx_buffer: 128,
+		cmd_size:  96,
+		reports:   []string{"T:", "LCD status changed"},
+		greeting:  "start",
+		busy:      "echo:busy:",
+		keepalive: 2,
+	},
+	// Grbl answers each line with ok or error:N, and keeps reading lines
+	// into a 128 byte buffer meanwhile. Single bytes act at once, wherever
+	// they come in the stream.
+	"grbl": {
+		rx_buffer: 128,
+		counts:    true,
+		cmd_size:  80,
+		replies:   []string{"error:"},
+		reports:   []string{"<", "[MSG:"},
+		greeting:  "Grbl ",
+		reset:     0x18,
+		status:    '?',
+		hold:      '!',
+		cycle:     '~',
+	},
+	// RepRapFirmware reports its state as JSON: M408 answers with it on
+	// every version, and M409 with the object model on 3.x.
+	"reprap": {
+		checksum: true,
+		poll:     "M408 S0",
+	},
+	// Klipper's virtual serial port, /tmp/printer, takes lines without
+	// numbers. It tells of errors with !! before the ok, and says so when it
+	// shuts down, until FIRMWARE_RESTART. Other lines it sends by itself
+	// start with //.
+	"klipper": {
+		recover: "FIRMWARE_RESTART",
+		halts:   "shutdown",
+	},
+	// Repetier-Firmware numbers its oks, answers a line it already has with
+	// skip, and says wait once it has had nothing to do for a while. Fatal
+	// errors start with fatal: and M999 carries on after them.
+	"repetier": {
+		checksum: true,
+		replies:  []string{"skip"},
+		reports:  []string{"wait", "T:"},
+		recover:  "M999",
+		halts:    "fatal:",
+		greeting: "start",
+	},
+	// Smoothieware answers each line with ok, except once it has halted,
+	// e.g. on an alarm or the kill button: then it answers every line with !!
+	// until M999 clears the halt. It never asks for lines again, so they go
+	// without numbers.
+	"smoothie": {
+		replies:  []string{"!!"},
+		recover:  "M999",
+		greeting: "Smoothie",
+	},
+}
+
+// How firmware names itself in its answer to M115, e.g.
+// "FIRMWARE_NAME:Marlin 2.1.2 (Github) SOURCE_CODE_URL:...", by dialect.
+// The first that matches wins: Prusa-Firmware says it is based on Marlin.
+// Grbl has no M115, and is reset before anything is sent anyway, so it is
+// only ever driven with -firmware grbl.
+var firmware_names = []struct{ name, dialect string }{
+	{"prusa-firmware", "prusa"},
+	{"marlin 1.", "marlin1"},
+	{"marlin bugfix-1.", "marlin1"},
+	{"marlin", "marlin"},
+	{"repetier", "repetier"},
+	{"smoothieware", "smoothie"},
+	{"klipper", "klipper"},
+	{"reprapfirmware", "reprap"},
+}
+
+// Matches the firmware's name in its answer to M115, up to the next field.
+var firmware_name_re = regexp.MustCompile(`FIRMWARE_NAME:\s*(.*?)(?:,?\s+[A-Z_]+:|$)`)
+
+// firmwareName finds the firmware's name in its answer to M115, and the
+// dialect it speaks, if it is known.
+func firmwareName(resp []string) (string, string) {
+	for _, ln := range resp {
+		m := firmware_name_re.FindStringSubmatch(ln)
+		if m == nil {
+			continue
+		}
+		name := strings.TrimSuffix(m[1], ",")
+		for _, f := range firmware_names {
+			if strings.Contains(strings.ToLower(name), f.name) {
+				return name, f.dialect
+			}
+		}
+		return name, ""
+	}
+	return "", ""
+}
+
+// startDialect is the dialect the printer is talked to in at first: with
+// -firmware auto, Marlin's, until the printer says what it runs.
+func startDialect() *dialect {
+	if firmware == "auto" {
+		return dialects["marlin"]
+	}
+	return dialects[firmware]
+}
+
+// identify takes the printer's answer to M115, with -firmware auto, and
+// talks to it in its own dialect from then on.
+func (d *dripper) identify(resp []string) {
+	d.identifying = false
+	name, dialect := firmwareName(resp)
+	switch {
+	case name == "":
+		d.con.Println("-- FIRMWARE: not named, talking to it as Marlin")
+		return
+	case dialect == "":
+		d.con.Printf("-- FIRMWARE: %s is not known, talking to it as Marlin\n", name)
+		return
+	}
+	d.con.Printf("-- FIRMWARE: %s, talking to it as -firmware %s\n", name, dialect)
+	fw := dialects[dialect]
+	d.fw = fw
+	d.recv_fw.Store(fw)
+	d.checksum = d.checksum && fw.checksum
+	d.rx_buffer = 0
+	if err := d.readProfile(); err != nil {
+		d.con.Println("-- FIRMWARE:", err)
+	}
+	d.keepAlive()
+}
+
+// Set by -stream: how lines are sent ahead of their answers, by counting
+// "chars" against the receive buffer or by "lines" answered. Left empty, it
+// is whichever the firmware calls for.
+var stream = ""
+
+// checkFirmware makes sure -firmware names a dialect, and -stream a way of
+// sending lines ahead.
+func checkFirmware() error {
+	if startDialect() != nil {
+		switch stream {
+		case "", "chars", "lines":
+			return nil
+		}
+		return fmt.Errorf("-stream %s: want chars or lines", stream)
+	}
+	var names []string
+	for name := range dialects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("-firmware %s: want auto or one of %s", firmware,
+		strings.Join(names, ", "))
+}
+
+// readProfile takes the sizes of the printer's buffers from the [firmware]
+// config section, where its firmware was built with other than the defaults:
+// rx_buffer for RX_BUFFER_SIZE, bufsize for BUFSIZE and max_cmd_size for
+// MAX_CMD_SIZE, and keepalive for how often it sends busy messages. It then
+// decides how lines are sent ahead.
+func (d *dripper) readProfile() error {
+	keepalive, err := confInt(d.section("firmware", "keepalive"), "keepalive",
+		d.fw.keepalive)
+	if err != nil {
+		return err
+	}
+	// The stand-in for the printer on a dry run is never busy.
+	if d.fw.busy == "" || dry_run {
+		keepalive = 0
+	}
+	d.keepalive = keepalive
+	rx_buffer, err := confInt(d.section("firmware", "rx_buffer"), "rx_buffer",
+		d.fw.rx_buffer)
+	if err != nil {
+		return err
+	}
+	bufsize, err := confInt(d.section("firmware", "bufsize"), "bufsize", 0)
+	if err != nil {
+		return err
+	}
+	d.cmd_size, err = confInt(d.section("firmware", "max_cmd_size"),
+		"max_cmd_size", d.fw.cmd_size)
+	if err != nil {
+		return err
+	}
+	if bufsize > 0 {
+		d.win.most = bufsize
+	}
+	switch {
+	case stream == "chars" && rx_buffer == 0:
+		return fmt.Errorf("-stream chars: the size of %s's receive buffer is not known, set [firmware] rx_buffer", firmware)
+	case stream == "chars", stream == "" && d.fw.counts:
+		d.rx_buffer = rx_buffer
+	}
+	return nil
+}
+
+// String commands, whose spaces can't be dropped to shorten them.
+var string_gcodes = []string{"M23", "M28", "M30", "M32", "M117", "M118", "M928"}
+
+// fitLine makes a line short enough for the printer's command buffer by
+// dropping its spaces, which firmware doesn't need between words. It returns
+// nil if the line can't be made to fit.
+func (d *dripper) fitLine(line []byte) []byte {
+	if d.cmd_size == 0 || d.lineSize(line) < d.cmd_size {
+		return line
+	}
+	for _, cmd := range string_gcodes {
+		if isGCode(line, cmd) {
+			return nil
+		}
+	}
+	short := bytes.ReplaceAll(line, []byte(" "), nil)
+	if d.lineSize(short) >= d.cmd_size {
+		return nil
+	}
+	return short
+}
+
+// lineSize returns how many bytes the line takes in the printer's buffer
+// once it is numbered, with the byte that ends it.
+func (d *dripper) lineSize(line []byte) int {
+	if _, ok := parseM110(line); d.checksum && !ok {
+		return len(numberLine(d.line_no+1, line)) + 1
+	}
+	return len(line) + 1
+}
+
+// endsReply tells whether a line from the printer ends its reply to a line.
+func (fw *dialect) endsReply(ln string) bool {
+	if ln == "ok" || strings.HasPrefix(ln, "ok ") {
+		return true
+	}
+	for _, p := range fw.replies {
+		if strings.HasPrefix(ln, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// isFatal recognizes that the firmware has halted.
+func (fw *dialect) isFatal(ln string) bool {
+	if fw.halts != "" {
+		return strings.Contains(strings.ToLower(ln), fw.halts)
+	}
+	return isFatal(ln)
+}
+
+// isReport tells whether a line comes by itself rather than in a reply.
+func (fw *dialect) isReport(ln string) bool {
+	if fw.greets(ln) || fw.busy != "" && strings.HasPrefix(ln, fw.busy) {
+		return true
+	}
+	for _, p := range fw.reports {
+		if strings.HasPrefix(ln, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// greets tells whether a line is the firmware's greeting.
+func (fw *dialect) greets(ln string) bool {
+	return fw.greeting != "" && strings.HasPrefix(ln, fw.greeting)
+}
+
+// report takes in a line the firmware sent by itself.
+func (d *dripper) report(ln string) {
+	switch {
+	case d.fw.greets(ln):
+		d.greeted()
+	case strings.HasPrefix(ln, "T:"):
+		if t, ok := parseTemps(ln); ok {
+			d.setTemps(t)
+		}
+		d.heatReport(ln)
+	case strings.HasPrefix(ln, "<"):
+		d.grblStatus(ln)
+	case ln == "wait":
+		// Repetier has run out of lines, so the ok for the line it was sent
+		// got lost on the way, unless it said so before the line got there.
+		if len(d.in_flight) > 0 && d.rx_buffer == 0 &&
+			time.Since(d.sent_at) > repetier_wait {
+			d.con.Println("-- LOST OK: carrying on")
+			d.answered(d.answer(), nil)
+		}
+	}
+}
+
+// Repetier says wait after a second with nothing to do.
+const repetier_wait = 500 * time.Millisecond
+
+// How many keepalive intervals the printer may say nothing for, with a line
+// unanswered, before it is taken to have hung.
+const hang_intervals = 5
+
+// keepAlive asks firmware with busy messages to send them every so often
+// while a line takes long (M113), so that silence means it has hung.
+func (d *dripper) keepAlive() {
+	if d.keepalive > 0 {
+		d.hack_queue = append(d.hack_queue, fmt.Sprintf("M113 S%d", d.keepalive))
+	}
+}
+
+// checkHang warns when the printer has said nothing, not even that it is
+// busy, for hang_intervals keepalive intervals with a line unanswered: it
+// has hung, or the answer got lost on the way.
+func (d *dripper) checkHang() {
+	if d.keepalive == 0 || d.silent || len(d.in_flight) == 0 {
+		return
+	}
+	last := d.heard_at
+	if d.sent_at.After(last) {
+		last = d.sent_at
+	}
+	limit := time.Duration(d.keepalive*hang_intervals) * time.Second
+	if time.Since(last) < limit {
+		return
+	}
+	d.silent = true
+	d.con.Printf("-- PRINTER SILENT for %v with a line unanswered: it may have hung\n",
+		limit)
+	d.emitError(errors.New("the printer stopped answering"))
+}
+
+// greeted takes in the firmware's greeting. Once the printer has answered a
+// line, other than while booting, it means the firmware was reset, e.g. by
+// a brownout or its reset button, losing what it had in its buffers and
+// counting lines from 0 again.
+func (d *dripper) greeted() {
+	if d.settling {
+		d.greeted_at = time.Now()
+		return
+	}
+	if d.booting {
+		d.booting = false
+		d.ready = len(d.in_flight) == 0
+		return
+	}
+	if !d.heard {
+		return
+	}
+	d.con.Println("-- FIRMWARE RESET")
+	d.line_no, d.resend_from = 0, 0
+	if len(d.in_flight) > 0 {
+		d.in_flight, d.rx, d.backlog = nil, nil, nil
+		d.ready = true
+		d.fail(errors.New("the firmware was reset"))
+	}
+}
+
+// boot resets firmware that greets the host when it is, so nothing is sent
+// before it is ready to listen. Other firmware that greets the host when it
+// starts up, as Marlin does when opening the port resets the board, is given
+// time to finish its start-up messages first, e.g. while it reads the SD
+// card, since lines sent meanwhile are lost.
+func (d *dripper) boot() {
+	d.boot_at = time.Now()
+	switch {
+	case d.fw.reset != 0:
+		d.booting = true
+		d.realtime(d.fw.reset)
+	case d.fw.greeting != "" && !dry_run:
+		d.booting, d.settling = true, true
+	}
+}
+
+// How long firmware that greets the host is waited for, in case opening the
+// port reset it, and how long after its greeting it is given to start up.
+// Boards that aren't reset by opening the port say nothing.
+const (
+	boot_wait   = 2 * time.Second
+	settle_wait = time.Second
+)
+
+// Set by -connect-timeout: how long the firmware has to greet the host
+// after a reset, and to answer the handshake, before giving up on it.
+var connect_timeout time.Duration
+
+// Set by -read-timeout: how long the printer may say nothing that makes
+// sense, with a line unanswered, before giving up on it, or 0 to wait as
+// long as it takes.
+var read_timeout time.Duration
+
+// checkHandshake moves the handshake on once the firmware has started up,
+// and returns an error if it takes too long, saying what went wrong.
+func (d *dripper) checkHandshake() error {
+	switch {
+	case d.settling:
+		since, wait := d.boot_at, boot_wait
+		if !d.greeted_at.IsZero() {
+			since, wait = d.greeted_at, settle_wait
+		}
+		if time.Since(since) >= wait {
+			d.booting, d.settling = false, false
+			d.boot_at = time.Now()
+			d.ready = len(d.in_flight) == 0
+		}
+	case d.booting && time.Since(d.boot_at) > connect_timeout:
+		return fmt.Errorf("the firmware didn't greet us in %v after "+
+			"resetting it: is it switched on, on this port, and at %d baud?",
+			connect_timeout, serial_mode.BaudRate)
+	case d.shaking && time.Since(d.boot_at) > connect_timeout:
+		if !d.heard {
+			return fmt.Errorf("the printer answered nothing in %v: is it "+
+				"switched on, on this port, and at %d baud?",
+				connect_timeout, serial_mode.BaudRate)
+		}
+		return fmt.Errorf("the printer didn't answer M115 in %v", connect_timeout)
+	}
+	return nil
+}
+
+// checkReadTimeout returns an error once the printer has said nothing that
+// makes sense for -read-timeout with a line unanswered. Garbled lines, as
+// at the wrong baud rate, don't count.
+func (d *dripper) checkReadTimeout() error {
+	if read_timeout == 0 || d.booting || d.shaking || len(d.in_flight) == 0 {
+		return nil
+	}
+	last := d.heard_at
+	if d.sent_at.After(last) {
+		last = d.sent_at
+	}
+	if time.Since(last) < read_timeout {
+		return nil
+	}
+	return fmt.Errorf("the printer said nothing for %v with a line unanswered",
+		read_timeout)
+}
+
+// shook takes the printer's answer to M115, which ends the handshake. With
+// -firmware auto, it tells how to talk to the printer from then on.
+func (d *dripper) shook(resp []string) {
+	d.shaking = false
+	if d.identifying {
+		d.identify(resp)
+		return
+	}
+	if name, _ := firmwareName(resp); name != "" {
+		d.con.Println("-- CONNECTED:", name)
+	} else {
+		d.con.Println("-- CONNECTED")
+	}
+}
+
+// Matches the machine and work positions and the work coordinate offset in
+// a Grbl status report, e.g. "<Idle|MPos:10.000,0.000,2.000|FS:0,0>".
+var grbl_pos_re = regexp.MustCompile(`\|(MPos|WPos|WCO):(-?[\d.]+),(-?[\d.]+),(-?[\d.]+)`)
+
+// grblStatus picks up the position from a Grbl status report. Work
+// positions are what the job's GCode uses, so the machine position is taken
+// back to one with the last offset reported.
+func (d *dripper) grblStatus(ln string) {
+	var mpos, wpos *position
+	for _, m := range grbl_pos_re.FindAllStringSubmatch(ln, -1) {
+		var p position
+		for i, c := range []*float64{&p.X, &p.Y, &p.Z} {
+			*c, _ = strconv.ParseFloat(m[i+2], 64)
+		}
+		switch m[1] {
+		case "MPos":
+			mpos = &p
+		case "WPos":
+			wpos = &p
+		case "WCO":
+			d.wco = p
+		}
+	}
+	switch {
+	case wpos != nil:
+		d.pos.X, d.pos.Y, d.pos.Z = wpos.X, wpos.Y, wpos.Z
+	case mpos != nil:
+		d.pos.X, d.pos.Y, d.pos.Z = mpos.X-d.wco.X, mpos.Y-d.wco.Y, mpos.Z-d.wco.Z
+	}
+}
+
+// halt takes a daemon through the firmware halting, on firmware that stays
+// up and can carry on once cleared: the job is cancelled, and nothing more
+// is dripped until the halt is cleared. It returns false if the loop should
+// end instead, like it does for firmware that needs a reset.
+func (d *dripper) halt(err error) bool {
+	if d.fw.recover == "" || !d.daemon {
+		return false
+	}
+	// Each line sent meanwhile is answered with the halt.
+	if d.halted {
+		return true
+	}
+	d.halted = true
+	d.con.Println("-- HALTED:", err)
+	d.con.Printf("-- Send %s to clear the halt.\n", d.fw.recover)
+	d.emitError(err)
+	d.cancelJob(err)
+	// The stop GCodes would only be refused.
+	d.gcode = nil
+	d.job_queue = nil
+	return true
+}
+
+// recovered takes the answer to the GCode that clears a halt.
+func (d *dripper) recovered() {
+	d.halted = false
+	d.con.Println("-- HALT CLEARED")
+}
+
+// realtime sends a byte the firmware acts on at once, ahead of the lines
+// waiting in its buffer.
+func (d *dripper) realtime(b byte) {
+	if _, err := d.port.Write([]byte{b}); err != nil {
+		d.con.Println("-- ERROR:", err)
+	}
+}
+
+// feedHold stops the machine at once when the job is paused, on firmware
+// that can, rather than once it has done the lines in its buffer, and
+// starts it again on resuming.
+func (d *dripper) feedHold() {
+	if d.fw.hold == 0 || d.paused == d.holding {
+		return
+	}
+	d.holding = d.paused
+	if d.paused {
+		d.con.Println("-- FEED HOLD")
+		d.realtime(d.fw.hold)
+	} else {
+		d.con.Println("-- CYCLE START")
+		d.realtime(d.fw.cycle)
+	}
+}
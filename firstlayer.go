@@ -0,0 +1,28 @@
+package main
+
+// Set by -first-layer: quiet the console during the first layer so it can
+// be watched and adjusted with the hotkeys.
+var first_layer bool
+
+// firstLayer turns the first layer mode on when the job reaches layer 1,
+// going by the slicer's comments, and off when it leaves it.
+func (d *dripper) firstLayer() {
+	on := first_layer && d.layer == 1
+	if on == d.first_layer {
+		return
+	}
+	d.first_layer = on
+	if on {
+		d.con.Println("-- FIRST LAYER: [ and ] babystep Z, < and > change " +
+			"the flow, - and + the speed. The GCode is hidden until layer 2.")
+		return
+	}
+	d.con.Printf("-- FIRST LAYER DONE: Z%+.2f, flow %d%%, speed %d%%\n",
+		d.babystep, d.flow, d.feedrate)
+}
+
+// onFirstLayer tells whether the job's lines are hidden, since the first
+// layer is being printed.
+func (d *dripper) onFirstLayer() bool {
+	return d.first_layer && d.gcode != nil && d.gcode == d.gcode_file
+}
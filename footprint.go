@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Set by -check-footprint: the first layer of each job is checked against
+// the bed before it starts.
+var check_footprint bool
+
+// bedArea is the bed, from the [bed] section of the config file: how far it
+// goes in X and Y from 0, or the size given for -prime, and the zones to keep
+// out of, such as the clips holding the plate down.
+type bedArea struct {
+	x, y     float64
+	keep_out [][4]float64 // x0, y0, x1, y1
+}
+
+// bedArea reads the printer's bed from the config file.
+func (d *dripper) bedArea() (*bedArea, error) {
+	var b bedArea
+	var err error
+	get := func(key, prime_key string) float64 {
+		def, e := confFloat("prime", prime_key, prime_bed)
+		if err == nil {
+			err = e
+		}
+		v, e := confFloat(d.section("bed", key), key, def)
+		if err == nil {
+			err = e
+		}
+		return v
+	}
+	b.x, b.y = get("x", "bed_x"), get("y", "bed_y")
+	if err != nil {
+		return nil, err
+	}
+	if b.x <= 0 || b.y <= 0 {
+		return nil, fmt.Errorf("bed too small: %gx%g", b.x, b.y)
+	}
+	section := d.section("bed", "keep_out")
+	for _, zone := range strings.Fields(conf.get(section, "keep_out")) {
+		f := strings.Split(zone, ",")
+		if len(f) != 4 {
+			return nil, fmt.Errorf("[%s] keep_out: want x0,y0,x1,y1, not %q",
+				section, zone)
+		}
+		var r [4]float64
+		for i, s := range f {
+			if r[i], err = strconv.ParseFloat(s, 64); err != nil {
+				return nil, fmt.Errorf("[%s] keep_out: not a number: %q",
+					section, s)
+			}
+		}
+		r[0], r[2] = math.Min(r[0], r[2]), math.Max(r[0], r[2])
+		r[1], r[3] = math.Min(r[1], r[3]), math.Max(r[1], r[3])
+		b.keep_out = append(b.keep_out, r)
+	}
+	return &b, nil
+}
+
+// allows tells whether the nozzle may print at a point.
+func (b *bedArea) allows(x, y float64) bool {
+	if x < 0 || y < 0 || x > b.x || y > b.y {
+		return false
+	}
+	for _, r := range b.keep_out {
+		if x >= r[0] && y >= r[1] && x <= r[2] && y <= r[3] {
+			return false
+		}
+	}
+	return true
+}
+
+// draw draws the bed and its keep-out zones, on a canvas showing the bed and
+// the area from (x0, y0) to (x1, y1) as well.
+func (b *bedArea) draw(x0, y0, x1, y1 float64, cols, rows int, ascii bool) *canvas {
+	c := newCanvas(math.Min(x0, 0), math.Min(y0, 0), math.Max(x1, b.x),
+		math.Max(y1, b.y), cols, rows, ascii)
+	c.rect(0, 0, b.x, b.y, mark_edge)
+	for _, r := range b.keep_out {
+		c.rect(r[0], r[1], r[2], r[3], mark_edge)
+	}
+	return c
+}
+
+// The most rows a picture shown before a job takes.
+const footprint_rows = 20
+
+// checkFootprint draws the first layer of the job on the bed, if
+// -check-footprint is given, and pauses the job if any of it goes off the
+// bed or into a keep-out zone.
+func (d *dripper) checkFootprint() {
+	// A file piped in can't be read twice.
+	if !check_footprint || d.job_name == "" || d.job_name == "-" {
+		return
+	}
+	bed, err := d.bedArea()
+	if err != nil {
+		d.con.Println("-- FOOTPRINT CHECK:", err)
+		return
+	}
+	layers, err := readLayers(d.job_name, 1)
+	if err != nil {
+		d.con.Println("-- FOOTPRINT CHECK:", err)
+		return
+	}
+	if len(layers) == 0 || len(layers[0].segs) == 0 {
+		return
+	}
+	segs := layers[0].segs
+	x0, y0, x1, y1 := bounds(segs)
+	cols, rows := pictureSize()
+	if rows > footprint_rows {
+		rows = footprint_rows
+	}
+	c := bed.draw(x0, y0, x1, y1, cols, rows, false)
+	off := false
+	for _, s := range segs {
+		c.trace(s.x0, s.y0, s.x1, s.y1, func(x, y float64) byte {
+			if bed.allows(x, y) {
+				return mark_path
+			}
+			off = true
+			return mark_warn
+		})
+	}
+	d.con.Printf("-- FOOTPRINT of the first layer: X%.1f to %.1f, Y%.1f to %.1f\n%s",
+		x0, x1, y0, y1, c)
+	if !off {
+		return
+	}
+	d.con.Println("-- FOOTPRINT CHECK: the first layer goes off the bed or " +
+		"into a keep-out zone (in red). Paused: resume to print anyway.")
+	if d.gcode != nil && !d.paused {
+		d.paused = true
+		d.holdPosition()
+		d.emit(hookEvent{Event: "pause"})
+	}
+}
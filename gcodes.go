@@ -0,0 +1,61 @@
+package main
+
+import (
+	_ "embed"
+	"sort"
+	"strings"
+)
+
+//go:embed gcodes.txt
+var gcodes_txt string
+
+// gcodeHints maps the commands in gcodes.txt to their parameters and what
+// they do.
+var gcodeHints = func() map[string]string {
+	hints := make(map[string]string)
+	for _, ln := range strings.Split(gcodes_txt, "\n") {
+		f := strings.Split(ln, "\t")
+		if len(f) != 3 || strings.HasPrefix(ln, "#") {
+			continue
+		}
+		hints[f[0]] = strings.TrimSpace(f[1] + "  " + f[2])
+	}
+	return hints
+}()
+
+// gcodeHint returns the hint for a command, e.g. "S<temp> [T<tool>]  Set
+// hotend temperature" for M104, or "".
+func gcodeHint(code string) string {
+	for c, h := range gcodeHints {
+		if strings.EqualFold(c, code) {
+			return h
+		}
+	}
+	if name := findMacro(code); name != "" {
+		return "Macro: " + strings.ReplaceAll(conf.get("macros", name), "\n", "; ")
+	}
+	if name := findAlias(code); name != "" {
+		return "Alias: " + strings.ReplaceAll(conf.get("aliases", name), "\n", "; ")
+	}
+	return ""
+}
+
+// completions lists the commands, macros and aliases starting with prefix.
+func completions(prefix string) []string {
+	var found []string
+	up := strings.ToUpper(prefix)
+	for code := range gcodeHints {
+		if strings.HasPrefix(strings.ToUpper(code), up) {
+			found = append(found, code)
+		}
+	}
+	for _, section := range []string{"macros", "aliases"} {
+		for name := range conf[section] {
+			if strings.HasPrefix(strings.ToUpper(name), up) {
+				found = append(found, name)
+			}
+		}
+	}
+	sort.Strings(found)
+	return found
+}
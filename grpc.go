@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/juster/dripp3r/dripp3rpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcServer serves the daemon's gRPC API (see dripp3rpb/dripp3r.proto) for
+// all of its printers: the same requests as the control channel, as typed
+// methods for native front ends.
+type grpcServer struct {
+	dripp3rpb.UnimplementedDripperServer
+	f   *farm
+	con *console
+	h   *httpAPI // for the uploads directory jobs are started from
+}
+
+// Set by the daemon's -grpc flag: the address to serve the gRPC API on.
+var grpc_addr string
+
+// serveGRPC serves the gRPC API on -grpc's address.
+func serveGRPC(f *farm, con *console) error {
+	h, err := newHTTPAPI(f, "-grpc")
+	if err != nil {
+		return err
+	}
+	l, err := listenAPI("-grpc", grpc_addr)
+	if err != nil {
+		return err
+	}
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, h grpc.UnaryHandler) (interface{}, error) {
+			k, err := grpcKey(ctx, info.FullMethod)
+			if err != nil {
+				return nil, err
+			}
+			var addr string
+			if p, ok := peer.FromContext(ctx); ok {
+				addr = p.Addr.String()
+			}
+			return h(context.WithValue(ctx, grpcClient{}, apiClient{k, addr, "gRPC"}), req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, h grpc.StreamHandler) error {
+			if _, err := grpcKey(ss.Context(), info.FullMethod); err != nil {
+				return err
+			}
+			return h(srv, ss)
+		}),
+	}
+	if api.tls != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(api.tls)))
+	}
+	s := grpc.NewServer(opts...)
+	dripp3rpb.RegisterDripperServer(s, &grpcServer{f: f, con: con, h: h})
+	log.Print("gRPC API on ", l.Addr())
+	go s.Serve(l)
+	return nil
+}
+
+// grpcClient is the context key for the apiClient who made a call.
+type grpcClient struct{}
+
+// grpcKey finds who made a call, by its x-api-key metadata, and checks
+// that their key may call the method: a monitor's may only watch.
+func grpcKey(ctx context.Context, method string) (apiKey, error) {
+	var sent string
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md["x-api-key"]) > 0 {
+		sent = md["x-api-key"][0]
+	}
+	k, ok := lookupKey(sent)
+	if !ok {
+		return k, status.Error(codes.Unauthenticated, "wrong or missing x-api-key")
+	}
+	switch method {
+	case dripp3rpb.Dripper_GetStatus_FullMethodName, dripp3rpb.Dripper_StreamConsole_FullMethodName:
+	default:
+		if !k.control {
+			return k, status.Error(codes.PermissionDenied, k.refusal())
+		}
+	}
+	return k, nil
+}
+
+// control runs a control request on a printer for the client who made the
+// call and returns the result, with the daemon's errors turned into gRPC's.
+func (g *grpcServer) control(ctx context.Context, method string, params ctlParams) (interface{}, error) {
+	d, err := g.f.lookup(params.Printer)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if method != "status" {
+		what := strings.TrimSpace(method + " " + params.Path)
+		if err := ctx.Value(grpcClient{}).(apiClient).admit(d, what); err != nil {
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		}
+	}
+	rep := d.call(rpcRequest{Method: method, Params: params})
+	if rep.err != nil {
+		return nil, status.Error(codes.FailedPrecondition, rep.err.Error())
+	}
+	return rep.result, nil
+}
+
+func (g *grpcServer) reply(ctx context.Context, method string, params ctlParams) (*dripp3rpb.Reply, error) {
+	res, err := g.control(ctx, method, params)
+	if err != nil {
+		return nil, err
+	}
+	return &dripp3rpb.Reply{Message: fmt.Sprint(res)}, nil
+}
+
+func (g *grpcServer) StartJob(ctx context.Context, req *dripp3rpb.StartJobRequest) (*dripp3rpb.Reply, error) {
+	if req.Path == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing path")
+	}
+	path, err := fileIn("[http] uploads", g.h.dir, req.Path)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return g.reply(ctx, "print", ctlParams{Printer: req.Printer, Path: path})
+}
+
+func (g *grpcServer) Pause(ctx context.Context, req *dripp3rpb.PrinterRequest) (*dripp3rpb.Reply, error) {
+	return g.reply(ctx, "pause", ctlParams{Printer: req.Printer})
+}
+
+func (g *grpcServer) Resume(ctx context.Context, req *dripp3rpb.PrinterRequest) (*dripp3rpb.Reply, error) {
+	return g.reply(ctx, "resume", ctlParams{Printer: req.Printer})
+}
+
+func (g *grpcServer) Cancel(ctx context.Context, req *dripp3rpb.PrinterRequest) (*dripp3rpb.Reply, error) {
+	return g.reply(ctx, "cancel", ctlParams{Printer: req.Printer})
+}
+
+func (g *grpcServer) GetStatus(ctx context.Context, req *dripp3rpb.PrinterRequest) (*dripp3rpb.Status, error) {
+	res, err := g.control(ctx, "status", ctlParams{Printer: req.Printer})
+	if err != nil {
+		return nil, err
+	}
+	return pbStatus(res.(ctlStatus)), nil
+}
+
+// StreamConsole streams the console notifications, as a monitor gets them,
+// until the client goes away.
+func (g *grpcServer) StreamConsole(req *dripp3rpb.PrinterRequest, stream dripp3rpb.Dripper_StreamConsoleServer) error {
+	if req.Printer != "" {
+		if _, err := g.f.lookup(req.Printer); err != nil {
+			return status.Error(codes.NotFound, err.Error())
+		}
+	}
+	notes := g.con.attach()
+	defer g.con.detach(notes)
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case note := <-notes:
+			ev := pbEvent(note)
+			if ev == nil || req.Printer != "" && ev.Printer != req.Printer {
+				continue
+			}
+			if err := stream.Send(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// pbEvent turns a console notification into an event to stream, or returns
+// nil.
+func pbEvent(note rpcNotice) *dripp3rpb.ConsoleEvent {
+	switch p := note.Params.(type) {
+	case consoleLine:
+		return &dripp3rpb.ConsoleEvent{Printer: p.Printer,
+			Event: &dripp3rpb.ConsoleEvent_Line{Line: p.Line}}
+	case consoleTemps:
+		return &dripp3rpb.ConsoleEvent{Printer: p.Printer,
+			Event: &dripp3rpb.ConsoleEvent_Temps{Temps: pbTemps(p.temps)}}
+	case consoleStatus:
+		return &dripp3rpb.ConsoleEvent{Printer: p.Printer,
+			Event: &dripp3rpb.ConsoleEvent_Status{Status: pbStatus(p.ctlStatus)}}
+	}
+	return nil
+}
+
+func pbTemps(t temps) *dripp3rpb.Temps {
+	return &dripp3rpb.Temps{
+		Hotend:       t.Hotend,
+		HotendTarget: t.HotendTarget,
+		Bed:          t.Bed,
+		BedTarget:    t.BedTarget,
+	}
+}
+
+func pbStatus(st ctlStatus) *dripp3rpb.Status {
+	p := st.Position
+	return &dripp3rpb.Status{
+		State:      st.State,
+		Job:        st.Job,
+		Queued:     int32(st.Queued),
+		Temps:      pbTemps(st.Temps),
+		Feedrate:   int32(st.Feedrate),
+		Flow:       int32(st.Flow),
+		Fan:        int32(st.Fan),
+		Position:   &dripp3rpb.Position{X: p.X, Y: p.Y, Z: p.Z, E: p.E},
+		Babystep:   st.Babystep,
+		FirstLayer: st.FirstLayer,
+		Machine:    st.Machine,
+		Firmware:   st.Firmware,
+		Heating:    st.Heating,
+		Progress:   st.Progress,
+		Line:       int32(st.Line),
+		Lines:      int32(st.Lines),
+		Layer:      int32(st.Layer),
+		Layers:     int32(st.Layers),
+		Left:       int32(st.Left),
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// heatWait follows the printer heating up while M109 or M190 waits for it,
+// to show how far it has got and how long it has left on the status line,
+// rather than a line for every temperature it reports meanwhile.
+type heatWait struct {
+	heater  string // "hotend" or "bed"
+	target  float64
+	temp    float64
+	started time.Time
+	samples []heatSample // the readings of the last heat_window
+	noted   time.Time    // when progress was last printed
+	settle  int          // seconds it has to hold the temperature, or -1
+}
+
+type heatSample struct {
+	at   time.Time
+	temp float64
+}
+
+// How far back the readings go that the time left is worked out from, and
+// how often progress is printed, for logs and consoles without a status line.
+const (
+	heat_window = 10 * time.Second
+	heat_note   = 30 * time.Second
+)
+
+// Matches the current temperatures in a report, e.g. "T:178.2 /210.0" or
+// Prusa-Firmware's "T:178.2 E:0 B:59.8", which leaves out the targets.
+var heat_re = regexp.MustCompile(`\b([TB]):\s*(-?[\d.]+)`)
+
+// Matches Marlin's residency countdown in a report: once the heater is at
+// its target, the seconds it has yet to hold it there (TEMP_RESIDENCY_TIME)
+// before the wait is over, and "W:?" before then.
+var residency_re = regexp.MustCompile(`\bW:(\d+)`)
+
+// heatReport takes in a temperature report, which while M109 or M190 waits
+// tells how far the heater has got.
+func (d *dripper) heatReport(ln string) {
+	if len(d.in_flight) == 0 {
+		return
+	}
+	line := d.in_flight[0]
+	var heater string
+	var letter byte
+	switch {
+	case isGCode(line, "M109"):
+		heater, letter = "hotend", 'T'
+	case isGCode(line, "M190"):
+		heater, letter = "bed", 'B'
+	default:
+		return
+	}
+	temp, found := 0.0, false
+	for _, m := range heat_re.FindAllStringSubmatch(ln, -1) {
+		if m[1][0] == letter {
+			t, err := strconv.ParseFloat(m[2], 64)
+			temp, found = t, err == nil
+			break
+		}
+	}
+	if !found {
+		return
+	}
+	now := time.Now()
+	h := d.heat
+	if h == nil {
+		_, args := gcodeWords(string(stripLineNumber(line)))
+		target := args['S']
+		if r, ok := args['R']; ok {
+			target = r
+		}
+		h = &heatWait{heater: heater, target: target, started: now, noted: now,
+			settle: -1}
+		d.heat = h
+		d.con.Printf("-- HEATING the %s to %.0f°C\n", heater, target)
+	}
+	h.temp = temp
+	// The wait goes on at the target while the temperature settles, which
+	// could otherwise look like the printer hanging.
+	if m := residency_re.FindStringSubmatch(ln); m != nil {
+		if h.settle < 0 {
+			d.con.Printf("-- HEATING the %s: at %.0f°C, holding it for %ss\n",
+				heater, temp, m[1])
+		}
+		h.settle, _ = strconv.Atoi(m[1])
+	} else {
+		h.settle = -1
+	}
+	h.samples = append(h.samples, heatSample{now, temp})
+	for len(h.samples) > 2 && now.Sub(h.samples[0].at) > heat_window {
+		h.samples = h.samples[1:]
+	}
+	if now.Sub(h.noted) >= heat_note {
+		h.noted = now
+		d.con.Println("-- HEATING", h)
+	}
+}
+
+// left extrapolates how long the heater has left to get to its target from
+// the recent readings.
+func (h *heatWait) left() (time.Duration, bool) {
+	if len(h.samples) < 2 {
+		return 0, false
+	}
+	first, last := h.samples[0], h.samples[len(h.samples)-1]
+	secs := last.at.Sub(first.at).Seconds()
+	if secs <= 0 {
+		return 0, false
+	}
+	rate := (last.temp - first.temp) / secs
+	// Heating towards the target, or cooling towards it with M109 R.
+	to_go := h.target - last.temp
+	if rate*to_go <= 0 {
+		return 0, false
+	}
+	return time.Duration(to_go / rate * float64(time.Second)).Round(time.Second), true
+}
+
+func (h *heatWait) String() string {
+	s := fmt.Sprintf("%s %.0f/%.0f°C", h.heater, h.temp, h.target)
+	if h.settle >= 0 {
+		return s + fmt.Sprintf(", settling %ds", h.settle)
+	}
+	if left, ok := h.left(); ok {
+		s += fmt.Sprintf(", %v left", left)
+	}
+	return s
+}
+
+// heated ends the wait for a heater, once the printer has answered M109 or
+// M190.
+func (d *dripper) heated() {
+	h := d.heat
+	if h == nil {
+		return
+	}
+	d.heat = nil
+	d.est_heat += time.Since(h.started)
+	d.con.Printf("-- HEATED the %s to %.0f°C in %v\n", h.heater, h.target,
+		time.Since(h.started).Round(time.Second))
+}
+
+// Set by -parallel-heat: when the start code waits for one heater before
+// setting the other, the other is set first, so both heat at once.
+var parallel_heat bool
+
+// How far into a file heatInParallel looks for the heaters being set: the
+// start code ends by then, or with the first extruding move.
+const start_code_lines = 500
+
+// heatInParallel passes on a job's lines, sending the hotend's temperature
+// (M104) ahead of the first wait for the bed (M190) if the start code only
+// sets it later with M109, and likewise the bed's (M140) ahead of the first
+// wait for the hotend. Start code that sets a heater before waiting for the
+// other, e.g. to a lower temperature for probing, is left alone.
+func heatInParallel(in <-chan []byte, con *console) <-chan []byte {
+	out := make(chan []byte)
+	go func() {
+		defer close(out)
+		var start [][]byte
+		for ln := range in {
+			start = append(start, ln)
+			if len(start) >= start_code_lines || ln[0] != '@' &&
+				(isGCode(ln, "G0") || isGCode(ln, "G1")) && bytes.ContainsAny(ln, "Ee") {
+				break
+			}
+		}
+		for _, ln := range hoistHeat(start, con) {
+			out <- ln
+		}
+		for ln := range in {
+			out <- ln
+		}
+	}()
+	return out
+}
+
+// hoistHeat sends each heater's temperature ahead of the first wait for the
+// other, where the lines don't set it before then.
+func hoistHeat(lines [][]byte, con *console) [][]byte {
+	pairs := []struct{ wait, set, other_wait, other_set string }{
+		{"M190", "M140", "M109", "M104"},
+		{"M109", "M104", "M190", "M140"},
+	}
+	for _, p := range pairs {
+		first := -1
+		for i, ln := range lines {
+			if ln[0] == '@' {
+				continue
+			}
+			if first < 0 && isGCode(ln, p.wait) {
+				first = i
+			}
+			if first < 0 && (isGCode(ln, p.other_set) || isGCode(ln, p.other_wait)) {
+				break
+			}
+			if first < 0 || !isGCode(ln, p.other_wait) && !isGCode(ln, p.other_set) {
+				continue
+			}
+			_, args := gcodeWords(string(ln))
+			target, ok := args['S']
+			if r, has_r := args['R']; !ok && has_r {
+				target, ok = r, true
+			}
+			if !ok || target <= 0 {
+				break
+			}
+			set := fmt.Sprintf("%s S%g", p.other_set, target)
+			if t, ok := args['T']; ok {
+				set += fmt.Sprintf(" T%g", t)
+			}
+			con.Printf("-- PARALLEL HEAT: %s ahead of %s\n", set, lines[first])
+			lines = append(lines[:first], append([][]byte{[]byte(set)},
+				lines[first:]...)...)
+			break
+		}
+	}
+	return lines
+}
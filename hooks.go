@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// hookEvent is passed as JSON on the stdin of the command configured for it
+// in the [hooks] section of the config file.
+type hookEvent struct {
+	Event   string    `json:"event"`
+	Time    time.Time `json:"time"`
+	Printer string    `json:"printer,omitempty"`
+	Job     string    `json:"job,omitempty"`
+	Layer   int       `json:"layer,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// emit publishes an event that has no type of its own.
+func (d *dripper) emit(ev hookEvent) {
+	d.bus.publish(ev)
+}
+
+// runHooks runs the hook for an event, if there is one, in the background,
+// passes it to the plugins, and then calls the script's handlers for it.
+func (d *dripper) runHooks(e event) {
+	var ev hookEvent
+	switch e := e.(type) {
+	case hookEvent:
+		ev = e
+	case jobStarted:
+		ev = hookEvent{Event: "job_start", Job: e.Job}
+	case jobEnded:
+		ev = hookEvent{Event: "job_end", Job: e.Job}
+		if e.Err != nil {
+			ev.Event, ev.Error = "job_fail", e.Err.Error()
+		}
+	case layerChanged:
+		ev = hookEvent{Event: "layer_change", Layer: e.Layer}
+	default:
+		return
+	}
+	ev.Time = time.Now()
+	ev.Printer = d.con.name
+	if ev.Job == "" {
+		ev.Job = d.job_name
+	}
+	if json_out {
+		writeJSON(ev)
+	}
+	if cmd := conf.get("hooks", ev.Event); cmd != "" {
+		data, _ := json.Marshal(ev)
+		d.runShell(cmd, data)
+	}
+	for _, p := range d.plugins {
+		p.notify(ev)
+	}
+	if d.script != nil {
+		d.script.handle(ev)
+	}
+}
+
+func (d *dripper) emitError(err error) {
+	d.emit(hookEvent{Event: "error", Error: err.Error()})
+}
+
+// jobFailed reports that the current job, if any, did not finish.
+func (d *dripper) jobFailed(err error) {
+	if d.job_name != "" {
+		d.bus.publish(jobEnded{d.job_name, err})
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+var (
+	snapshot_cmd string
+	allow_run    bool
+)
+
+// hostCommand carries out a line starting with "@", which is meant for
+// dripp3r rather than the printer. Files give them as comments, e.g.
+// ";@pause", so other hosts ignore them, or as bare lines like OctoPrint.
+func (d *dripper) hostCommand(cmd string) {
+	name, arg, _ := strings.Cut(cmd, " ")
+	switch name {
+	case "pause":
+		d.con.Println("-- PAUSE (@pause)")
+		d.paused = true
+		d.holdPosition()
+		d.park("pause")
+		d.emit(hookEvent{Event: "pause"})
+	case "resume":
+		if d.paused {
+			d.con.Println("-- RESUME (@resume)")
+			d.paused = false
+			d.returnToJob()
+		}
+	case "cancel", "abort":
+		if d.daemon {
+			d.cancelJob(errAborted)
+			return
+		}
+		d.con.Println("-- CANCEL (@cancel): Dripping stop GCodes.")
+		d.shutdown(errAborted)
+		d.err = errAborted
+	case "layer":
+		// Slicers count layers from 0.
+		if n, err := strconv.Atoi(arg); err == nil {
+			d.layer = n + 1
+		} else {
+			d.layer++
+		}
+		d.bus.publish(layerChanged{d.layer})
+		sim.layer(d.layer)
+		d.firstLayer()
+		d.timelapseFrame()
+		d.pauseAt("layer")
+	case "object":
+		d.startObject(arg)
+	case "define_object":
+		d.defineObject(arg)
+	case "snapshot":
+		if snapshot_cmd == "" {
+			log.Print("@snapshot ignored, no -snapshot command given")
+			return
+		}
+		d.runHost(snapshot_cmd)
+	case "run":
+		if !allow_run {
+			log.Print("@run ignored, use -allow-run to run commands from GCode")
+			return
+		}
+		d.runHost(strings.TrimSpace(arg))
+	default:
+		log.Printf("Unknown host command: @%s", cmd)
+	}
+}
+
+func (d *dripper) runHost(script string) {
+	d.con.Println("-- RUN", script)
+	d.runShell(script, nil)
+}
+
+// runShell runs a shell command without holding up the printer and copies
+// its output to the console.
+func (d *dripper) runShell(script string, stdin []byte) {
+	d.running.Add(1)
+	go func() {
+		defer d.running.Done()
+		cmd := shellCommand(script)
+		cmd.Stdin = bytes.NewReader(stdin)
+		out, err := cmd.CombinedOutput()
+		if len(out) > 0 {
+			d.con.Printf("%s", out)
+		}
+		if err != nil {
+			d.con.Printf("-- RUN FAILED: %s: %v\n", script, err)
+		}
+	}()
+}
+
+// runFile sends the lines of a file ahead of the job, as if typed in hacker
+// mode, for ":run warmup.gcode".
+func (d *dripper) runFile(path string) {
+	if d.side != nil {
+		d.con.Println("-- ERROR: already running a file")
+		return
+	}
+	f, err := openGCode(path)
+	if err != nil {
+		d.con.Println("-- ERROR:", err)
+		return
+	}
+	d.con.Println("-- RUN FILE", path)
+	d.side, d.side_err = gcodeLines(f)
+}
+
+// hostComment turns a comment meant for the host into a host command, or
+// returns nil. Layer changes are marked by the slicer: Cura writes
+// ";LAYER:n" and PrusaSlicer ";LAYER_CHANGE".
+func hostComment(ln []byte) []byte {
+	switch {
+	case bytes.HasPrefix(ln, []byte(";@")):
+		return ln[1:]
+	case bytes.HasPrefix(ln, []byte(";LAYER:")):
+		return append([]byte("@layer "), ln[len(";LAYER:"):]...)
+	case bytes.Equal(ln, []byte(";LAYER_CHANGE")):
+		return []byte("@layer")
+	}
+	return objectComment(ln)
+}
+
+func shellCommand(script string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", script)
+	}
+	return exec.Command("sh", "-c", script)
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Jog steps in mm, chosen with keys 1 to 4, and speeds in mm/min.
+var jog_steps = []float64{0.1, 1, 10, 100}
+
+const (
+	jog_xy_feed = 3000
+	jog_z_feed  = 600
+)
+
+// How much filament e and r move in jog mode, in mm, and how fast, in
+// mm/min, unless [jog] in the config file says otherwise. Marlin won't
+// extrude below extrude_min_temp.
+const (
+	extrude_length   = 50
+	extrude_feed     = 300
+	extrude_min_temp = 170
+)
+
+// How far the nozzle moves for each press of [ or ], in mm.
+const babystep = 0.02
+
+// Steps for the feedrate (+ and -) and flow (< and >) overrides, and the
+// limits Marlin takes for both, in percent.
+const (
+	feedrate_step = 10
+	flow_step     = 5
+	override_min  = 10
+	override_max  = 999
+)
+
+// The part cooling fan speeds f steps through, in percent.
+const fan_step = 25
+
+// Match the "FR:110%" and "E0 Flow: 95%" Marlin reports for M220 and M221
+// without parameters.
+var (
+	feedrate_re = regexp.MustCompile(`\bFR:(\d+)%`)
+	flow_re     = regexp.MustCompile(`\bFlow:\s*(\d+)%`)
+)
+
+var errNoHotkey = errors.New("no hotkey")
+
+// hotkey handles a key pressed while the job runs, outside hacker mode and
+// the menu, and returns what it did. Attached clients send theirs with the
+// "key" control method.
+func (d *dripper) hotkey(k string) (string, error) {
+	switch {
+	case d.jogging:
+		return d.jogKey(k)
+	case d.zoffsetting:
+		return d.zoffsetKey(k)
+	case d.esteps != estepsOff:
+		return d.eStepsKey(k)
+	case d.changing:
+		return d.changeKey(k)
+	}
+	switch k {
+	case "p", " ":
+		// The loop takes no more lines while paused, so the printer stops
+		// once the line in flight is done.
+		if d.paused {
+			d.con.Println("-- RESUME")
+			d.paused = false
+			d.returnToJob()
+			return "resumed", nil
+		}
+		if d.gcode == nil {
+			return "", errors.New("nothing to pause")
+		}
+		return d.requestPause()
+	case "[", "]":
+		// M290 moves the nozzle at once, even with moves queued.
+		z := babystep
+		if k == "[" {
+			z = -z
+		}
+		d.babystep += z
+		d.hack_queue = append(d.hack_queue, fmt.Sprintf("M290 Z%g", z))
+		d.con.Printf("-- BABYSTEP Z%+.2f (total %+.2f)\n", z, d.babystep)
+		return fmt.Sprintf("babystep %+.2f", d.babystep), nil
+	case "+", "=":
+		return d.override("feedrate", "M220", &d.feedrate, feedrate_step)
+	case "-":
+		return d.override("feedrate", "M220", &d.feedrate, -feedrate_step)
+	case ">", ".":
+		return d.override("flow", "M221", &d.flow, flow_step)
+	case "<", ",":
+		return d.override("flow", "M221", &d.flow, -flow_step)
+	case "f":
+		// Up a step at a time, and from full speed back to off.
+		pct := (fanPercent(d.fan)/fan_step + 1) * fan_step
+		if pct > 100 {
+			pct = 0
+		}
+		d.fan = (pct*255 + 50) / 100
+		d.hack_queue = append(d.hack_queue, fanGCode(d.fan))
+		d.con.Printf("-- FAN %d%%\n", pct)
+		return fmt.Sprintf("fan %d%%", pct), nil
+	case "h":
+		return d.home("")
+	case "z":
+		return d.startZOffset()
+	case "j":
+		if d.gcode != nil && !d.paused {
+			return "", errors.New("pause the job before jogging")
+		}
+		d.jogging = true
+		// Fresh temperatures for extruding.
+		d.hack_queue = append(d.hack_queue, "M105")
+		d.con.Printf("-- JOG: Arrows move X/Y, PgUp/PgDn Z, 1-4 set the step, "+
+			"h homes, x/y/z home one axis, e/r extrude/retract, q leaves. "+
+			"Step %gmm\n", d.jog_step)
+		return "jogging", nil
+	}
+	return "", fmt.Errorf("%w %q", errNoHotkey, k)
+}
+
+// override changes a percentage the printer applies to the job, such as the
+// feedrate, by step.
+func (d *dripper) override(name, cmd string, pct *int, step int) (string, error) {
+	v := *pct + step
+	if v < override_min || v > override_max {
+		return "", fmt.Errorf("%s %d%% is out of range", name, v)
+	}
+	*pct = v
+	d.hack_queue = append(d.hack_queue, fmt.Sprintf("%s S%d", cmd, v))
+	d.con.Printf("-- %s %d%%\n", strings.ToUpper(name), v)
+	return fmt.Sprintf("%s %d%%", name, v), nil
+}
+
+// home homes an axis, or all of them. The outcome is reported when the
+// printer answers.
+func (d *dripper) home(axis string) (string, error) {
+	if d.gcode != nil && !d.paused {
+		return "", errors.New("pause the job before homing")
+	}
+	if axis == "" {
+		d.con.Println("-- HOMING all axes")
+		d.hack_queue = append(d.hack_queue, "G28")
+	} else {
+		d.con.Println("-- HOMING", axis)
+		d.hack_queue = append(d.hack_queue, "G28 "+axis)
+	}
+	return "homing", nil
+}
+
+// jogKey handles a key in jog mode, moving the head by the jog step.
+func (d *dripper) jogKey(k string) (string, error) {
+	var move string
+	switch k {
+	case "left":
+		move = fmt.Sprintf("G1 X%g F%d", -d.jog_step, jog_xy_feed)
+	case "right":
+		move = fmt.Sprintf("G1 X%g F%d", d.jog_step, jog_xy_feed)
+	case "up":
+		move = fmt.Sprintf("G1 Y%g F%d", d.jog_step, jog_xy_feed)
+	case "down":
+		move = fmt.Sprintf("G1 Y%g F%d", -d.jog_step, jog_xy_feed)
+	case "pgup":
+		move = fmt.Sprintf("G1 Z%g F%d", d.jog_step, jog_z_feed)
+	case "pgdn":
+		move = fmt.Sprintf("G1 Z%g F%d", -d.jog_step, jog_z_feed)
+	case "1", "2", "3", "4":
+		d.jog_step = jog_steps[k[0]-'1']
+		d.con.Printf("-- JOG STEP %gmm\n", d.jog_step)
+		return fmt.Sprintf("step %gmm", d.jog_step), nil
+	case "h":
+		return d.home("")
+	case "x", "y", "z":
+		return d.home(strings.ToUpper(k))
+	case "e", "r":
+		return d.extrude(k == "r")
+	case "q", "esc":
+		d.jogging = false
+		d.con.Println("-- END JOG")
+		return "done jogging", nil
+	default:
+		return "", fmt.Errorf("%w %q in jog mode", errNoHotkey, k)
+	}
+	d.moveBy(move)
+	return move, nil
+}
+
+// moveBy queues a relative move. The job expects absolute positioning back.
+func (d *dripper) moveBy(move string) {
+	d.hack_queue = append(d.hack_queue, "G91", move, "G90")
+	if d.relative_e {
+		// G90 makes E absolute too.
+		d.hack_queue = append(d.hack_queue, "M83")
+	}
+}
+
+// extrude pushes filament through the hotend, or pulls it back, e.g. to
+// load or unload it.
+func (d *dripper) extrude(retract bool) (string, error) {
+	length, err := confFloat("jog", "extrude_length", extrude_length)
+	if err != nil {
+		return "", err
+	}
+	feed, err := confFloat("jog", "extrude_feed", extrude_feed)
+	if err != nil {
+		return "", err
+	}
+	if retract {
+		length = -length
+	}
+	return d.extrudeBy(length, feed)
+}
+
+// extrudeBy moves the filament by length mm, back if negative, at feed
+// mm/min.
+func (d *dripper) extrudeBy(length, feed float64) (string, error) {
+	if !isPrinter() {
+		return "", errNotPrinter
+	}
+	if d.temps.Hotend < extrude_min_temp {
+		return "", fmt.Errorf("the hotend is at %.0f, heat it to %d first",
+			d.temps.Hotend, extrude_min_temp)
+	}
+	if length < 0 {
+		d.con.Printf("-- RETRACT %gmm\n", -length)
+	} else {
+		d.con.Printf("-- EXTRUDE %gmm\n", length)
+	}
+	move := fmt.Sprintf("G1 E%g F%g", length, feed)
+	d.hack_queue = append(d.hack_queue, "M83", move)
+	if !d.relative_e {
+		d.hack_queue = append(d.hack_queue, "M82")
+	}
+	return move, nil
+}
+
+// track follows the overrides set by a line sent to the printer, whether by
+// a hotkey, the file or hacker mode, so the status line shows them.
+func (d *dripper) track(line []byte) {
+	if s, ok := gcodeParam(line, "M220", 'S'); ok {
+		d.feedrate = int(s)
+	}
+	if s, ok := gcodeParam(line, "M221", 'S'); ok {
+		d.flow = int(s)
+	}
+	// Only the first fan, which is the part cooling fan.
+	if p, _ := gcodeParam(line, "M106", 'P'); p == 0 {
+		switch s, ok := gcodeParam(line, "M106", 'S'); {
+		case ok:
+			d.fan = int(s)
+		case isGCode(line, "M106"):
+			d.fan = 255
+		}
+	}
+	if p, _ := gcodeParam(line, "M107", 'P'); p == 0 && isGCode(line, "M107") {
+		d.fan = 0
+	}
+	switch {
+	case isGCode(line, "G90"):
+		d.relative_xyz, d.relative_e = false, false
+	case isGCode(line, "G91"):
+		d.relative_xyz, d.relative_e = true, true
+	case isGCode(line, "M82"):
+		d.relative_e = false
+	case isGCode(line, "M83"):
+		d.relative_e = true
+	case isGCode(line, "M3"), isGCode(line, "M4"):
+		d.tool = string(line)
+	case isGCode(line, "M5"):
+		d.tool = ""
+	}
+	d.trackMove(line)
+}
+
+// fanPercent turns a fan speed from 0 to 255 into a percentage.
+func fanPercent(s int) int {
+	return (s*100 + 127) / 255
+}
+
+// fanGCode returns the GCode that sets the part cooling fan to a speed.
+func fanGCode(s int) string {
+	if s == 0 {
+		return "M107"
+	}
+	return fmt.Sprintf("M106 S%d", s)
+}
+
+// trackResponse picks up overrides reported by the printer.
+func (d *dripper) trackResponse(ln string) {
+	if m := feedrate_re.FindStringSubmatch(ln); m != nil {
+		d.feedrate, _ = strconv.Atoi(m[1])
+	}
+	if m := flow_re.FindStringSubmatch(ln); m != nil {
+		d.flow, _ = strconv.Atoi(m[1])
+	}
+	d.trackZOffset(ln)
+	d.trackESteps(ln)
+	d.trackPosition(ln)
+}
+
+// gcodeParam returns parameter p of a line if it is the GCode cmd, e.g. 150
+// for S in "M220 S150".
+func gcodeParam(line []byte, cmd string, p byte) (float64, bool) {
+	if !isGCode(line, cmd) {
+		return 0, false
+	}
+	for _, arg := range bytes.Fields(line)[1:] {
+		if arg[0] == p || arg[0] == p+'a'-'A' {
+			v, err := strconv.ParseFloat(string(arg[1:]), 64)
+			return v, err == nil
+		}
+	}
+	return 0, false
+}
+
+// isGCode tells whether line is the GCode cmd, such as "M106".
+func isGCode(line []byte, cmd string) bool {
+	f := bytes.Fields(line)
+	return len(f) > 0 && bytes.EqualFold(f[0], []byte(cmd))
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// httpAPI serves the daemon's own HTTP API: POST /print uploads a GCode file
+// and prints it, or queues it behind the job printing, for slicers'
+// post-processing scripts, network shares and anything else that can make a
+// request.
+type httpAPI struct {
+	f   *farm
+	dir string // where uploads are saved
+	max int64  // the largest upload taken, in bytes
+}
+
+// Set by the daemon's -http flag: the address to serve the HTTP API on.
+var http_addr string
+
+// newHTTPAPI reads the [http] section of the config file for the flag that
+// serves an API.
+func newHTTPAPI(f *farm, flag string) (*httpAPI, error) {
+	h := &httpAPI{f: f, dir: conf.get("http", "uploads")}
+	if h.dir == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", flag, err)
+		}
+		h.dir = filepath.Join(dir, "dripp3r", "uploads")
+	}
+	if err := os.MkdirAll(h.dir, 0755); err != nil {
+		return nil, fmt.Errorf("[http] uploads: %w", err)
+	}
+	mb, err := confInt("http", "max_upload", 256)
+	if err != nil {
+		return nil, err
+	}
+	if mb == 0 {
+		return nil, errors.New("[http] max_upload: want the size of the largest upload in MB, not 0")
+	}
+	h.max = int64(mb) << 20
+	return h, nil
+}
+
+// serveHTTPAPI serves the HTTP API on -http's address.
+func serveHTTPAPI(f *farm) error {
+	h, err := newHTTPAPI(f, "-http")
+	if err != nil {
+		return err
+	}
+	l, err := listenAPI("-http", http_addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/print", h.servePrint)
+	log.Print("HTTP API on ", l.Addr())
+	serveAPI(l, mux)
+	return nil
+}
+
+// httpError answers a request with an error as JSON.
+func httpError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// controlError answers a control request that was refused: with 429 if the
+// client has made too many, or else 409, as the printer can't do it now.
+func controlError(w http.ResponseWriter, err error) {
+	if err == errTooOften {
+		w.Header().Set("Retry-After", strconv.Itoa(60/api.rate+1))
+		httpError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	httpError(w, http.StatusConflict, err.Error())
+}
+
+// httpReply answers a request with v as JSON.
+func httpReply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// servePrint saves the GCode file in the request and prints it on the
+// printer named by ?printer=, which can be left out when the daemon drives
+// only one. The file is either the body itself, named by ?name=, or the
+// first file in a multipart form, as curl -F file=@part.gcode sends it.
+func (h *httpAPI) servePrint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		httpError(w, http.StatusMethodNotAllowed, "POST a GCode file")
+		return
+	}
+	if k, ok := requestKey(r); !ok {
+		httpError(w, http.StatusUnauthorized, "wrong or missing X-Api-Key")
+		return
+	} else if !k.control {
+		httpError(w, http.StatusForbidden, k.refusal())
+		return
+	}
+	d, err := h.f.lookup(r.URL.Query().Get("printer"))
+	if err != nil {
+		httpError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	// Before the file is read, so that clients turned away can't fill the
+	// disk either.
+	what := "print " + r.URL.Query().Get("name")
+	if what == "print " {
+		what += "an upload"
+	}
+	if err := requestClient(r, "HTTP").admit(d, what); err != nil {
+		controlError(w, err)
+		return
+	}
+	h.limit(w, r)
+	path, err := h.save(r)
+	if err != nil {
+		saveError(w, err, http.StatusBadRequest)
+		return
+	}
+	rep := d.call(rpcRequest{Method: "print", Params: ctlParams{Path: path}})
+	if rep.err != nil {
+		controlError(w, rep.err)
+		return
+	}
+	httpReply(w, map[string]interface{}{"file": path, "result": rep.result})
+}
+
+// save writes the file in a request to the uploads directory and returns its
+// path. A file of the same name is only replaced if ?overwrite= says to, as
+// it may be the one printing.
+func (h *httpAPI) save(r *http.Request) (string, error) {
+	body, name := io.Reader(r.Body), r.URL.Query().Get("name")
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if ct == "multipart/form-data" {
+		form, err := r.MultipartReader()
+		if err != nil {
+			return "", err
+		}
+		for {
+			part, err := form.NextPart()
+			if err == io.EOF {
+				return "", errors.New("no file in the form")
+			} else if err != nil {
+				return "", err
+			}
+			if part.FileName() != "" {
+				body = part
+				if name == "" {
+					name = part.FileName()
+				}
+				break
+			}
+		}
+	}
+	if name == "" {
+		name = time.Now().Format("upload-20060102-150405.gcode")
+	}
+	path, err := h.upload(name)
+	if err != nil {
+		return "", err
+	}
+	overwrite, _ := strconv.ParseBool(r.URL.Query().Get("overwrite"))
+	if err := h.store(path, body, overwrite); errors.Is(err, fs.ErrExist) {
+		return "", fmt.Errorf("%s is there already, to replace with ?overwrite=1: %w",
+			filepath.Base(path), fs.ErrExist)
+	} else if err != nil {
+		return "", err
+	}
+	log.Printf("Uploaded %s from %s", path, r.RemoteAddr)
+	return path, nil
+}
+
+// limit caps the size of the upload in a request, as [http] max_upload says.
+func (h *httpAPI) limit(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.max)
+}
+
+// saveError answers a request whose upload couldn't be saved: with 413 if it
+// was too large, 409 if a file by its name is there already, or else code.
+func saveError(w http.ResponseWriter, err error, code int) {
+	var too_big *http.MaxBytesError
+	switch {
+	case errors.As(err, &too_big):
+		httpError(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("larger than [http] max_upload, %dMB", too_big.Limit>>20))
+	case errors.Is(err, fs.ErrExist):
+		httpError(w, http.StatusConflict, err.Error())
+	default:
+		httpError(w, code, err.Error())
+	}
+}
+
+// upload returns the path in the uploads directory a file uploaded as name
+// is saved to: only the name is kept, so uploads can't go anywhere else.
+func (h *httpAPI) upload(name string) (string, error) {
+	name = filepath.Base(filepath.FromSlash(name))
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return "", fmt.Errorf("bad file name %q", name)
+	}
+	return filepath.Join(h.dir, name), nil
+}
+
+// store saves an upload to path once it has all arrived. A file there
+// already is replaced only with overwrite; otherwise the upload is linked
+// into place, which fails with fs.ErrExist if the name is taken, even by
+// another upload that finished a moment before.
+func (h *httpAPI) store(path string, body io.Reader, overwrite bool) error {
+	f, err := os.CreateTemp(h.dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && overwrite {
+		err = os.Rename(f.Name(), path)
+	} else if err == nil {
+		err = os.Link(f.Name(), path)
+	}
+	if err != nil || !overwrite {
+		os.Remove(f.Name())
+	}
+	return err
+}
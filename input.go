@@ -0,0 +1,154 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// Where to save the thumbnail found in a 3MF/UFP file, if anywhere.
+var thumbnail_path string
+
+// openGCode opens a GCode file for gcodeLines, or stdin if path is "-".
+// Compressed files and 3MF/UFP archives are recognized by their contents,
+// whatever their name, and the GCode inside them is read.
+func openGCode(path string) (io.ReadCloser, error) {
+	f := os.Stdin
+	if path != "-" {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return nil, err
+		}
+	}
+	r, err := unpack(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// readCloser reads from one reader and closes another.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func unpack(f *os.File) (io.ReadCloser, error) {
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return readCloser{zr, f}, nil
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		return openArchive(f, br)
+	}
+	return readCloser{br, f}, nil
+}
+
+// openArchive finds the GCode in a 3MF or UFP archive, which are both zip
+// files. Any thumbnail is saved to thumbnail_path and the model's metadata
+// is logged.
+func openArchive(f *os.File, br *bufio.Reader) (io.ReadCloser, error) {
+	var ra io.ReaderAt = f
+	var size int64
+	if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+		size = fi.Size()
+	} else {
+		// zip needs random access, which a pipe can't give.
+		b, err := io.ReadAll(br)
+		if err != nil {
+			return nil, err
+		}
+		ra, size = bytes.NewReader(b), int64(len(b))
+	}
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var gcode, thumb *zip.File
+	for _, zf := range zr.File {
+		name := strings.ToLower(zf.Name)
+		switch {
+		case path.Ext(name) == ".gcode" && gcode == nil:
+			gcode = zf
+		case path.Ext(name) == ".png" && strings.Contains(name, "metadata/"):
+			// Prefer an image called thumbnail over e.g. plate_1.png.
+			if thumb == nil || strings.Contains(name, "thumbnail") {
+				thumb = zf
+			}
+		case name == "3d/3dmodel.model":
+			logModelMetadata(zf)
+		}
+	}
+	if gcode == nil {
+		return nil, errors.New("no GCode in archive; was it sliced?")
+	}
+	log.Print("Printing ", gcode.Name, " from archive")
+	if thumb != nil && thumbnail_path != "" {
+		if err := saveZipFile(thumb, thumbnail_path); err != nil {
+			log.Print("Saving thumbnail: ", err)
+		}
+	}
+	r, err := gcode.Open()
+	if err != nil {
+		return nil, err
+	}
+	return readCloser{r, f}, nil
+}
+
+// logModelMetadata logs the title, designer etc. of a 3MF model.
+func logModelMetadata(zf *zip.File) {
+	r, err := zf.Open()
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	var model struct {
+		Metadata []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"metadata"`
+	}
+	if xml.NewDecoder(r).Decode(&model) != nil {
+		return
+	}
+	for _, m := range model.Metadata {
+		if v := strings.TrimSpace(m.Value); v != "" {
+			log.Printf("%s: %s", m.Name, v)
+		}
+	}
+}
+
+func saveZipFile(zf *zip.File, dest string) error {
+	r, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
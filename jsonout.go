@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Set by -json: stdout carries every event as a JSON object on a line of its
+// own, for programs that drive dripp3r rather than people:
+//
+//	{"event":"sent","time":"…","line":"G1 X10 Y10"}
+//	{"event":"response","time":"…","line":"ok"}
+//	{"event":"console","time":"…","line":"-- HOMED all axes"}
+//	{"event":"temps","time":"…","hotend":209.8,"hotend_target":210,…}
+//	{"event":"status","time":"…","state":"printing","progress":0.42,…}
+//
+// and the hook events, e.g. job_start, layer_change, pause and error, as the
+// hooks are given them.
+var json_out bool
+
+// json_mu keeps the consoles of a daemon's printers from writing over each
+// other.
+var json_mu sync.Mutex
+
+// jsonHead starts every event.
+type jsonHead struct {
+	Event   string    `json:"event"`
+	Time    time.Time `json:"time"`
+	Printer string    `json:"printer,omitempty"`
+}
+
+// writeJSON writes an event to stdout.
+func writeJSON(ev interface{}) {
+	json_mu.Lock()
+	defer json_mu.Unlock()
+	json.NewEncoder(os.Stdout).Encode(ev)
+}
+
+// jsonLine writes a line of the console: a line sent to the printer, a
+// response from it, or dripp3r's own message.
+func (c *console) jsonLine(ln string) {
+	head := jsonHead{"console", time.Now(), c.name}
+	if s, ok := strings.CutPrefix(ln, ">> "); ok {
+		head.Event, ln = "sent", s
+	} else if s, ok := strings.CutPrefix(ln, "<< "); ok {
+		head.Event, ln = "response", s
+	}
+	writeJSON(struct {
+		jsonHead
+		Line string `json:"line"`
+	}{head, ln})
+}
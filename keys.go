@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+)
+
+// key is a key pressed on a terminal in cbreak mode: a rune, including
+// control characters, or one of the special keys below.
+type key rune
+
+const (
+	keyUp key = -1 - iota
+	keyDown
+	keyRight
+	keyLeft
+	keyHome
+	keyEnd
+	keyDelete
+	keyPgUp
+	keyPgDn
+	keyUnknown
+)
+
+const (
+	keyEsc       key = 0x1b
+	keyBackspace key = 0x7f
+)
+
+// Names of keys that aren't runes, as passed to hotkeys.
+var key_names = map[key]string{
+	keyUp:     "up",
+	keyDown:   "down",
+	keyRight:  "right",
+	keyLeft:   "left",
+	keyHome:   "home",
+	keyEnd:    "end",
+	keyDelete: "delete",
+	keyPgUp:   "pgup",
+	keyPgDn:   "pgdn",
+	keyEsc:    "esc",
+}
+
+func (k key) String() string {
+	if name, ok := key_names[k]; ok {
+		return name
+	}
+	return string(rune(k))
+}
+
+// ctrl returns the key for Ctrl and a letter.
+func ctrl(c rune) key {
+	return key(c & 0x1f)
+}
+
+// readKey reads a key, decoding the escape sequences terminals send for
+// arrows and the like. A lone Esc is told apart by nothing following it.
+func readKey(r *bufio.Reader) (key, error) {
+	c, _, err := r.ReadRune()
+	if err != nil || key(c) != keyEsc || r.Buffered() == 0 {
+		return key(c), err
+	}
+	if c, _, err = r.ReadRune(); err != nil {
+		return 0, err
+	}
+	if c != '[' && c != 'O' {
+		// Alt and a key.
+		return keyUnknown, nil
+	}
+	var params []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b < 0x40 || b > 0x7e {
+			params = append(params, b)
+			continue
+		}
+		// Modifiers come after a ';', e.g. Ctrl-Up is ESC [ 1;5A.
+		p, _, _ := strings.Cut(string(params), ";")
+		switch b {
+		case 'A':
+			return keyUp, nil
+		case 'B':
+			return keyDown, nil
+		case 'C':
+			return keyRight, nil
+		case 'D':
+			return keyLeft, nil
+		case 'H':
+			return keyHome, nil
+		case 'F':
+			return keyEnd, nil
+		case '~':
+			switch p {
+			case "1", "7":
+				return keyHome, nil
+			case "4", "8":
+				return keyEnd, nil
+			case "3":
+				return keyDelete, nil
+			case "5":
+				return keyPgUp, nil
+			case "6":
+				return keyPgDn, nil
+			}
+		}
+		return keyUnknown, nil
+	}
+}
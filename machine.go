@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Set by -machine: what the firmware drives. Lasers and CNC machines have
+// their tool turned off when a job stops, and have no heaters or filament.
+var machine = "printer"
+
+// What the stop sequence is for lasers and CNC machines: the laser or
+// spindle off, and the coolant too on a CNC machine.
+var tool_stop_gcode = map[string][]byte{
+	"laser": []byte("M5\n"),
+	"cnc":   []byte("M5\nM9\n"),
+}
+
+// checkMachine makes sure -machine names a machine, and refuses flags only
+// 3D printers have a use for.
+func checkMachine() error {
+	if machine == "printer" {
+		return nil
+	}
+	if tool_stop_gcode[machine] == nil {
+		return fmt.Errorf("-machine %s: want printer, laser or cnc", machine)
+	}
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"-first-layer", first_layer},
+		{"-level", level_bed},
+		{"-check-mesh", check_mesh},
+		{"-check-footprint", check_footprint},
+		{"-parallel-heat", parallel_heat},
+		{"-prime", prime},
+		{"-host-m600", host_m600},
+	} {
+		if f.set {
+			return fmt.Errorf("%s is for 3D printers, not -machine %s", f.name, machine)
+		}
+	}
+	return nil
+}
+
+// isPrinter tells whether the machine is a 3D printer.
+func isPrinter() bool {
+	return machine == "printer"
+}
+
+// errNotPrinter refuses what only 3D printers do, like heating and
+// extruding.
+var errNotPrinter = errors.New("not on a laser or CNC machine")
+
+// stopCodes returns the GCode dripped when a job stops.
+func stopCodes() []byte {
+	if isPrinter() {
+		return stop_gcode
+	}
+	return tool_stop_gcode[machine]
+}
+
+// toolOff turns the laser or spindle off straight away, when the loop ends
+// without dripping the stop GCodes, e.g. on an abort. Grbl's reset stops
+// the spindle, coolant and any motion; other firmware is sent M5 past the
+// lines waiting to go.
+func (d *dripper) toolOff() {
+	if isPrinter() {
+		return
+	}
+	d.con.Println("-- TOOL OFF")
+	if d.fw.reset != 0 {
+		d.realtime(d.fw.reset)
+		return
+	}
+	if _, err := d.port.Write([]byte("M5\n")); err != nil {
+		d.con.Println("-- ERROR:", err)
+	}
+}
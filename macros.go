@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Macros and aliases may use others, this deep.
+const max_macro_depth = 8
+
+// macroNames lists the macros in the [macros] section of the config file.
+func macroNames() []string {
+	var names []string
+	for name := range conf["macros"] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// findMacro returns the name of the macro line runs, ignoring case, or "".
+func findMacro(line string) string {
+	line = strings.TrimSpace(line)
+	for name := range conf["macros"] {
+		if strings.EqualFold(name, line) {
+			return name
+		}
+	}
+	return ""
+}
+
+// findAlias returns the name of the alias for a command, ignoring case, or
+// "".
+func findAlias(cmd string) string {
+	for name := range conf["aliases"] {
+		if strings.EqualFold(name, cmd) {
+			return name
+		}
+	}
+	return ""
+}
+
+// expandLine expands a line that is a macro, or starts with an alias, into
+// the lines to send. The rest of a line after an alias is added to the last
+// line of the alias, so with "home = G28", "home X" is "G28 X".
+func expandLine(line string, depth int) ([]string, error) {
+	line = strings.TrimSpace(line)
+	if depth > max_macro_depth {
+		return nil, fmt.Errorf("%s: nested too deep", line)
+	}
+	var body string
+	cmd, args, _ := strings.Cut(line, " ")
+	alias := findAlias(cmd)
+	if name := findMacro(line); name != "" {
+		body = conf.get("macros", name)
+	} else if alias != "" {
+		body = conf.get("aliases", alias)
+		if args != "" {
+			body += " " + args
+		}
+	} else {
+		return []string{line}, nil
+	}
+
+	var lines []string
+	for _, ln := range strings.Split(body, "\n") {
+		ln, _, _ = strings.Cut(ln, ";")
+		ln = strings.TrimSpace(ln)
+		if ln == "" {
+			continue
+		}
+		// An alias can add to the command it is named after.
+		if first, _, _ := strings.Cut(ln, " "); alias != "" &&
+			strings.EqualFold(first, alias) {
+			lines = append(lines, ln)
+			continue
+		}
+		more, err := expandLine(ln, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, more...)
+	}
+	return lines, nil
+}
+
+// queueLine queues a line typed or sent by the user, after expanding it.
+func (d *dripper) queueLine(line string) {
+	lines, err := expandLine(line, 0)
+	if err != nil {
+		d.con.Println("-- ERROR:", err)
+		return
+	}
+	d.hack_queue = append(d.hack_queue, lines...)
+}
+
+// hostLine reports whether a line is for dripp3r rather than the printer:
+// a command such as :run or @pause, or more than one line.
+func hostLine(line string) bool {
+	line = strings.TrimSpace(line)
+	return strings.HasPrefix(line, ":") || strings.HasPrefix(line, "@") ||
+		strings.ContainsAny(line, "\r\n")
+}
+
+func (d *dripper) runMacro(name string) {
+	d.con.Println("-- MACRO", name)
+	d.queueLine(name)
+}
+
+// macroMenu asks which macro to run, by number or name. It returns "" if
+// none was chosen.
+func macroMenu(userin <-chan string) (string, error) {
+	names := macroNames()
+	if len(names) == 0 {
+		fmt.Println("no [macros] in the config file")
+		return "", nil
+	}
+	fmt.Println("-- MACROS")
+	ans, err := pickMenu(userin, names, "macro> ")
+	if err != nil {
+		return "", err
+	}
+	if ans != "" && findMacro(ans) == "" {
+		fmt.Printf("no macro named %q\n", ans)
+	}
+	return findMacro(ans), nil
+}
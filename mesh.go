@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Set by -level and -check-mesh: level the bed, and ask for its mesh, before
+// each job.
+var level_bed, check_mesh bool
+
+// Unless the [mesh] section of the config file says otherwise, a mesh is
+// suspect if its points are more than mesh_max_deviation mm apart, or it
+// was probed over mesh_max_age days ago.
+const (
+	mesh_max_deviation = 1
+	mesh_max_age       = 7
+)
+
+// Matches a row of the mesh M420 V prints, e.g. " 0 +0.120 +0.050 -0.010"
+// or " 2 | +0.120 +0.050", but not the row of column numbers.
+var mesh_row_re = regexp.MustCompile(`^\s*\d+\s*\|?\s*[-+]?\d*\.\d+`)
+
+// parseMesh returns the points of the mesh reported by M420 V, or none if
+// the printer has no mesh.
+func parseMesh(resp []string) []float64 {
+	var points []float64
+	for _, ln := range resp {
+		ln = strings.TrimPrefix(ln, "echo:")
+		if !mesh_row_re.MatchString(ln) {
+			continue
+		}
+		ln = strings.NewReplacer("|", " ", "[", " ", "]", " ").Replace(ln)
+		for _, f := range strings.Fields(ln)[1:] {
+			if z, err := strconv.ParseFloat(f, 64); err == nil {
+				points = append(points, z)
+			}
+		}
+	}
+	return points
+}
+
+// levelBed homes and levels the bed ahead of the job's own start code, with
+// G29 or what the [level] section of the config file gives for the printer,
+// e.g. M420 S1 to load the mesh saved in EEPROM.
+func (d *dripper) levelBed() {
+	if !level_bed {
+		return
+	}
+	gcode := conf.get("level", d.name)
+	if gcode == "" {
+		gcode = "G29"
+	}
+	lines := strings.Split(gcode, "\n")
+	d.con.Println("-- LEVEL:", strings.Join(lines, "; "))
+	d.hack_queue = append(d.hack_queue, "G28")
+	d.hack_queue = append(d.hack_queue, lines...)
+}
+
+// checkMesh asks for the mesh at the start of a job, if -check-mesh is
+// given. The job waits for the answer.
+func (d *dripper) checkMesh() {
+	if !check_mesh {
+		return
+	}
+	d.mesh_check = true
+	d.hack_queue = append(d.hack_queue, "M420 V")
+}
+
+// meshChecked pauses the job if the mesh is missing, stale or uneven.
+func (d *dripper) meshChecked(resp []string) {
+	d.mesh_check = false
+	problem, err := d.meshProblem(parseMesh(resp))
+	if err != nil {
+		d.con.Println("-- MESH CHECK:", err)
+		return
+	}
+	if problem == "" {
+		return
+	}
+	d.con.Printf("-- MESH CHECK: %s. Paused: resume to print anyway.\n",
+		problem)
+	if d.gcode != nil && !d.paused {
+		d.paused = true
+		d.holdPosition()
+		d.emit(hookEvent{Event: "pause"})
+	}
+}
+
+// meshProblem returns what is wrong with the mesh, or "".
+func (d *dripper) meshProblem(points []float64) (string, error) {
+	if len(points) == 0 {
+		return "the printer has no bed leveling mesh", nil
+	}
+	lo, hi := points[0], points[0]
+	for _, z := range points {
+		lo, hi = math.Min(lo, z), math.Max(hi, z)
+	}
+	d.con.Printf("-- MESH %d points, %+.3f to %+.3f\n", len(points), lo, hi)
+	max, err := confFloat("mesh", "max_deviation", mesh_max_deviation)
+	if err != nil {
+		return "", err
+	}
+	if hi-lo > max {
+		return fmt.Sprintf("the mesh is %.2fmm from lowest to highest point, "+
+			"over %gmm", hi-lo, max), nil
+	}
+	days, err := confFloat("mesh", "max_age", mesh_max_age)
+	if err != nil {
+		return "", err
+	}
+	if fi, err := os.Stat(meshStampPath(d.name)); err == nil {
+		age := time.Since(fi.ModTime())
+		if age > time.Duration(days*float64(24*time.Hour)) {
+			return fmt.Sprintf("the mesh was probed %.0f days ago",
+				age.Hours()/24), nil
+		}
+	}
+	return "", nil
+}
+
+// meshProbed notes when the printer last probed its mesh (G29), for telling
+// whether it is stale.
+func (d *dripper) meshProbed(resp []string) {
+	for _, ln := range resp {
+		if strings.HasPrefix(ln, "Error:") {
+			return
+		}
+	}
+	path := meshStampPath(d.name)
+	if path == "" {
+		return
+	}
+	os.MkdirAll(filepath.Dir(path), 0700)
+	if err := os.WriteFile(path, []byte(time.Now().Format(time.RFC3339)+"\n"), 0600); err != nil {
+		d.con.Println("-- cannot note when the mesh was probed:", err)
+	}
+}
+
+func meshStampPath(name string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "dripp3r", "mesh-"+name)
+}
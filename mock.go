@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Set by -mock: a virtual Marlin printer named mock stands in for a real
+// one, for demos, screenshots and trying dripp3r out without one.
+var mock bool
+
+// The port name of the virtual printer.
+const mock_port = "mock"
+
+// mockPrinter is a virtual Marlin printer. It carries out each line in
+// turn, taking as long over moves as the estimator says (see estimate.go),
+// and heats up and cools down at a steady rate, reporting the temperatures
+// while it waits for them like Marlin. Lines that take long are kept alive
+// with busy messages, as often as M113 says. -timed speeds it up.
+type mockPrinter struct {
+	est       *estimator
+	keepalive time.Duration
+	lines     chan []byte
+	hotend    mockHeater
+	bed       mockHeater
+	r         *io.PipeReader
+	w         *io.PipeWriter
+}
+
+// mockHeater heats towards its target, or cools towards the room's
+// temperature, at so many degrees a second.
+type mockHeater struct {
+	temp, target float64
+	heat, cool   float64
+}
+
+const mock_room = 22.0
+
+// Seconds a heater has to hold its temperature before M109 or M190 is done,
+// as Marlin's TEMP_RESIDENCY_TIME.
+const mock_residency = 10
+
+func newMockPrinter() *mockPrinter {
+	m := &mockPrinter{
+		est:       newEstimator(),
+		keepalive: 2 * time.Second,
+		lines:     make(chan []byte, 256),
+		hotend:    mockHeater{temp: mock_room, heat: 3, cool: 1},
+		bed:       mockHeater{temp: mock_room, heat: 0.8, cool: 0.2},
+	}
+	m.r, m.w = io.Pipe()
+	go m.run()
+	return m
+}
+
+func (h *mockHeater) advance(secs float64) {
+	goal, rate := h.target, h.heat
+	if goal < mock_room {
+		goal = mock_room
+	}
+	if goal < h.temp {
+		rate = -h.cool
+	}
+	h.temp += rate * secs
+	if rate > 0 && h.temp > goal || rate < 0 && h.temp < goal {
+		h.temp = goal
+	}
+}
+
+// wait lets time pass on the printer, as fast as -timed says.
+func (m *mockPrinter) wait(d time.Duration) {
+	speed := timed
+	if speed <= 0 {
+		speed = 1
+	}
+	time.Sleep(time.Duration(float64(d) / speed))
+	m.hotend.advance(d.Seconds())
+	m.bed.advance(d.Seconds())
+}
+
+// busy lets a line take its time, saying it is busy every keepalive
+// interval meanwhile like Marlin.
+func (m *mockPrinter) busy(d time.Duration) error {
+	for m.keepalive > 0 && d > m.keepalive {
+		m.wait(m.keepalive)
+		d -= m.keepalive
+		if err := m.say("echo:busy: processing\n"); err != nil {
+			return err
+		}
+	}
+	m.wait(d)
+	return nil
+}
+
+func (m *mockPrinter) temps() string {
+	return fmt.Sprintf("T:%.2f /%.2f B:%.2f /%.2f @:0 B@:0", m.hotend.temp,
+		m.hotend.target, m.bed.temp, m.bed.target)
+}
+
+func (m *mockPrinter) say(format string, args ...interface{}) error {
+	_, err := fmt.Fprintf(m.w, format, args...)
+	return err
+}
+
+func (m *mockPrinter) run() {
+	if m.say("start\necho:Marlin 2.1.2 (dripp3r mock)\n") != nil {
+		return
+	}
+	for ln := range m.lines {
+		if m.answer(ln) != nil {
+			return
+		}
+	}
+}
+
+// answer carries out a line, and answers it as Marlin would.
+func (m *mockPrinter) answer(ln []byte) error {
+	cmd, args := gcodeWords(string(stripLineNumber(ln)))
+	switch cmd {
+	case "M104", "M140", "M109", "M190":
+		h := &m.hotend
+		if cmd == "M140" || cmd == "M190" {
+			h = &m.bed
+		}
+		if s, ok := args['S']; ok {
+			h.target = s
+		} else if r, ok := args['R']; ok {
+			h.target = r
+		}
+		// M109 S and M190 S wait only while heating; R waits either way.
+		// Once there, the temperature has to hold for the residency time,
+		// counted down in W:, which is ? until then.
+		_, either := args['R']
+		left := -1
+		for (cmd == "M109" || cmd == "M190") && h.target > 0 && left != 0 {
+			if h.temp < h.target-1 || either && h.temp > h.target+1 {
+				left = -1
+			} else if left < 0 {
+				left = mock_residency
+			}
+			m.wait(time.Second)
+			w := "?"
+			if left > 0 {
+				left--
+				w = strconv.Itoa(left)
+			}
+			if err := m.say(" %s W:%s\n", m.temps(), w); err != nil {
+				return err
+			}
+		}
+	case "M105":
+		return m.say("ok %s\n", m.temps())
+	case "M113":
+		if s, ok := args['S']; ok {
+			m.keepalive = time.Duration(s * float64(time.Second))
+		}
+	case "M114":
+		p := m.est.pos
+		return m.say("X:%.2f Y:%.2f Z:%.2f E:%.2f Count X:0 Y:0 Z:0\nok\n",
+			p.X, p.Y, p.Z, p.E)
+	case "M115":
+		return m.say("FIRMWARE_NAME:Marlin 2.1.2 (dripp3r mock) " +
+			"PROTOCOL_VERSION:1.0 MACHINE_TYPE:Mock EXTRUDER_COUNT:1\n" +
+			"Cap:EEPROM:0\nCap:AUTOREPORT_TEMP:0\nCap:HOST_ACTION_COMMANDS:1\nok\n")
+	default:
+		if err := m.busy(m.est.took(ln)); err != nil {
+			return err
+		}
+	}
+	return m.say("ok\n")
+}
+
+// Write takes in lines for the printer to carry out in turn. Real-time
+// bytes, which Marlin doesn't have, are dropped.
+func (m *mockPrinter) Write(b []byte) (int, error) {
+	for _, ln := range bytes.SplitAfter(b, []byte("\n")) {
+		if len(ln) > 1 && ln[len(ln)-1] == '\n' {
+			m.lines <- ln
+		}
+	}
+	return len(b), nil
+}
+
+func (m *mockPrinter) Read(b []byte) (int, error) {
+	return m.r.Read(b)
+}
+
+func (m *mockPrinter) Close() error {
+	return m.r.Close()
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+// The virtual printer reports where homing left it, or the idle M114 poll
+// would put the dripper back where it was.
+func TestMockHomes(t *testing.T) {
+	defer func(t float64) { timed = t }(timed)
+	timed = 1000
+	m := newMockPrinter()
+	defer m.Close()
+	rd := bufio.NewScanner(m)
+
+	for _, tc := range []struct{ line, want string }{
+		{"G1 X10 Y20 Z5 F6000", ""},
+		{"M114", "X:10.00 Y:20.00 Z:5.00"},
+		{"G28 X", ""},
+		{"M114", "X:0.00 Y:20.00 Z:5.00"},
+		{"N3 G28*18", ""},
+		{"M114", "X:0.00 Y:0.00 Z:0.00"},
+	} {
+		m.Write([]byte(tc.line + "\n"))
+		var got string
+		for rd.Scan() && rd.Text() != "ok" {
+			if strings.HasPrefix(rd.Text(), "X:") {
+				got = rd.Text()
+			}
+		}
+		if !strings.HasPrefix(got, tc.want) {
+			t.Errorf("M114 says %q, want %q", got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// moonraker serves a small part of Moonraker's API for one of the daemon's
+// printers, enough for Mainsail, Fluidd and the like to watch it and start,
+// pause and cancel jobs.
+type moonraker struct {
+	d     *dripper
+	con   *console
+	start time.Time
+}
+
+// Set by the daemon's -moonraker flag: [printer=]address to serve the
+// Moonraker API on.
+var moonraker_addr string
+
+// serveMoonraker serves the Moonraker API on -moonraker's address.
+func serveMoonraker(f *farm, con *console) error {
+	name, addr, ok := strings.Cut(moonraker_addr, "=")
+	if !ok {
+		name, addr = "", moonraker_addr
+	}
+	d, err := f.lookup(name)
+	if err != nil {
+		return fmt.Errorf("-moonraker: %w", err)
+	}
+	l, err := listenAPI("-moonraker", addr)
+	if err != nil {
+		return err
+	}
+	m := &moonraker{d: d, con: con, start: time.Now()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/websocket", m.serveWebSocket)
+	mux.HandleFunc("/", m.serveHTTP)
+	log.Print("Moonraker API on ", l.Addr())
+	serveAPI(l, mux)
+	return nil
+}
+
+// mrError is an error with the HTTP status Moonraker would answer it with.
+type mrError struct {
+	code int
+	msg  string
+}
+
+func (e *mrError) Error() string {
+	return e.msg
+}
+
+// serveHTTP answers the API's HTTP requests, e.g. GET /printer/info or POST
+// /printer/print/start?filename=part.gcode, each the same as the WebSocket
+// method named by its path: printer.print.start.
+func (m *moonraker) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requestKey(r); !ok {
+		mrHTTPError(w, &mrError{http.StatusUnauthorized, "Unauthorized"})
+		return
+	}
+	method := strings.ReplaceAll(strings.Trim(r.URL.Path, "/"), "/", ".")
+	// Lest a link or an image on any web page start or cancel a print.
+	if mrControls(method) && r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		mrHTTPError(w, &mrError{http.StatusMethodNotAllowed, "POST to " + r.URL.Path})
+		return
+	}
+	params := make(map[string]interface{})
+	objects := make(map[string]interface{})
+	for key, vals := range r.URL.Query() {
+		v := vals[0]
+		if method != "printer.objects.query" {
+			params[key] = v
+		} else if v == "" {
+			objects[key] = nil
+		} else {
+			var fields []interface{}
+			for _, f := range strings.Split(v, ",") {
+				fields = append(fields, f)
+			}
+			objects[key] = fields
+		}
+	}
+	params["objects"] = objects
+	c := requestClient(r, "Moonraker")
+	res, err := m.call(method, params, &c, nil)
+	if err != nil {
+		mrHTTPError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"result": res})
+}
+
+// fileIn finds a file a client named in dir, the directory setting says
+// it may print from, refusing names that lead out of it.
+func fileIn(setting, dir, name string) (string, error) {
+	if dir == "" {
+		return "", fmt.Errorf("no %s directory in the config file to print from", setting)
+	}
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("%s: not a file in %s", name, setting)
+	}
+	path := filepath.Join(dir, name)
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: not a file in %s", name, setting)
+	}
+	return path, nil
+}
+
+// mrHTTPError answers an HTTP request with an error, as Moonraker does.
+func mrHTTPError(w http.ResponseWriter, err error) {
+	code := http.StatusBadRequest
+	var merr *mrError
+	if errors.As(err, &merr) {
+		code = merr.code
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"code": code, "message": err.Error()},
+	})
+}
+
+// mrControls reports whether a method changes what the printer does, which
+// a monitor's key may not.
+func mrControls(method string) bool {
+	switch method {
+	case "printer.print.start", "printer.print.pause", "printer.print.resume",
+		"printer.print.cancel", "printer.gcode.script":
+		return true
+	}
+	return false
+}
+
+// mrSession is what a WebSocket client has subscribed to.
+type mrSession struct {
+	objects map[string]interface{}
+	sent    map[string]map[string]interface{}
+	addr    string
+	client  *apiClient // once they have sent a key, in the headers or on identifying
+}
+
+// serveWebSocket speaks JSON-RPC 2.0 over a WebSocket, as Moonraker does,
+// and sends notify_status_update and notify_gcode_response notifications.
+func (m *moonraker) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	ws, err := wsUpgrade(w, r, strings.Fields(conf.get("moonraker", "origins")))
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+	notes := m.con.attach()
+	defer m.con.detach(notes)
+
+	var call struct {
+		ID     json.RawMessage        `json:"id"`
+		Method string                 `json:"method"`
+		Params map[string]interface{} `json:"params"`
+	}
+	calls := make(chan []byte)
+	go func() {
+		defer close(calls)
+		for {
+			msg, err := ws.read()
+			if err != nil {
+				return
+			}
+			calls <- msg
+		}
+	}()
+	s := &mrSession{addr: r.RemoteAddr}
+	if _, ok := requestKey(r); ok {
+		c := requestClient(r, "Moonraker")
+		s.client = &c
+	}
+	for {
+		var out interface{}
+		select {
+		case msg, ok := <-calls:
+			if !ok {
+				return
+			}
+			call.ID, call.Params = nil, nil
+			resp := rpcResponse{Version: "2.0"}
+			if err := json.Unmarshal(msg, &call); err != nil {
+				resp.Error = &rpcError{rpcParseError, err.Error()}
+				out = resp
+				break
+			}
+			resp.ID = call.ID
+			res, err := m.call(call.Method, call.Params, s.client, s)
+			if err == nil {
+				resp.Result, err = json.Marshal(res)
+			}
+			if err != nil {
+				resp.Error = &rpcError{rpcAppError, err.Error()}
+			}
+			out = resp
+		case note := <-notes:
+			out = m.notice(note, s)
+		}
+		if out == nil {
+			continue
+		}
+		msg, _ := json.Marshal(out)
+		if ws.write(msg) != nil {
+			return
+		}
+	}
+}
+
+// notice turns a console notification about the printer into Moonraker's,
+// or returns nil.
+func (m *moonraker) notice(note rpcNotice, s *mrSession) interface{} {
+	switch p := note.Params.(type) {
+	case consoleLine:
+		// The lines sent are left out, like Moonraker does.
+		if p.Printer == m.d.con.name && !strings.HasPrefix(p.Line, ">> ") {
+			return rpcNotice{"2.0", "notify_gcode_response", []string{p.Line}}
+		}
+	case consoleStatus:
+		if p.Printer == m.d.con.name && s.objects != nil {
+			if diff := s.update(mrObjects(p.ctlStatus)); len(diff) > 0 {
+				return rpcNotice{"2.0", "notify_status_update",
+					[]interface{}{diff, m.eventtime()}}
+			}
+		}
+	}
+	return nil
+}
+
+// update returns the subscribed fields that changed since they were last
+// sent.
+func (s *mrSession) update(all map[string]map[string]interface{}) map[string]map[string]interface{} {
+	diff := make(map[string]map[string]interface{})
+	for name, obj := range filterObjects(all, s.objects) {
+		for k, v := range obj {
+			if reflect.DeepEqual(s.sent[name][k], v) {
+				continue
+			}
+			if diff[name] == nil {
+				diff[name] = make(map[string]interface{})
+			}
+			diff[name][k] = v
+			if s.sent[name] == nil {
+				s.sent[name] = make(map[string]interface{})
+			}
+			s.sent[name][k] = v
+		}
+	}
+	return diff
+}
+
+func (m *moonraker) eventtime() float64 {
+	return time.Since(m.start).Seconds()
+}
+
+// call runs one of the API's methods for a client. s is the WebSocket
+// client's session, or nil over HTTP.
+func (m *moonraker) call(method string, params map[string]interface{}, c *apiClient, s *mrSession) (interface{}, error) {
+	str := func(key string) string {
+		v, _ := params[key].(string)
+		return v
+	}
+	// A WebSocket client without the key in its headers, as a browser's
+	// is, sends it when it identifies itself.
+	if s != nil && s.client == nil {
+		k, ok := lookupKey(str("api_key"))
+		if method != "server.connection.identify" || !ok {
+			return nil, &mrError{http.StatusUnauthorized, "Unauthorized"}
+		}
+		s.client = &apiClient{k, s.addr, "Moonraker"}
+		c = s.client
+	}
+	if mrControls(method) {
+		if !c.control {
+			return nil, &mrError{http.StatusForbidden, "Forbidden"}
+		}
+		what := strings.TrimPrefix(method, "printer.")
+		if v := str("filename") + str("script"); v != "" {
+			what += " " + strings.ReplaceAll(v, "\n", "; ")
+		}
+		if err := c.admit(m.d, what); err != nil {
+			return nil, &mrError{http.StatusTooManyRequests, err.Error()}
+		}
+	}
+	switch method {
+	case "server.info":
+		return map[string]interface{}{
+			"klippy_connected":  true,
+			"klippy_state":      m.klippyState(),
+			"components":        []string{},
+			"moonraker_version": "dripp3r",
+		}, nil
+	case "server.connection.identify":
+		return map[string]interface{}{"connection_id": 1}, nil
+	case "printer.info":
+		state := m.klippyState()
+		host, _ := os.Hostname()
+		return map[string]interface{}{
+			"state":            state,
+			"state_message":    "Printer is " + state,
+			"hostname":         host,
+			"software_version": "dripp3r",
+		}, nil
+	case "printer.objects.list":
+		var names []string
+		for name := range mrObjects(ctlStatus{}) {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return map[string]interface{}{"objects": names}, nil
+	case "printer.objects.query", "printer.objects.subscribe":
+		objects, _ := params["objects"].(map[string]interface{})
+		st, err := m.status()
+		if err != nil {
+			return nil, err
+		}
+		all := mrObjects(st)
+		if method == "printer.objects.subscribe" {
+			if s == nil {
+				return nil, &mrError{http.StatusBadRequest,
+					"subscribe over the WebSocket"}
+			}
+			s.objects = objects
+			s.sent = make(map[string]map[string]interface{})
+			s.update(all)
+		}
+		return map[string]interface{}{
+			"eventtime": m.eventtime(),
+			"status":    filterObjects(all, objects),
+		}, nil
+	case "printer.print.start":
+		name := str("filename")
+		if name == "" {
+			return nil, &mrError{http.StatusBadRequest, "missing filename"}
+		}
+		path, err := fileIn("[moonraker] gcodes", conf.get("moonraker", "gcodes"), name)
+		if err != nil {
+			return nil, &mrError{http.StatusBadRequest, err.Error()}
+		}
+		return m.control("print", ctlParams{Path: path})
+	case "printer.print.pause":
+		return m.control("pause", ctlParams{})
+	case "printer.print.resume":
+		return m.control("resume", ctlParams{})
+	case "printer.print.cancel":
+		return m.control("cancel", ctlParams{})
+	case "printer.gcode.script":
+		var lines []string
+		for _, line := range strings.Split(str("script"), "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			if hostLine(line) {
+				return nil, &mrError{http.StatusBadRequest, "not GCode: " + line}
+			}
+			lines = append(lines, line)
+		}
+		for _, line := range lines {
+			if _, err := m.control("raw", ctlParams{GCode: line}); err != nil {
+				return nil, err
+			}
+		}
+		return "ok", nil
+	case "server.files.list":
+		return m.listFiles()
+	}
+	return nil, &mrError{http.StatusNotFound, "unknown method: " + method}
+}
+
+// control runs a control request on the printer, answering "ok" as
+// Moonraker does.
+func (m *moonraker) control(method string, params ctlParams) (interface{}, error) {
+	rep := m.d.call(rpcRequest{Method: method, Params: params})
+	if rep.err != nil {
+		return nil, rep.err
+	}
+	return "ok", nil
+}
+
+func (m *moonraker) status() (ctlStatus, error) {
+	rep := m.d.call(rpcRequest{Method: "status"})
+	if rep.err != nil {
+		return ctlStatus{}, rep.err
+	}
+	return rep.result.(ctlStatus), nil
+}
+
+// klippyState is the state of Klipper that the printer's is closest to.
+func (m *moonraker) klippyState() string {
+	if st, err := m.status(); err != nil || st.State == "halted" {
+		return "shutdown"
+	}
+	return "ready"
+}
+
+// listFiles lists the GCode files in the [moonraker] gcodes directory.
+func (m *moonraker) listFiles() (interface{}, error) {
+	dir := conf.get("moonraker", "gcodes")
+	if dir == "" {
+		return nil, &mrError{http.StatusNotFound, "no gcodes directory in the config file"}
+	}
+	files := []map[string]interface{}{}
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || !isGCodeFile(path) {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files = append(files, map[string]interface{}{
+			"path":     filepath.ToSlash(rel),
+			"modified": float64(fi.ModTime().UnixNano()) / 1e9,
+			"size":     fi.Size(),
+		})
+		return nil
+	})
+	return files, err
+}
+
+// isGCodeFile tells whether a file is one dripp3r can print.
+func isGCodeFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gcode", ".gco", ".g", ".nc", ".gz", ".3mf", ".ufp":
+		return true
+	}
+	return false
+}
+
+// mrObjects is the printer's status as the Klipper printer objects that
+// Moonraker reports.
+func mrObjects(st ctlStatus) map[string]map[string]interface{} {
+	state := "standby"
+	switch st.State {
+	case "printing", "pausing":
+		state = "printing"
+	case "paused":
+		state = "paused"
+	case "halted":
+		state = "error"
+	}
+	webhooks := "ready"
+	if st.State == "halted" {
+		webhooks = "shutdown"
+	}
+	idle, filename := "Idle", ""
+	if st.Job != "" {
+		idle, filename = "Printing", filepath.Base(st.Job)
+	}
+	p := st.Position
+	return map[string]map[string]interface{}{
+		"webhooks": {"state": webhooks, "state_message": "Printer is " + webhooks},
+		"print_stats": {
+			"filename": filename,
+			"state":    state,
+		},
+		"virtual_sdcard": {
+			"file_path": st.Job,
+			"is_active": state == "printing",
+			"progress":  st.Progress,
+		},
+		"pause_resume": {"is_paused": st.State == "paused"},
+		"idle_timeout": {"state": idle},
+		"extruder": {
+			"temperature": st.Temps.Hotend,
+			"target":      st.Temps.HotendTarget,
+		},
+		"heater_bed": {
+			"temperature": st.Temps.Bed,
+			"target":      st.Temps.BedTarget,
+		},
+		"fan": {"speed": float64(st.Fan) / 100},
+		"toolhead": {
+			"position": []float64{p.X, p.Y, p.Z, p.E},
+		},
+		"gcode_move": {
+			"speed_factor":   float64(st.Feedrate) / 100,
+			"extrude_factor": float64(st.Flow) / 100,
+			"gcode_position": []float64{p.X, p.Y, p.Z, p.E},
+			"homing_origin":  []float64{0, 0, st.Babystep, 0},
+		},
+	}
+}
+
+// filterObjects picks the objects asked for out of all, each with all its
+// fields if they are nil, or else only those listed.
+func filterObjects(all map[string]map[string]interface{}, objects map[string]interface{}) map[string]map[string]interface{} {
+	out := make(map[string]map[string]interface{})
+	for name, fields := range objects {
+		obj, ok := all[name]
+		if !ok {
+			continue
+		}
+		list, _ := fields.([]interface{})
+		if list == nil {
+			out[name] = obj
+			continue
+		}
+		out[name] = make(map[string]interface{})
+		for _, f := range list {
+			if k, ok := f.(string); ok {
+				if v, ok := obj[k]; ok {
+					out[name][k] = v
+				}
+			}
+		}
+	}
+	return out
+}
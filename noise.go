@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// noiseFilter keeps repetitive lines off the screen, as the [console]
+// section of the config file says: hide and collapse each list kinds of line
+// by name, or as /regular expressions/ matched against the line shown. Lines
+// hidden are never shown, and of a run of lines collapsed only the first is,
+// followed by how many more there were once something else is shown. Either
+// way monitors still get every line, as does the log file, if there is one.
+type noiseFilter struct {
+	hide     []*regexp.Regexp
+	collapse []*regexp.Regexp
+	log      *os.File
+}
+
+// The kinds of line the filters know by name.
+var noise_kinds = map[string]string{
+	"sent":  `^>> `,
+	"ok":    `^<< ok$`,
+	"temps": `^<< (ok )?T:`,
+	"wait":  `^<< wait$`,
+	"busy":  `^<< echo:busy:`,
+}
+
+var noise noiseFilter
+
+// checkConsole makes sure -timestamps says how to stamp lines and -q isn't
+// given with -v or -trace, reads the
+// console's filters from the config file, and opens its log file.
+func checkConsole() error {
+	switch timestamps {
+	case "", "clock", "job":
+	default:
+		return fmt.Errorf("-timestamps %s: want clock or job", timestamps)
+	}
+	if terse && (verbose || trace) {
+		return errors.New("-q shows no traffic for -v or -trace to add to")
+	}
+	verbose = verbose || trace || json_out
+	var err error
+	if noise.hide, err = noisePatterns("hide"); err != nil {
+		return err
+	}
+	if noise.collapse, err = noisePatterns("collapse"); err != nil {
+		return err
+	}
+	if path := conf.get("console", "log"); path != "" {
+		noise.log, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("[console] log: %w", err)
+		}
+	}
+	return nil
+}
+
+func noisePatterns(key string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, word := range strings.Fields(conf.get("console", key)) {
+		expr, ok := noise_kinds[word]
+		if len(word) > 2 && word[0] == '/' && word[len(word)-1] == '/' {
+			expr, ok = word[1:len(word)-1], true
+		}
+		if !ok {
+			return nil, fmt.Errorf("[console] %s: %q is not sent, ok, temps, "+
+				"wait, busy or a /regular expression/", key, word)
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("[console] %s: %w", key, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+func matchNoise(res []*regexp.Regexp, ln string) *regexp.Regexp {
+	for _, re := range res {
+		if re.MatchString(ln) {
+			return re
+		}
+	}
+	return nil
+}
+
+// screen tells whether the console shows a line, first showing how many
+// were collapsed before it. The console's lock is held.
+func (c *console) screen(ln string) bool {
+	if terse && (strings.HasPrefix(ln, ">> ") || strings.HasPrefix(ln, "<< ")) {
+		return false
+	}
+	if matchNoise(noise.hide, ln) != nil {
+		return false
+	}
+	re := matchNoise(noise.collapse, ln)
+	if re != nil && re == c.run {
+		c.more++
+		return false
+	}
+	c.showMore()
+	c.run = re
+	return true
+}
+
+// endRun shows how many lines were collapsed at the end of the last run.
+func (c *console) endRun() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.showMore()
+	c.run = nil
+}
+
+func (c *console) showMore() {
+	if c.more > 0 {
+		c.show(fmt.Sprintf("-- (%d more like it)", c.more))
+	}
+	c.more = 0
+}
+
+// logLine copies a line to the log file, if there is one.
+func (c *console) logLine(ln string) {
+	if noise.log == nil {
+		return
+	}
+	if c.name != "" {
+		ln = "[" + c.name + "] " + ln
+	}
+	noise.log.WriteString(ln + "\n")
+}
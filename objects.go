@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// objectComment turns the lines slicers use to label objects into host
+// commands, or returns nil: "@object name" where an object starts and
+// "@object" where it ends, and "@define_object name" for objects listed up
+// front. PrusaSlicer writes "; printing object name" and "; stop printing
+// object name", Cura ";MESH:name" and ";MESH:NONMESH", and files for
+// Klipper EXCLUDE_OBJECT_DEFINE, _START and _END. Marlin's M486 S numbers
+// the objects instead.
+func objectComment(ln []byte) []byte {
+	switch {
+	case bytes.HasPrefix(ln, []byte("; stop printing object")),
+		bytes.Equal(ln, []byte(";MESH:NONMESH")),
+		bytes.HasPrefix(ln, []byte("EXCLUDE_OBJECT_END")):
+		return []byte("@object")
+	case bytes.HasPrefix(ln, []byte("; printing object ")):
+		return append([]byte("@object "), ln[len("; printing object "):]...)
+	case bytes.HasPrefix(ln, []byte(";MESH:")):
+		return append([]byte("@object "), ln[len(";MESH:"):]...)
+	case bytes.HasPrefix(ln, []byte("EXCLUDE_OBJECT_START ")):
+		return append([]byte("@object "), objectName(ln)...)
+	case bytes.HasPrefix(ln, []byte("EXCLUDE_OBJECT_DEFINE ")):
+		return append([]byte("@define_object "), objectName(ln)...)
+	}
+	if s, ok := gcodeParam(ln, "M486", 'S'); ok {
+		if s < 0 {
+			return []byte("@object")
+		}
+		return []byte("@object " + strconv.Itoa(int(s)))
+	}
+	return nil
+}
+
+// objectName returns the NAME= parameter of a Klipper object command.
+func objectName(ln []byte) []byte {
+	for _, f := range bytes.Fields(ln) {
+		if name, ok := bytes.CutPrefix(f, []byte("NAME=")); ok {
+			return bytes.Trim(name, `"'`)
+		}
+	}
+	return nil
+}
+
+// startObject notes which object the lines that follow print, or none.
+func (d *dripper) startObject(name string) {
+	if name == d.object {
+		return
+	}
+	if d.excluded[d.object] {
+		d.rejoin()
+	}
+	d.object = name
+	d.defineObject(name)
+}
+
+func (d *dripper) defineObject(name string) {
+	if name == "" {
+		return
+	}
+	for _, o := range d.objects {
+		if o == name {
+			return
+		}
+	}
+	d.objects = append(d.objects, name)
+}
+
+// skipLine tells whether a line of the job prints an excluded object, and
+// if so follows where it would have moved the head.
+func (d *dripper) skipLine(line []byte) bool {
+	if !d.excluded[d.object] || d.gcode != d.gcode_file {
+		return false
+	}
+	for _, g := range []string{"G0", "G1", "G2", "G3"} {
+		if isGCode(line, g) {
+			d.trackMove(line)
+			return true
+		}
+	}
+	return false
+}
+
+// rejoin puts the printer where the job expects it after skipping an
+// object: at the height the skipped moves left off, with the extruder
+// position they got to. The next object's own travel move takes the head
+// there.
+func (d *dripper) rejoin() {
+	if !d.relative_xyz {
+		d.hack_queue = append(d.hack_queue, "G0 Z"+gnum(d.pos.Z))
+	}
+	if !d.relative_e {
+		d.hack_queue = append(d.hack_queue, "G92 E"+gnum(d.pos.E))
+	}
+}
+
+// excludeObject stops printing an object for the rest of the job, e.g.
+// because it came off the bed, and carries on with the others.
+func (d *dripper) excludeObject(name string) (string, error) {
+	if d.job_name == "" {
+		return "", errors.New("no job")
+	}
+	found := false
+	for _, o := range d.objects {
+		found = found || o == name
+	}
+	if !found {
+		return "", fmt.Errorf("no object named %q", name)
+	}
+	if d.excluded[name] {
+		return "", fmt.Errorf("%s is excluded already", name)
+	}
+	if d.excluded == nil {
+		d.excluded = make(map[string]bool)
+	}
+	d.excluded[name] = true
+	d.con.Println("-- EXCLUDE OBJECT", name)
+	return "excluded " + name, nil
+}
+
+// objectList lists the job's objects seen so far, marking excluded ones.
+func (d *dripper) objectList() []string {
+	var list []string
+	for _, o := range d.objects {
+		if d.excluded[o] {
+			o += " (excluded)"
+		}
+		list = append(list, o)
+	}
+	return list
+}
+
+// ctlExclude lists the objects of the daemon's job, or excludes one:
+//
+//	dripp3r exclude
+//	dripp3r exclude "Shape-Box id:0 copy 0"
+func ctlExclude(sock, printer string, args []string) error {
+	params := ctlParams{Printer: printer}
+	switch len(args) {
+	case 0:
+		var list []string
+		if err := ctlCall(sock, "objects", params, &list); err != nil {
+			return err
+		}
+		for _, o := range list {
+			fmt.Println(o)
+		}
+		return nil
+	case 1:
+		params.Name = args[0]
+		var msg string
+		if err := ctlCall(sock, "exclude", params, &msg); err != nil {
+			return err
+		}
+		fmt.Println(msg)
+		return nil
+	}
+	usage()
+	return nil
+}
+
+// objectMenu asks which object to exclude. It returns "" if none was chosen.
+func (d *dripper) objectMenu(userin <-chan string) (string, error) {
+	if len(d.objects) == 0 {
+		fmt.Println("no labelled objects in the job so far")
+		return "", nil
+	}
+	fmt.Println("-- OBJECTS")
+	ans, err := pickMenu(userin, d.objectList(), "object> ")
+	if err != nil {
+		return "", err
+	}
+	name, _ := strings.CutSuffix(ans, " (excluded)")
+	return name, nil
+}
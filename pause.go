@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// requestPause pauses the job at the next safe point, rather than in the
+// middle of an extrusion where the nozzle would leave a blob: after the next
+// travel move, at the next layer change, or at once, as the [pause] at
+// setting says. Asking again while waiting pauses at once.
+func (d *dripper) requestPause() (string, error) {
+	at := conf.get(d.section("pause", "at"), "at")
+	switch at {
+	case "":
+		at = "travel"
+	case "travel", "layer", "now":
+	default:
+		return "", fmt.Errorf("[%s] at: want travel, layer or now, not %q",
+			d.section("pause", "at"), at)
+	}
+	if at == "now" || d.pause_at != "" {
+		d.pauseHere()
+		return "paused", nil
+	}
+	d.pause_at = at
+	if at == "layer" {
+		d.con.Println("-- PAUSING at the next layer change. Press p again to pause now.")
+	} else {
+		d.con.Println("-- PAUSING after the next travel move. Press p again to pause now.")
+	}
+	return "pausing", nil
+}
+
+// pauseHere pauses the job once the line in flight is done.
+func (d *dripper) pauseHere() {
+	d.pause_at = ""
+	d.con.Println("-- PAUSE: Press p to resume.")
+	d.paused = true
+	d.holdPosition()
+	d.park("pause")
+	d.emit(hookEvent{Event: "pause"})
+}
+
+// cancelPause forgets a pause that is waiting for a safe point.
+func (d *dripper) cancelPause() {
+	d.pause_at = ""
+	d.con.Println("-- PAUSE CANCELLED")
+}
+
+// pauseAt pauses the job if a pause is waiting for the kind of point just
+// reached.
+func (d *dripper) pauseAt(at string) {
+	if d.pause_at == at && !d.paused {
+		d.pauseHere()
+	}
+}
+
+// isTravel tells whether a line moves the head across without extruding.
+func isTravel(line []byte) bool {
+	if !isGCode(line, "G0") && !isGCode(line, "G1") {
+		return false
+	}
+	xy := false
+	for _, arg := range bytes.Fields(line)[1:] {
+		switch arg[0] {
+		case ';':
+			return xy
+		case 'E', 'e':
+			return false
+		case 'X', 'x', 'Y', 'y':
+			xy = true
+		}
+	}
+	return xy
+}
+
+// How fast the filament is pulled back when parking and pushed out again on
+// resuming, in mm/min.
+const pause_retract_feed = 2400
+
+// park moves the nozzle off the print, as a section of the config file says:
+// the filament is retracted by retract mm, the nozzle lifted by lift mm, then
+// moved to park_x and park_y. Nothing moves unless they are set. [pause]
+// parks while paused, [timelapse] for each frame.
+func (d *dripper) park(section string) {
+	var v [5]float64
+	for i, key := range []string{"retract", "prime", "lift", "park_x", "park_y"} {
+		f, err := confFloat(d.section(section, key), key, math.NaN())
+		if err != nil {
+			d.con.Println("-- cannot park:", err)
+			return
+		}
+		v[i] = f
+	}
+	retract, prime, lift, x, y := v[0], v[1], v[2], v[3], v[4]
+	if d.hold == nil || math.IsNaN(retract) && math.IsNaN(lift) &&
+		math.IsNaN(x) && math.IsNaN(y) {
+		return
+	}
+	if retract > 0 {
+		if _, err := d.extrudeBy(-retract, pause_retract_feed); err != nil {
+			d.con.Println("-- not retracting:", err)
+		} else if math.IsNaN(prime) {
+			d.hold.prime += retract
+		}
+	}
+	if prime > 0 {
+		d.hold.prime += prime
+	}
+	// moveBy leaves the printer in absolute positioning.
+	if lift > 0 {
+		d.moveBy("G1 Z" + gnum(lift) + " F600")
+	} else {
+		d.hack_queue = append(d.hack_queue, "G90")
+	}
+	if !math.IsNaN(x) || !math.IsNaN(y) {
+		d.con.Println("-- PARK")
+		d.hack_queue = append(d.hack_queue,
+			"G1"+axisArg(" X", x)+axisArg(" Y", y)+" F3000")
+	}
+}
+
+// axisArg returns a GCode argument, or "" for NaN.
+func axisArg(axis string, v float64) string {
+	if math.IsNaN(v) {
+		return ""
+	}
+	return axis + gnum(v)
+}
+
+// gnum formats a number for a GCode argument, to 5 decimal places at most.
+// Firmware stops reading a number at an exponent, so %g's 1e-17 would be 1.
+func gnum(v float64) string {
+	// Adding 0 turns -0 into 0.
+	return strconv.FormatFloat(math.Round(v*1e5)/1e5+0, 'f', -1, 64)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// plugin is a program started for each printer from the [plugins] section of
+// the config file. Events are sent to its stdin as JSON-RPC "event"
+// notifications, and it may write control channel requests to its stdout,
+// which are answered on its stdin.
+type plugin struct {
+	name string
+	in   chan []byte   // lines for the plugin's stdin
+	stop chan struct{} // closes its stdin
+	done chan struct{} // closed once it has exited
+}
+
+// startExtensions loads the -script and starts the plugins for a printer.
+func (d *dripper) startExtensions() error {
+	if script_path != "" {
+		if err := d.loadScript(script_path); err != nil {
+			return err
+		}
+	}
+	var names []string
+	for name := range conf["plugins"] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := d.startPlugin(name, conf.get("plugins", name)); err != nil {
+			return fmt.Errorf("plugin %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (d *dripper) startPlugin(name, cmdline string) error {
+	cmd := shellCommand(cmdline)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	p := &plugin{
+		name: name,
+		in:   make(chan []byte, 64),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer stdin.Close()
+		for {
+			select {
+			case ln := <-p.in:
+				if _, err := stdin.Write(ln); err != nil {
+					return
+				}
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	go func() {
+		defer close(p.done)
+		scan := bufio.NewScanner(stdout)
+		for scan.Scan() {
+			var call rpcRequest
+			var resp rpcResponse
+			if err := json.Unmarshal(scan.Bytes(), &call); err != nil {
+				resp = rpcResponse{
+					Version: "2.0",
+					Error:   &rpcError{rpcParseError, err.Error()},
+				}
+			} else if resp = d.call(call).response(call.ID); call.ID == nil {
+				// No reply to notifications.
+				continue
+			}
+			data, _ := json.Marshal(resp)
+			select {
+			case p.in <- append(data, '\n'):
+			case <-p.stop:
+			}
+		}
+		log.Printf("Plugin %s exited: %v", name, cmd.Wait())
+	}()
+	d.plugins = append(d.plugins, p)
+	return nil
+}
+
+// notify sends an event to the plugin, unless it has fallen behind.
+func (p *plugin) notify(ev hookEvent) {
+	data, _ := json.Marshal(rpcNotice{"2.0", "event", ev})
+	select {
+	case p.in <- append(data, '\n'):
+	default:
+		log.Printf("Plugin %s missed a %s event", p.name, ev.Event)
+	}
+}
+
+// close closes the plugin's stdin and gives it a moment to exit.
+func (p *plugin) close() {
+	close(p.stop)
+	select {
+	case <-p.done:
+	case <-time.After(5 * time.Second):
+		log.Printf("Plugin %s did not exit", p.name)
+	}
+}
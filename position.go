@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// position is where the printer has been told to go, in mm.
+type position struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+	E float64 `json:"e"`
+}
+
+func (p position) String() string {
+	return fmt.Sprintf("X%.2f Y%.2f Z%.2f", p.X, p.Y, p.Z)
+}
+
+// Matches the "X:10.00 Y:20.00 Z:0.30 E:5.12 Count X:800 ..." reply to M114.
+var position_re = regexp.MustCompile(
+	`^(?:ok )?X:(-?[\d.]+) Y:(-?[\d.]+) Z:(-?[\d.]+) E:(-?[\d.]+)`)
+
+// axis returns the coordinate for an axis letter, or nil.
+func (p *position) axis(c byte) *float64 {
+	switch c {
+	case 'X', 'x':
+		return &p.X
+	case 'Y', 'y':
+		return &p.Y
+	case 'Z', 'z':
+		return &p.Z
+	case 'E', 'e':
+		return &p.E
+	}
+	return nil
+}
+
+// trackMove follows the position through the moves, homing and G92 in a
+// line sent to the printer.
+func (d *dripper) trackMove(line []byte) {
+	f := bytes.Fields(line)
+	if len(f) == 0 {
+		return
+	}
+	cmd := string(bytes.ToUpper(f[0]))
+	switch cmd {
+	case "G0", "G1", "G2", "G3", "G92":
+	case "G28":
+		// Good enough until the printer reports where home is.
+		if len(f) == 1 {
+			d.pos.X, d.pos.Y, d.pos.Z = 0, 0, 0
+		}
+		for _, arg := range f[1:] {
+			if c := d.pos.axis(arg[0]); c != nil {
+				*c = 0
+			}
+		}
+		return
+	default:
+		return
+	}
+	for _, arg := range f[1:] {
+		v, err := strconv.ParseFloat(string(arg[1:]), 64)
+		if err != nil {
+			continue
+		}
+		if (arg[0] == 'F' || arg[0] == 'f') && cmd != "G92" {
+			d.feed = v
+		}
+		c := d.pos.axis(arg[0])
+		if c == nil {
+			continue
+		}
+		relative := d.relative_xyz
+		if c == &d.pos.E {
+			relative = d.relative_e
+		}
+		if relative && cmd != "G92" {
+			*c += v
+		} else {
+			*c = v
+		}
+	}
+}
+
+// trackPosition picks up the position reported by M114.
+func (d *dripper) trackPosition(ln string) {
+	m := position_re.FindStringSubmatch(ln)
+	if m == nil {
+		return
+	}
+	var p position
+	for i, c := range []*float64{&p.X, &p.Y, &p.Z, &p.E} {
+		v, err := strconv.ParseFloat(m[i+1], 64)
+		if err != nil {
+			return
+		}
+		*c = v
+	}
+	d.pos = p
+}
+
+// held is the state of the job where it left off.
+type held struct {
+	pos   position
+	feed  float64 // mm/min
+	fan   int
+	prime float64 // mm of filament to push out, after retracting to park
+	tool  string  // the M3 or M4 the laser was on with
+}
+
+// holdPosition remembers where the job left off, before the head is moved
+// by hand while paused or in hacker mode. A laser is turned off meanwhile,
+// so it doesn't burn a hole where it stands.
+func (d *dripper) holdPosition() {
+	if d.hold == nil {
+		d.hold = &held{pos: d.pos, feed: d.feed, fan: d.fan, tool: d.tool}
+		if machine == "laser" && d.tool != "" {
+			d.hack_queue = append(d.hack_queue, "M5")
+		}
+	}
+}
+
+// returnToJob puts things back as they were where the job left off, once
+// it carries on.
+func (d *dripper) returnToJob() {
+	h := d.hold
+	d.hold = nil
+	if h == nil {
+		return
+	}
+	d.goBack(h)
+	// The laser comes back on once it is back where it left off.
+	if machine == "laser" && h.tool != "" {
+		d.hack_queue = append(d.hack_queue, h.tool)
+	}
+}
+
+// goBack queues the moves back to where the job left off, if the head was
+// moved since. The nozzle is lifted clear of the print first and lowered
+// onto it last, primed if the filament was retracted, and the speed and fan
+// are put back as the job had them.
+func (d *dripper) goBack(h *held) {
+	if h.pos == d.pos && h.feed == d.feed && h.fan == d.fan {
+		return
+	}
+	p := h.pos
+	d.con.Println("-- RETURN TO", p)
+	d.hack_queue = append(d.hack_queue, "G90",
+		"G1 Z"+gnum(math.Max(p.Z, d.pos.Z))+" F600",
+		"G1 X"+gnum(p.X)+" Y"+gnum(p.Y)+" F3000",
+		"G1 Z"+gnum(p.Z)+" F600")
+	if h.prime > 0 && d.temps.Hotend >= extrude_min_temp {
+		d.hack_queue = append(d.hack_queue, "M83",
+			fmt.Sprintf("G1 E%s F%d", gnum(h.prime), pause_retract_feed), "M82")
+	}
+	if isPrinter() {
+		d.hack_queue = append(d.hack_queue, "G92 E"+gnum(p.E))
+	}
+	if h.feed > 0 {
+		d.hack_queue = append(d.hack_queue, "G1 F"+gnum(h.feed))
+	}
+	if h.fan != d.fan {
+		d.hack_queue = append(d.hack_queue, fanGCode(h.fan))
+	}
+	// G90 changed the job's positioning, so put it back.
+	if d.relative_xyz {
+		d.hack_queue = append(d.hack_queue, "G91")
+	} else if d.relative_e {
+		d.hack_queue = append(d.hack_queue, "M83")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Presets for common materials, as "hotend bed" temperatures. The [presets]
+// section of the config file adds more or changes these.
+var default_presets = map[string]string{
+	"PLA":  "200 60",
+	"PETG": "235 80",
+	"ABS":  "245 100",
+}
+
+// How close to its target a heater must be to count as heated.
+const preheat_slack = 2
+
+// presetNames lists the built-in presets and those in the config file.
+func presetNames() []string {
+	var names []string
+	for name := range default_presets {
+		if findConfPreset(name) == "" {
+			names = append(names, name)
+		}
+	}
+	for name := range conf["presets"] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// findPreset returns the name of a preset and its temperatures, ignoring
+// case.
+func findPreset(name string) (string, temps, error) {
+	val := ""
+	if found := findConfPreset(name); found != "" {
+		name, val = found, conf["presets"][found]
+	} else {
+		for p, v := range default_presets {
+			if strings.EqualFold(p, name) {
+				name, val = p, v
+			}
+		}
+	}
+	if val == "" {
+		return "", temps{}, fmt.Errorf("no preset named %q", name)
+	}
+	var t temps
+	f := strings.Fields(val)
+	if len(f) != 2 {
+		return "", t, fmt.Errorf("preset %s: want hotend and bed temperatures", name)
+	}
+	var err1, err2 error
+	t.HotendTarget, err1 = strconv.ParseFloat(f[0], 64)
+	t.BedTarget, err2 = strconv.ParseFloat(f[1], 64)
+	if err1 != nil || err2 != nil {
+		return "", t, fmt.Errorf("preset %s: bad temperature in %q", name, val)
+	}
+	return name, t, nil
+}
+
+func findConfPreset(name string) string {
+	for p := range conf["presets"] {
+		if strings.EqualFold(p, name) {
+			return p
+		}
+	}
+	return ""
+}
+
+// preheat sets the hotend and bed to a preset's temperatures without
+// waiting, and says so once both have got there.
+func (d *dripper) preheat(name string) (string, error) {
+	if !isPrinter() {
+		return "", errNotPrinter
+	}
+	name, t, err := findPreset(name)
+	if err != nil {
+		return "", err
+	}
+	d.hack_queue = append(d.hack_queue,
+		fmt.Sprintf("M104 S%g", t.HotendTarget),
+		fmt.Sprintf("M140 S%g", t.BedTarget))
+	d.con.Printf("-- PREHEAT %s: hotend %g, bed %g\n", name, t.HotendTarget,
+		t.BedTarget)
+	d.heating, d.heat_to = name, t
+	// Without the daemon's polling, ask for the temperatures until heated.
+	if d.temp_tick == nil {
+		d.heat_ticker = time.NewTicker(2 * time.Second)
+		d.temp_tick = d.heat_ticker.C
+	}
+	return "preheating " + name, nil
+}
+
+// checkPreheat reports when the preheat in progress, if any, has reached
+// its temperatures.
+func (d *dripper) checkPreheat() {
+	if d.heating == "" {
+		return
+	}
+	t := d.temps
+	if t.Hotend < d.heat_to.HotendTarget-preheat_slack ||
+		t.Bed < d.heat_to.BedTarget-preheat_slack {
+		return
+	}
+	d.con.Printf("-- PREHEATED %s: %s\n", d.heating, t)
+	d.stopPreheat()
+}
+
+func (d *dripper) stopPreheat() {
+	d.heating = ""
+	if d.heat_ticker != nil {
+		d.heat_ticker.Stop()
+		d.heat_ticker, d.temp_tick = nil, nil
+	}
+}
+
+// cooldown turns off the heaters and the fan, e.g. after cancelling a job,
+// and carries on.
+func (d *dripper) cooldown() string {
+	d.con.Println("-- COOLDOWN")
+	d.stopPreheat()
+	d.hack_queue = append(d.hack_queue, "M104 S0", "M140 S0", "M107")
+	return "cooling down"
+}
+
+// preheatMenu asks which preset to heat to. It returns "" if none was
+// chosen.
+func preheatMenu(userin <-chan string) (string, error) {
+	names := presetNames()
+	fmt.Println("-- PRESETS")
+	name, err := pickMenu(userin, names, "preset> ")
+	if err != nil || name == "" {
+		return "", err
+	}
+	if _, _, err := findPreset(name); err != nil {
+		fmt.Println(err)
+		return "", nil
+	}
+	return name, nil
+}
+
+// pickMenu lists names and asks for one, by number or name. It returns the
+// answer, or "" if nothing was entered.
+func pickMenu(userin <-chan string, names []string, prompt string) (string, error) {
+	for i, name := range names {
+		fmt.Printf("%d) %s\n", i+1, name)
+	}
+	fmt.Println("Enter a number or name, or nothing to go back:")
+	setPrompt(prompt)
+	ans, ok := <-userin
+	setPrompt("")
+	if !ok {
+		return "", errors.New("cannot read from stdin")
+	}
+	if i, err := strconv.Atoi(ans); err == nil && i >= 1 && i <= len(names) {
+		return names[i-1], nil
+	}
+	return ans, nil
+}
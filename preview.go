@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// segment is an extruding move, from (x0, y0) to (x1, y1).
+type segment struct {
+	x0, y0, x1, y1 float64
+}
+
+// layerPaths is what a layer of a GCode file extrudes.
+type layerPaths struct {
+	n    int
+	z    float64
+	segs []segment
+}
+
+// readLayers follows a GCode file's moves, layer by layer, up to the most
+// layers asked for unless that is 0. Layers are counted by the slicer's
+// comments as when printing, or else by the height of the extruding moves.
+func readLayers(path string, most int) ([]*layerPaths, error) {
+	f, err := openGCode(path)
+	if err != nil {
+		return nil, err
+	}
+	lines, errc := gcodeLines(f)
+	est := newEstimator()
+	var layers []*layerPaths
+	var cur *layerPaths
+	commented := false
+	newLayer := func(n int) {
+		cur = &layerPaths{n: n, z: est.pos.Z}
+		layers = append(layers, cur)
+	}
+	for ln := range lines {
+		if most > 0 && len(layers) > most {
+			layers = layers[:most]
+			drainLines(lines)
+			return layers, nil
+		}
+		if arg, ok := bytes.CutPrefix(ln, []byte("@layer")); ok {
+			n := len(layers) + 1
+			// Slicers count layers from 0.
+			if i, err := strconv.Atoi(string(bytes.TrimSpace(arg))); err == nil {
+				n = i + 1
+			}
+			commented = true
+			newLayer(n)
+			continue
+		}
+		from := est.pos
+		est.took(ln)
+		to := est.pos
+		if to.E <= from.E || to.X == from.X && to.Y == from.Y {
+			continue
+		}
+		switch {
+		case cur == nil:
+			newLayer(1)
+		case !commented && to.Z > cur.z+0.001:
+			newLayer(cur.n + 1)
+		}
+		if len(cur.segs) == 0 {
+			cur.z = to.Z
+		}
+		cur.segs = append(cur.segs, segment{from.X, from.Y, to.X, to.Y})
+	}
+	select {
+	case err := <-errc:
+		return nil, err
+	default:
+	}
+	return layers, nil
+}
+
+// bounds returns the extent of the segments.
+func bounds(segs []segment) (x0, y0, x1, y1 float64) {
+	x0, y0 = math.Inf(1), math.Inf(1)
+	x1, y1 = math.Inf(-1), math.Inf(-1)
+	for _, s := range segs {
+		x0 = math.Min(x0, math.Min(s.x0, s.x1))
+		y0 = math.Min(y0, math.Min(s.y0, s.y1))
+		x1 = math.Max(x1, math.Max(s.x0, s.x1))
+		y1 = math.Max(y1, math.Max(s.y0, s.y1))
+	}
+	return
+}
+
+// canvas is a picture drawn in the terminal, in braille dots two wide and
+// four high to a character, or in ASCII one to a character. Each dot is a
+// square of so many mm, with the back of the bed (high Y) at the top.
+type canvas struct {
+	ascii  bool
+	x0, y1 float64 // the top left corner, in mm
+	mm     float64 // mm per dot across
+	w, h   int     // in dots
+	dots   [][]byte
+}
+
+// newCanvas makes a canvas showing the area from (x0, y0) to (x1, y1), as
+// big as fits in cols by rows characters.
+func newCanvas(x0, y0, x1, y1 float64, cols, rows int, ascii bool) *canvas {
+	dx, dy := 2, 4
+	if ascii {
+		// Characters are about twice as high as they are wide.
+		dx, dy = 1, 1
+	}
+	c := &canvas{ascii: ascii, x0: x0, y1: y1}
+	w, h := math.Max(x1-x0, 1), math.Max(y1-y0, 1)
+	c.mm = math.Max(w/float64(cols*dx), h/float64(rows*dy)/dyAspect(ascii))
+	c.w = int(math.Ceil(w/c.mm)) + 1
+	c.h = int(math.Ceil(h/c.mm/dyAspect(ascii))) + 1
+	c.dots = make([][]byte, c.h)
+	for i := range c.dots {
+		c.dots[i] = make([]byte, c.w)
+	}
+	return c
+}
+
+// dyAspect is how many dots across make up the height of a dot.
+func dyAspect(ascii bool) float64 {
+	if ascii {
+		return 2
+	}
+	return 1
+}
+
+// dot returns where a point falls on the canvas.
+func (c *canvas) dot(x, y float64) (int, int) {
+	return int(math.Round((x - c.x0) / c.mm)),
+		int(math.Round((c.y1 - y) / c.mm / dyAspect(c.ascii)))
+}
+
+// plot marks the dot at a point with the mark, unless it has a mark drawn
+// over it.
+func (c *canvas) plot(x, y float64, mark byte) {
+	i, j := c.dot(x, y)
+	if i >= 0 && j >= 0 && i < c.w && j < c.h && c.dots[j][i] < mark {
+		c.dots[j][i] = mark
+	}
+}
+
+// line draws a line from (x0, y0) to (x1, y1).
+func (c *canvas) line(x0, y0, x1, y1 float64, mark byte) {
+	c.trace(x0, y0, x1, y1, func(x, y float64) byte { return mark })
+}
+
+// trace draws a line from (x0, y0) to (x1, y1), marking each point of it as
+// mark says.
+func (c *canvas) trace(x0, y0, x1, y1 float64, mark func(x, y float64) byte) {
+	steps := math.Ceil(math.Max(math.Abs(x1-x0), math.Abs(y1-y0)) / c.mm * 2)
+	for i := 0.0; i <= steps; i++ {
+		t := 0.0
+		if steps > 0 {
+			t = i / steps
+		}
+		x, y := x0+(x1-x0)*t, y0+(y1-y0)*t
+		c.plot(x, y, mark(x, y))
+	}
+}
+
+// rect draws the outline of a rectangle.
+func (c *canvas) rect(x0, y0, x1, y1 float64, mark byte) {
+	c.line(x0, y0, x1, y0, mark)
+	c.line(x1, y0, x1, y1, mark)
+	c.line(x1, y1, x0, y1, mark)
+	c.line(x0, y1, x0, y0, mark)
+}
+
+// The marks on a canvas, each drawn over the ones before it.
+const (
+	mark_edge = iota + 1
+	mark_path
+	mark_warn
+)
+
+// The characters for the marks in ASCII.
+var ascii_marks = [...]byte{' ', '.', '#', '!'}
+
+// String draws the canvas. In braille, a character is marked with a warning
+// if any of its dots are.
+func (c *canvas) String() string {
+	var sb strings.Builder
+	if c.ascii {
+		for _, row := range c.dots {
+			for _, m := range row {
+				sb.WriteByte(ascii_marks[m])
+			}
+			sb.WriteByte('\n')
+		}
+		return sb.String()
+	}
+	// The bit of each dot in a braille character, by row and column.
+	bits := [4][2]rune{{0x01, 0x08}, {0x02, 0x10}, {0x04, 0x20}, {0x40, 0x80}}
+	for j := 0; j < c.h; j += 4 {
+		for i := 0; i < c.w; i += 2 {
+			r, warn := rune(0x2800), false
+			for y := 0; y < 4 && j+y < c.h; y++ {
+				for x := 0; x < 2 && i+x < c.w; x++ {
+					if m := c.dots[j+y][i+x]; m != 0 {
+						r |= bits[y][x]
+						warn = warn || m == mark_warn
+					}
+				}
+			}
+			if warn {
+				sb.WriteString("\x1b[31m" + string(r) + "\x1b[0m")
+			} else {
+				sb.WriteRune(r)
+			}
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// The size a picture is drawn at when stdout isn't a terminal.
+const (
+	preview_cols = 80
+	preview_rows = 40
+)
+
+// pictureSize returns how many characters a picture may take up.
+func pictureSize() (int, int) {
+	cols, rows, err := termSize(os.Stdout)
+	if err != nil {
+		return preview_cols, preview_rows
+	}
+	// Leave room for the lines around it.
+	return cols - 1, rows - 4
+}
+
+// previewMain draws a layer of a GCode file in the terminal, to see where
+// the part is and what it looks like before printing it.
+func previewMain(args []string) {
+	flags := flag.NewFlagSet("preview", flag.ExitOnError)
+	layer := flags.Int("layer", 1, "draw layer `n`, counting from 1")
+	ascii := flags.Bool("ascii", false, "draw in ASCII rather than braille")
+	on_bed := flags.Bool("bed", false, "draw where the whole part is on the bed")
+	flags.StringVar(&config_path, "config", config_path,
+		"read settings from the config file at `path`")
+	flags.Usage = usage
+	flags.Parse(args)
+	// The flags may come after the file too.
+	path, rest := flags.Arg(0), flags.Args()
+	if len(rest) > 0 {
+		flags.Parse(rest[1:])
+	}
+	if path == "" || flags.NArg() > 0 {
+		usage()
+	}
+	if err := readConfig(); err != nil {
+		die(exitUsage, err)
+	}
+	layers, err := readLayers(path, 0)
+	if err != nil {
+		die(exitFailed, err)
+	}
+	if len(layers) == 0 {
+		die(exitFailed, errors.New(path+": nothing is extruded"))
+	}
+	if *on_bed {
+		previewBed(layers, *ascii)
+		return
+	}
+	var l *layerPaths
+	for _, l = range layers {
+		if l.n == *layer {
+			break
+		}
+	}
+	if l.n != *layer {
+		die(exitUsage, fmt.Errorf("%s: no layer %d, there are layers %d to %d",
+			path, *layer, layers[0].n, layers[len(layers)-1].n))
+	}
+	if len(l.segs) == 0 {
+		die(exitFailed, fmt.Errorf("%s: layer %d extrudes nothing", path, l.n))
+	}
+	x0, y0, x1, y1 := bounds(l.segs)
+	cols, rows := pictureSize()
+	c := newCanvas(x0, y0, x1, y1, cols, rows, *ascii)
+	for _, s := range l.segs {
+		c.line(s.x0, s.y0, s.x1, s.y1, mark_path)
+	}
+	fmt.Printf("Layer %d of %d at Z%.2f: X%.1f to %.1f, Y%.1f to %.1f\n",
+		l.n, layers[len(layers)-1].n, l.z, x0, x1, y0, y1)
+	fmt.Print(c)
+}
+
+// previewBed draws the box the part takes up on the bed, seen from above, to
+// catch a part that is off center or too big for the bed before printing it.
+// It exits with status 1 if the part goes off the bed or into a keep-out
+// zone.
+func previewBed(layers []*layerPaths, ascii bool) {
+	bed, err := (&dripper{}).bedArea()
+	if err != nil {
+		die(exitUsage, err)
+	}
+	var segs []segment
+	for _, l := range layers {
+		segs = append(segs, l.segs...)
+	}
+	x0, y0, x1, y1 := bounds(segs)
+	cols, rows := pictureSize()
+	c := bed.draw(x0, y0, x1, y1, cols, rows, ascii)
+	off := false
+	mark := func(x, y float64) byte {
+		if bed.allows(x, y) {
+			return mark_path
+		}
+		off = true
+		return mark_warn
+	}
+	c.trace(x0, y0, x1, y0, mark)
+	c.trace(x1, y0, x1, y1, mark)
+	c.trace(x1, y1, x0, y1, mark)
+	c.trace(x0, y1, x0, y0, mark)
+	// Only the box's edges are drawn, but a keep-out zone may be inside it.
+	for _, s := range segs {
+		if !bed.allows(s.x0, s.y0) || !bed.allows(s.x1, s.y1) {
+			off = true
+			break
+		}
+	}
+	fmt.Printf("Part %.1f x %.1f mm: X%.1f to %.1f, Y%.1f to %.1f, "+
+		"%+.1f %+.1f from the center of the %g x %g bed\n", x1-x0, y1-y0,
+		x0, x1, y0, y1, (x0+x1-bed.x)/2, (y0+y1-bed.y)/2, bed.x, bed.y)
+	fmt.Print(c)
+	if off {
+		die(exitFailed, errors.New("the part goes off the bed or into a keep-out zone"))
+	}
+}
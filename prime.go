@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Set by -prime: draw a prime line before each job.
+var prime bool
+
+// The prime line goes along the front of the bed and back, prime_margin mm
+// from its edges. Filament is pushed prime_e mm per mm of line, about right
+// for a 0.4mm nozzle at a 0.3mm layer height.
+const (
+	prime_bed    = 220
+	prime_margin = 10
+	prime_e      = 0.05
+)
+
+// The GCode for the prime line, unless the [prime] section of the config file
+// gives its own. The {names} are filled in from the config file.
+const prime_gcode = `M140 S{bed_temp}
+M104 S{temp}
+M190 S{bed_temp}
+M109 S{temp}
+M82
+G92 E0
+G1 Z2 F3000
+G1 X{x_start} Y{y} Z0.3 F5000
+G1 X{x_end} Y{y} E{e1} F1500
+G1 X{x_end} Y{y2} F5000
+G1 X{x_start} Y{y2} E{e2} F1500
+G92 E0
+G1 Z2 F3000`
+
+// primeLine queues a prime line ahead of the job's own start code, so small
+// parts need no skirt. The bed size and temperatures come from the [prime]
+// section of the config file; the temperatures default to the PLA preset.
+func (d *dripper) primeLine() {
+	if !prime {
+		return
+	}
+	lines, err := primeGCode()
+	if err != nil {
+		d.con.Println("-- PRIME:", err)
+		return
+	}
+	d.con.Println("-- PRIME LINE")
+	// After -level the printer is homed already, and homing again would
+	// turn leveling off on some firmware.
+	if !level_bed {
+		d.hack_queue = append(d.hack_queue, "G28")
+	}
+	d.hack_queue = append(d.hack_queue, lines...)
+}
+
+func primeGCode() ([]string, error) {
+	_, pla, err := findPreset("PLA")
+	if err != nil {
+		return nil, err
+	}
+	get := func(key string, def float64) float64 {
+		v, e := confFloat("prime", key, def)
+		if err == nil {
+			err = e
+		}
+		return v
+	}
+	bed_x, bed_y := get("bed_x", prime_bed), get("bed_y", prime_bed)
+	temp, bed_temp := get("temp", pla.HotendTarget), get("bed_temp", pla.BedTarget)
+	if err != nil {
+		return nil, err
+	}
+	length := bed_x - 2*prime_margin
+	if length <= 0 || bed_y <= prime_margin {
+		return nil, fmt.Errorf("bed too small: %gx%g", bed_x, bed_y)
+	}
+	num := func(f float64) string { return fmt.Sprintf("%.4g", f) }
+	fill := strings.NewReplacer(
+		"{bed_x}", num(bed_x),
+		"{bed_y}", num(bed_y),
+		"{temp}", num(temp),
+		"{bed_temp}", num(bed_temp),
+		"{x_start}", num(prime_margin),
+		"{x_end}", num(bed_x-prime_margin),
+		"{y}", num(prime_margin/2),
+		"{y2}", num(prime_margin/2+0.4),
+		"{e1}", num(length*prime_e),
+		"{e2}", num(2*length*prime_e),
+	)
+	gcode := conf.get("prime", "gcode")
+	if gcode == "" {
+		gcode = prime_gcode
+	}
+	var lines []string
+	for _, ln := range strings.Split(fill.Replace(gcode), "\n") {
+		ln, _, _ = strings.Cut(ln, ";")
+		if ln = strings.TrimSpace(ln); ln != "" {
+			lines = append(lines, ln)
+		}
+	}
+	return lines, nil
+}
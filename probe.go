@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// The probe passes the repeatability test if the standard deviation of its
+// readings is at most this, in mm, unless the config file says otherwise.
+const probe_max_deviation = 0.01
+
+// Match the summary M48 prints when it has finished probing.
+var (
+	probe_mean_re  = regexp.MustCompile(`Mean:\s*(-?[\d.]+)`)
+	probe_range_re = regexp.MustCompile(`Range:\s*(-?[\d.]+)`)
+	probe_dev_re   = regexp.MustCompile(`Standard Deviation:\s*(-?[\d.]+)`)
+)
+
+// probeResult is the outcome of a probe repeatability test (M48), in mm.
+type probeResult struct {
+	Mean         float64 `json:"mean"`
+	Deviation    float64 `json:"deviation"`
+	Range        float64 `json:"range"`
+	MaxDeviation float64 `json:"max_deviation"`
+	OK           bool    `json:"ok"`
+}
+
+func (r probeResult) String() string {
+	verdict := "OK"
+	if !r.OK {
+		verdict = "TOO HIGH"
+	}
+	return fmt.Sprintf("deviation %.4f, range %.4f, mean %.4f: %s (at most %g)",
+		r.Deviation, r.Range, r.Mean, verdict, r.MaxDeviation)
+}
+
+// parseProbeTest reads the results from the response to M48.
+func parseProbeTest(resp []string) (probeResult, error) {
+	var r probeResult
+	found := 0
+	scan := func(re *regexp.Regexp, ln string, v *float64) {
+		m := re.FindStringSubmatch(ln)
+		if m == nil {
+			return
+		}
+		if f, err := strconv.ParseFloat(m[1], 64); err == nil {
+			*v = f
+			found++
+		}
+	}
+	for _, ln := range resp {
+		scan(probe_mean_re, ln, &r.Mean)
+		scan(probe_range_re, ln, &r.Range)
+		scan(probe_dev_re, ln, &r.Deviation)
+	}
+	if found < 3 {
+		// e.g. "echo:Home XYZ first" or "Error:Probing failed"
+		if len(resp) > 0 {
+			return r, fmt.Errorf("no result from M48: %s", resp[len(resp)-1])
+		}
+		return r, errors.New("no result from M48")
+	}
+	max, err := confFloat("probe", "max_deviation", probe_max_deviation)
+	if err != nil {
+		return r, err
+	}
+	r.MaxDeviation = max
+	r.OK = r.Deviation <= max
+	return r, nil
+}
+
+// probeTested shows the result of M48 and hands it to the "probe-test" calls
+// waiting for it.
+func (d *dripper) probeTested(resp []string) {
+	r, err := parseProbeTest(resp)
+	if err != nil {
+		d.con.Println("-- PROBE TEST FAILED:", err)
+	} else {
+		d.con.Println("-- PROBE TEST", r)
+	}
+	d.reply("M48", r, err)
+}
+
+// ctlProbeTest runs "dripp3r probe-test": it homes, probes the same point
+// several times with M48 and exits with status 1 if the readings are spread
+// further than the [probe] max_deviation in the daemon's config file.
+func ctlProbeTest(sock, printer string) error {
+	var r probeResult
+	err := ctlCall(sock, "probe-test", ctlParams{Printer: printer}, &r)
+	if err != nil {
+		return err
+	}
+	fmt.Println(r)
+	if !r.OK {
+		return errors.New("the probe is not repeatable enough")
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jobTotals is what a pass over a job's file ahead of printing it counts,
+// so that progress goes by the lines and layers printed rather than bytes
+// read, which comments and thumbnails skew.
+type jobTotals struct {
+	lines  int // lines for the printer, leaving out comments and host commands
+	layers int
+	took   time.Duration // how long the printer takes over them, by the estimate
+}
+
+// scanJob counts the lines and layers of a job's file the way the loop
+// comes across them.
+func scanJob(path string) (jobTotals, error) {
+	var t jobTotals
+	est := newEstimator()
+	f, err := openGCode(path)
+	if err != nil {
+		return t, err
+	}
+	lines, errc := gcodeLines(f)
+	for ln := range lines {
+		if ln[0] != '@' {
+			t.lines++
+			t.took += est.took(ln)
+			continue
+		}
+		cmd, arg, _ := strings.Cut(string(ln[1:]), " ")
+		if cmd != "layer" {
+			continue
+		}
+		// Slicers count layers from 0.
+		n, err := strconv.Atoi(strings.TrimSpace(arg))
+		if err == nil {
+			n++
+		} else {
+			n = t.layers + 1
+		}
+		if n > t.layers {
+			t.layers = n
+		}
+	}
+	select {
+	case err := <-errc:
+		return t, err
+	default:
+	}
+	return t, nil
+}
+
+// jobScan is what scanJob made of a job's file.
+type jobScan struct {
+	totals jobTotals
+	err    error
+}
+
+// scanTotals starts counting the lines and layers of the job about to
+// start. A large file takes a while, so it is read on a goroutine of its
+// own, and the job's progress isn't known until the loop hears back on
+// d.scan. A file piped in can't be read twice, so its progress isn't known
+// at all.
+func (d *dripper) scanTotals() {
+	d.totals = jobTotals{}
+	d.est, d.est_done, d.est_heat = newEstimator(), 0, 0
+	d.printing_at = time.Time{}
+	d.scan = nil
+	if d.job_name == "" || d.job_name == "-" {
+		return
+	}
+	// Left buffered, so a scan the loop no longer waits for can finish.
+	scan := make(chan jobScan, 1)
+	go func(path string) {
+		t, err := scanJob(path)
+		scan <- jobScan{t, err}
+	}(d.job_name)
+	d.scan = scan
+}
+
+// scanned takes the totals of the job's file, once scanning it is done.
+func (d *dripper) scanned(s jobScan) {
+	d.scan = nil
+	if d.job_name == "" {
+		return
+	}
+	if s.err != nil {
+		d.con.Println("-- SCAN:", s.err)
+		return
+	}
+	t := s.totals
+	d.totals = t
+	if t.layers > 0 {
+		d.con.Printf("-- %d lines, %d layers, about %s\n", t.lines, t.layers,
+			clock(t.took))
+	} else {
+		d.con.Printf("-- %d lines, about %s\n", t.lines, clock(t.took))
+	}
+}
+
+// progress returns how far through the job the lines sent have got, from 0
+// to 1, if the job's length is known.
+func (d *dripper) progress() (float64, bool) {
+	if d.totals.lines == 0 || d.job_name == "" {
+		return 0, false
+	}
+	// -parallel-heat may add a line or two.
+	if d.sent >= d.totals.lines {
+		return 1, true
+	}
+	return float64(d.sent) / float64(d.totals.lines), true
+}
+
+// counted follows a line of the job's file on its way to the printer.
+func (d *dripper) counted(line []byte) {
+	if d.sent == 0 {
+		d.printing_at = time.Now()
+	}
+	d.sent++
+	if d.est != nil {
+		d.est_done += d.est.took(line)
+	}
+}
+
+// How much of the job the estimate has to have covered before the time
+// left goes by how the printer has kept up with it, rather than the
+// estimate alone.
+const pace_after = time.Minute
+
+// left returns how long the job has left: what the estimate has left of
+// it, slowed or sped up by how long the printer has actually taken over
+// the lines so far, leaving out waiting for the heaters.
+func (d *dripper) left() (time.Duration, bool) {
+	if d.totals.took == 0 || d.printing_at.IsZero() {
+		return 0, false
+	}
+	to_go := d.totals.took - d.est_done
+	if to_go < 0 {
+		return 0, true
+	}
+	if d.est_done >= pace_after {
+		took := time.Since(d.printing_at) - d.est_heat
+		if d.heat != nil {
+			took -= time.Since(d.heat.started)
+		}
+		if took > 0 {
+			to_go = time.Duration(float64(to_go) * float64(took) / float64(d.est_done))
+		}
+	}
+	return to_go, true
+}
+
+// clock shows a duration in hours and minutes, e.g. "1h03m", or in
+// seconds under a minute.
+func clock(t time.Duration) string {
+	t = t.Round(time.Second)
+	switch {
+	case t < time.Minute:
+		return fmt.Sprintf("%ds", int(t.Seconds()))
+	case t < time.Hour:
+		return fmt.Sprintf("%dm", int(t.Minutes()))
+	}
+	return fmt.Sprintf("%dh%02dm", int(t.Hours()), int(t.Minutes())%60)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// prusaLink serves the part of PrusaLink's v1 API that PrusaSlicer's
+// "PrusaLink" physical printer uses, and a little more, for one of the
+// daemon's printers: the version, the status, uploading files, and pausing,
+// resuming and stopping the job.
+type prusaLink struct {
+	*httpAPI
+	d *dripper
+}
+
+// Set by the daemon's -prusalink flag: [printer=]address to serve the
+// PrusaLink API on.
+var prusalink_addr string
+
+// servePrusaLink serves the PrusaLink API on -prusalink's address. Files are
+// uploaded to the [http] uploads directory, as with -http.
+func servePrusaLink(f *farm) error {
+	name, addr, ok := strings.Cut(prusalink_addr, "=")
+	if !ok {
+		name, addr = "", prusalink_addr
+	}
+	d, err := f.lookup(name)
+	if err != nil {
+		return fmt.Errorf("-prusalink: %w", err)
+	}
+	h, err := newHTTPAPI(f, "-prusalink")
+	if err != nil {
+		return err
+	}
+	l, err := listenAPI("-prusalink", addr)
+	if err != nil {
+		return err
+	}
+	p := &prusaLink{h, d}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/version", p.auth(p.serveVersion))
+	mux.HandleFunc("/api/v1/status", p.auth(p.serveStatus))
+	mux.HandleFunc("/api/v1/storage", p.auth(p.serveStorage))
+	mux.HandleFunc("/api/v1/job", p.auth(p.serveJob))
+	mux.HandleFunc("/api/v1/job/", p.auth(p.serveJob))
+	mux.HandleFunc("/api/v1/files/", p.auth(p.serveFiles))
+	log.Print("PrusaLink API on ", l.Addr())
+	serveAPI(l, mux)
+	return nil
+}
+
+// auth answers requests without the key with 401, as PrusaLink does, and
+// those that would change anything with a monitor's key with 403.
+func (p *prusaLink) auth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		k, ok := requestKey(r)
+		if !ok {
+			httpError(w, http.StatusUnauthorized, "wrong or missing X-Api-Key")
+			return
+		}
+		if !k.control && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			httpError(w, http.StatusForbidden, k.refusal())
+			return
+		}
+		h(w, r)
+	}
+}
+
+// serveVersion says this is PrusaLink, which PrusaSlicer checks, and that
+// files can be uploaded with PUT.
+func (p *prusaLink) serveVersion(w http.ResponseWriter, r *http.Request) {
+	host, _ := os.Hostname()
+	httpReply(w, map[string]interface{}{
+		"api":          "2.0.0",
+		"server":       "dripp3r",
+		"text":         "PrusaLink dripp3r",
+		"hostname":     host,
+		"capabilities": map[string]bool{"upload-by-put": true},
+	})
+}
+
+func (p *prusaLink) status() (ctlStatus, error) {
+	rep := p.d.call(rpcRequest{Method: "status"})
+	if rep.err != nil {
+		return ctlStatus{}, rep.err
+	}
+	return rep.result.(ctlStatus), nil
+}
+
+// plState is PrusaLink's name for the printer's state.
+func plState(st ctlStatus) string {
+	switch st.State {
+	case "printing", "pausing":
+		return "PRINTING"
+	case "paused":
+		return "PAUSED"
+	case "stopping":
+		return "BUSY"
+	case "halted":
+		return "ERROR"
+	}
+	return "IDLE"
+}
+
+// plJob is the job as PrusaLink describes it.
+func plJob(st ctlStatus) map[string]interface{} {
+	name := filepath.Base(st.Job)
+	job := map[string]interface{}{
+		"id":       st.JobID,
+		"state":    plState(st),
+		"progress": st.Progress * 100,
+		"file": map[string]interface{}{
+			"name":         name,
+			"path":         st.Job,
+			"display_name": name,
+		},
+	}
+	if st.Left > 0 {
+		job["time_remaining"] = st.Left
+	}
+	return job
+}
+
+func (p *prusaLink) serveStatus(w http.ResponseWriter, r *http.Request) {
+	st, err := p.status()
+	if err != nil {
+		httpError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	t := st.Temps
+	res := map[string]interface{}{
+		"printer": map[string]interface{}{
+			"state":         plState(st),
+			"temp_nozzle":   t.Hotend,
+			"target_nozzle": t.HotendTarget,
+			"temp_bed":      t.Bed,
+			"target_bed":    t.BedTarget,
+			"axis_x":        st.Position.X,
+			"axis_y":        st.Position.Y,
+			"axis_z":        st.Position.Z,
+			"speed":         st.Feedrate,
+			"flow":          st.Flow,
+			"fan_print":     st.Fan,
+		},
+		"storage": map[string]interface{}{
+			"path": "/local/", "name": "local", "read_only": false,
+		},
+	}
+	if st.Job != "" {
+		job := plJob(st)
+		delete(job, "file")
+		delete(job, "state")
+		res["job"] = job
+	}
+	httpReply(w, res)
+}
+
+// serveStorage lists the one storage there is: the uploads directory.
+func (p *prusaLink) serveStorage(w http.ResponseWriter, r *http.Request) {
+	httpReply(w, map[string]interface{}{
+		"storage_list": []map[string]interface{}{{
+			"type": "LOCAL", "path": "/local", "name": "local",
+			"available": true, "read_only": false,
+		}},
+	})
+}
+
+// serveJob answers GET /api/v1/job with the job, or 204 when there is none,
+// and pauses, resumes and stops it: PUT /api/v1/job/{id}/pause and
+// /resume, and DELETE /api/v1/job/{id}.
+func (p *prusaLink) serveJob(w http.ResponseWriter, r *http.Request) {
+	st, err := p.status()
+	if err != nil {
+		httpError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	rest := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/v1/job"), "/")
+	if rest == "" {
+		if r.Method != http.MethodGet {
+			httpError(w, http.StatusMethodNotAllowed, "GET the job")
+			return
+		}
+		if st.Job == "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		httpReply(w, plJob(st))
+		return
+	}
+	id, action, _ := strings.Cut(rest, "/")
+	if n, err := strconv.Atoi(id); err != nil || st.Job == "" || n != st.JobID {
+		httpError(w, http.StatusNotFound, "no job "+id)
+		return
+	}
+	var method string
+	switch {
+	case r.Method == http.MethodPut && action == "pause":
+		method = "pause"
+	case r.Method == http.MethodPut && action == "resume":
+		method = "resume"
+	case r.Method == http.MethodDelete && action == "":
+		method = "cancel"
+	default:
+		httpError(w, http.StatusMethodNotAllowed, "PUT pause or resume, or DELETE the job")
+		return
+	}
+	if err := requestClient(r, "PrusaLink").admit(p.d, method); err != nil {
+		controlError(w, err)
+		return
+	}
+	if rep := p.d.call(rpcRequest{Method: method}); rep.err != nil {
+		controlError(w, rep.err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveFiles takes a file PUT to /api/v1/files/local/{path}, as PrusaSlicer
+// uploads it, and prints it if the Print-After-Upload header says to. A file
+// that is there already is only replaced if the Overwrite header says to.
+func (p *prusaLink) serveFiles(w http.ResponseWriter, r *http.Request) {
+	storage, name, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v1/files/"), "/")
+	if storage != "local" && storage != "usb" {
+		httpError(w, http.StatusNotFound, "no storage named "+storage)
+		return
+	}
+	if r.Method != http.MethodPut {
+		w.Header().Set("Allow", http.MethodPut)
+		httpError(w, http.StatusMethodNotAllowed, "PUT a GCode file")
+		return
+	}
+	path, err := p.upload(name)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Before the file is read, so that clients turned away can't fill the
+	// disk either.
+	print_after := plTrue(r.Header.Get("Print-After-Upload"))
+	what := "upload " + path
+	if print_after {
+		what = "print " + path
+	}
+	if err := requestClient(r, "PrusaLink").admit(p.d, what); err != nil {
+		controlError(w, err)
+		return
+	}
+	p.limit(w, r)
+	if err := p.store(path, r.Body, plTrue(r.Header.Get("Overwrite"))); errors.Is(err, fs.ErrExist) {
+		httpError(w, http.StatusConflict, name+" is there already")
+		return
+	} else if err != nil {
+		saveError(w, err, http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Uploaded %s from %s", path, r.RemoteAddr)
+	if print_after {
+		rep := p.d.call(rpcRequest{Method: "print", Params: ctlParams{Path: path}})
+		if rep.err != nil {
+			controlError(w, rep.err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// plTrue reads a boolean header, ?1 in HTTP's structured fields.
+func plTrue(v string) bool {
+	switch strings.ToLower(v) {
+	case "?1", "1", "true":
+		return true
+	}
+	return false
+}
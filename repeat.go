@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Set by -repeat: how many times to print each job, or 0 to keep printing it
+// until stopped, e.g. on a belt printer or one that pushes parts off the bed.
+var repeat = 1
+
+// repeatJob starts the job that has just finished over, if -repeat asks for
+// more runs, and tells whether it did. The [repeat] between GCode goes
+// first, e.g. to wait for the bed to cool and push the part off.
+func (d *dripper) repeatJob() bool {
+	d.runs++
+	if repeat > 0 && d.runs >= repeat {
+		d.runs = 0
+		return false
+	}
+	if repeat > 0 {
+		d.con.Printf("-- REPEAT: run %d of %d\n", d.runs+1, repeat)
+	} else {
+		d.con.Printf("-- REPEAT: run %d\n", d.runs+1)
+	}
+	for _, ln := range strings.Split(conf.get("repeat", "between"), "\n") {
+		if ln = strings.TrimSpace(ln); ln != "" {
+			d.queueLine(ln)
+		}
+	}
+	if err := d.startJob(d.job_name); err != nil {
+		d.con.Println("-- ERROR:", err)
+		d.runs = 0
+		return false
+	}
+	return true
+}
+
+// checkRepeat refuses to repeat a job that can only be read once.
+func checkRepeat(path string) error {
+	switch {
+	case repeat < 0:
+		return fmt.Errorf("-repeat %d: want a number of runs, or 0 for endless", repeat)
+	case repeat != 1 && path == "-":
+		return fmt.Errorf("-repeat needs a file, not stdin")
+	}
+	return nil
+}
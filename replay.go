@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Set by replay -as-printer: the -dump-raw file whose printer is played back.
+var replay_dump string
+
+// rawEvent is a line of a -dump-raw file: bytes read from the printer (<) or
+// written to it (>), at so many seconds into the dump.
+type rawEvent struct {
+	at   float64
+	dir  byte
+	data []byte
+}
+
+// readDump reads the events of a -dump-raw file.
+func readDump(path string) ([]rawEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var events []rawEvent
+	scan := bufio.NewScanner(f)
+	scan.Buffer(nil, 1<<20)
+	for n := 1; scan.Scan(); n++ {
+		ln := scan.Text()
+		if ln == "" || strings.HasPrefix(ln, "#") {
+			continue
+		}
+		f := strings.SplitN(ln, " ", 3)
+		if len(f) != 3 || len(f[1]) != 1 || (f[1][0] != '<' && f[1][0] != '>') {
+			return nil, fmt.Errorf("%s:%d: not a line of a raw dump", path, n)
+		}
+		at, err := strconv.ParseFloat(f[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: bad time %q", path, n, f[0])
+		}
+		data, err := strconv.Unquote(f[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: bad bytes %s", path, n, f[2])
+		}
+		events = append(events, rawEvent{at, f[1][0], []byte(data)})
+	}
+	return events, scan.Err()
+}
+
+// replayPort plays the printer's side of a -dump-raw file: whatever the
+// printer sent after each write in the dump, it sends after the same write
+// now, as long after it as it did then. Writes are matched up with the dump
+// in order, and the first that differs from it is logged, since from there
+// on the printer may be answering something else.
+type replayPort struct {
+	mu      sync.Mutex
+	events  []rawEvent
+	next    int // the next event to play
+	batches chan []rawEvent
+	differs bool
+	ended   bool
+	r       *io.PipeReader
+	w       *io.PipeWriter
+}
+
+func openReplay(path string) (*replayPort, error) {
+	events, err := readDump(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &replayPort{events: events, batches: make(chan []rawEvent, 16)}
+	p.r, p.w = io.Pipe()
+	go p.play()
+	// What the printer said before the first write, e.g. its greeting.
+	p.batches <- p.reads(0)
+	return p, nil
+}
+
+// reads takes the events up to the next write off the events to play,
+// timed from the one before them at since.
+func (p *replayPort) reads(since float64) []rawEvent {
+	var batch []rawEvent
+	for p.next < len(p.events) && p.events[p.next].dir == '<' {
+		ev := p.events[p.next]
+		ev.at -= since
+		batch = append(batch, ev)
+		p.next++
+	}
+	return batch
+}
+
+// play sends the printer's bytes down the pipe, each batch as long after it
+// came as after the write it followed in the dump.
+func (p *replayPort) play() {
+	for batch := range p.batches {
+		start := time.Now()
+		for _, ev := range batch {
+			time.Sleep(time.Duration(ev.at*float64(time.Second)) - time.Since(start))
+			if _, err := p.w.Write(ev.data); err != nil {
+				return
+			}
+		}
+	}
+	p.w.CloseWithError(errors.New("the raw dump has ended"))
+}
+
+func (p *replayPort) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.next >= len(p.events) {
+		if !p.ended {
+			p.ended = true
+			close(p.batches)
+		}
+		return 0, errors.New("the raw dump has ended")
+	}
+	ev := p.events[p.next]
+	p.next++
+	if !p.differs && !bytes.Equal(ev.data, b) {
+		p.differs = true
+		log.Printf("Replay: sent %q where the dump has %q at %.6f", b,
+			ev.data, ev.at)
+	}
+	p.batches <- p.reads(ev.at)
+	return len(b), nil
+}
+
+func (p *replayPort) Read(b []byte) (int, error) {
+	return p.r.Read(b)
+}
+
+func (p *replayPort) Close() error {
+	return p.r.Close()
+}
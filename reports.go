@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// showEndstops prints the reply to M119 as a table, pointing out the
+// endstops that are triggered. The reply looks like:
+//
+//	Reporting endstop status
+//	x_min: open
+//	z_min: TRIGGERED
+func showEndstops(con *console, resp []string) {
+	con.Println("-- ENDSTOPS")
+	for _, ln := range resp {
+		name, state, ok := strings.Cut(ln, ":")
+		if !ok || strings.ContainsRune(name, ' ') {
+			continue
+		}
+		state = strings.ToLower(strings.TrimSpace(state))
+		if state == "triggered" {
+			state = "TRIGGERED <--"
+		}
+		con.Printf("   %-12s %s\n", name, state)
+	}
+}
+
+// setting is one line of the settings M503 reports, as the GCode that
+// makes it, e.g. "M92 X80.00 Y80.00 Z400.00 E93.00".
+type setting struct {
+	code   string
+	params []string
+}
+
+func (s setting) String() string {
+	return strings.Join(append([]string{s.code}, s.params...), " ")
+}
+
+type settingInfo struct {
+	group  string
+	name   string
+	labels map[byte]string // for parameters that aren't axes
+}
+
+// The groups settings are shown in, in order.
+var setting_groups = []string{
+	"Steps", "Feedrates", "Acceleration", "PID", "Offsets", "Leveling",
+	"Other",
+}
+
+var setting_info = map[string]settingInfo{
+	"M92":  {"Steps", "Steps per mm", nil},
+	"M203": {"Feedrates", "Max feedrate, mm/s", nil},
+	"M201": {"Acceleration", "Max acceleration, mm/s2", nil},
+	"M204": {"Acceleration", "Acceleration, mm/s2",
+		map[byte]string{'P': "print", 'R': "retract", 'T': "travel"}},
+	"M205": {"Acceleration", "Jerk and junction",
+		map[byte]string{'B': "min segment µs", 'S': "min feed",
+			'T': "min travel", 'J': "junction dev"}},
+	"M301": {"PID", "Hotend PID",
+		map[byte]string{'P': "Kp", 'I': "Ki", 'D': "Kd"}},
+	"M304": {"PID", "Bed PID",
+		map[byte]string{'P': "Kp", 'I': "Ki", 'D': "Kd"}},
+	"M206": {"Offsets", "Home offset", nil},
+	"M851": {"Offsets", "Probe offset", nil},
+	"M218": {"Offsets", "Tool offset", map[byte]string{'T': "tool"}},
+	"M420": {"Leveling", "Bed leveling",
+		map[byte]string{'S': "on", 'Z': "fade height"}},
+	"M900": {"Other", "Linear advance", nil},
+	"M145": {"Other", "Material preset",
+		map[byte]string{'S': "preset", 'H': "hotend", 'B': "bed", 'F': "fan"}},
+	"M200": {"Other", "Filament diameter",
+		map[byte]string{'D': "diameter", 'S': "on"}},
+	"M149": {"Other", "Temperature units", nil},
+	"G21":  {"Other", "Units in mm", nil},
+}
+
+var setting_re = regexp.MustCompile(`^[GM]\d+$`)
+
+// parseSettings picks the settings out of the reply to M503, where they are
+// mixed in with comments:
+//
+//	echo:; Steps per unit:
+//	echo:  M92 X80.00 Y80.00 Z400.00 E93.00
+func parseSettings(resp []string) []setting {
+	var settings []setting
+	for _, ln := range resp {
+		ln = strings.TrimPrefix(ln, "echo:")
+		ln, _, _ = strings.Cut(ln, ";")
+		f := strings.Fields(ln)
+		if len(f) == 0 || !setting_re.MatchString(f[0]) {
+			continue
+		}
+		settings = append(settings, setting{f[0], f[1:]})
+	}
+	return settings
+}
+
+// showSettings prints settings by group, with their values labelled.
+func showSettings(con *console, settings []setting) {
+	con.Println("-- SETTINGS")
+	for _, group := range setting_groups {
+		var lines []string
+		for _, s := range settings {
+			info, ok := setting_info[s.code]
+			if !ok {
+				info = settingInfo{group: "Other", name: s.code}
+			}
+			if info.group != group {
+				continue
+			}
+			var vals []string
+			for _, p := range s.params {
+				label := info.labels[p[0]]
+				if label == "" {
+					label = p[:1]
+				}
+				vals = append(vals, strings.TrimSpace(label+" "+p[1:]))
+			}
+			ln := fmt.Sprintf("   %-30s %s", info.name+" ("+s.code+")",
+				strings.Join(vals, "  "))
+			lines = append(lines, strings.TrimRight(ln, " "))
+		}
+		if len(lines) > 0 {
+			con.Println(group)
+			con.Println(strings.Join(lines, "\n"))
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// rrfStatus is what RepRapFirmware reports as JSON: the flat status of M408
+// S0, or the object model M409 answers with under "result".
+type rrfStatus struct {
+	Status  string    `json:"status"`
+	Heaters []float64 `json:"heaters"`
+	Active  []float64 `json:"active"`
+	Pos     []float64 `json:"pos"`
+	Result  *struct {
+		Heat *struct {
+			BedHeaters []int `json:"bedHeaters"`
+			Heaters    []struct {
+				Current float64 `json:"current"`
+				Active  float64 `json:"active"`
+			} `json:"heaters"`
+		} `json:"heat"`
+		Move *struct {
+			Axes []struct {
+				Letter       string  `json:"letter"`
+				UserPosition float64 `json:"userPosition"`
+			} `json:"axes"`
+		} `json:"move"`
+		State *struct {
+			Status string `json:"status"`
+		} `json:"state"`
+	} `json:"result"`
+}
+
+// The states M408 reports by letter, named as M409 names them.
+var rrf_states = map[string]string{
+	"A": "paused",
+	"B": "busy",
+	"C": "starting",
+	"D": "pausing",
+	"F": "updating",
+	"H": "halted",
+	"I": "idle",
+	"M": "simulating",
+	"O": "off",
+	"P": "processing",
+	"R": "resuming",
+	"S": "stopped",
+	"T": "changingTool",
+}
+
+// rrfReport picks up the temperatures, position and state from a line of
+// RepRapFirmware's JSON, and tells whether it was one.
+func (d *dripper) rrfReport(ln string) bool {
+	if !strings.HasPrefix(ln, "{") {
+		return false
+	}
+	var st rrfStatus
+	if err := json.Unmarshal([]byte(ln), &st); err != nil {
+		return false
+	}
+	if st.Result != nil {
+		d.rrfModel(&st)
+		return true
+	}
+	// M408's heater 0 is the bed and heater 1 the first hotend.
+	t := d.temps
+	if len(st.Heaters) > 1 && len(st.Active) > 1 {
+		t.Bed, t.BedTarget = st.Heaters[0], st.Active[0]
+		t.Hotend, t.HotendTarget = st.Heaters[1], st.Active[1]
+		d.setTemps(t)
+	}
+	if len(st.Pos) >= 3 {
+		d.pos.X, d.pos.Y, d.pos.Z = st.Pos[0], st.Pos[1], st.Pos[2]
+	}
+	if s, ok := rrf_states[st.Status]; ok {
+		d.fw_state = s
+	}
+	return true
+}
+
+// rrfModel picks up what there is of the same from M409's object model,
+// which only has the keys that were asked for.
+func (d *dripper) rrfModel(st *rrfStatus) {
+	r := st.Result
+	if h := r.Heat; h != nil && len(h.Heaters) > 0 {
+		bed := -1
+		if len(h.BedHeaters) > 0 {
+			bed = h.BedHeaters[0]
+		}
+		t := d.temps
+		hotend := false
+		for i, heater := range h.Heaters {
+			switch {
+			case i == bed:
+				t.Bed, t.BedTarget = heater.Current, heater.Active
+			case !hotend:
+				t.Hotend, t.HotendTarget = heater.Current, heater.Active
+				hotend = true
+			}
+		}
+		d.setTemps(t)
+	}
+	if m := r.Move; m != nil {
+		for _, a := range m.Axes {
+			if a.Letter == "" {
+				continue
+			}
+			if c := d.pos.axis(a.Letter[0]); c != nil {
+				*c = a.UserPosition
+			}
+		}
+	}
+	if r.State != nil && r.State.Status != "" {
+		d.fw_state = r.State.Status
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import "strings"
+
+// isAction tells whether a line from the printer asks something of the host
+// or tells it the printer is waiting, e.g. "//action:out_of_filament" or
+// "echo:busy: paused for user".
+func isAction(ln string) bool {
+	return strings.HasPrefix(ln, "//action:") ||
+		strings.HasPrefix(ln, "// action:") ||
+		strings.Contains(ln, "paused for user")
+}
+
+// printerAction reacts to an action line. When the filament runs out,
+// firmware that changes it by itself says "paused"; otherwise it says "pause"
+// and the filament is changed here, as with the menu's "filament" option.
+func (d *dripper) printerAction(ln string) {
+	if !isAction(ln) {
+		d.report(ln)
+		return
+	}
+	// Klipper puts a space after the slashes.
+	action := strings.TrimPrefix(strings.TrimPrefix(ln, "//"), " ")
+	action, _ = strings.CutPrefix(action, "action:")
+	action, _, _ = strings.Cut(action, " ")
+	if strings.Contains(ln, "paused for user") {
+		action = "paused"
+	}
+	switch action {
+	case "out_of_filament", "filament_runout":
+		if !isPrinter() {
+			return
+		}
+		d.runout = true
+		d.con.Println("-- FILAMENT RUNOUT")
+		d.emit(hookEvent{Event: "runout"})
+	case "pause":
+		if d.changing || d.paused {
+			return
+		}
+		if d.runout {
+			d.runout = false
+			if _, err := d.startChange(); err == nil {
+				return
+			}
+		}
+		d.con.Println("-- PAUSE (printer)")
+		d.paused = true
+		d.holdPosition()
+		d.park("pause")
+		d.emit(hookEvent{Event: "pause"})
+	case "paused":
+		if d.fw_paused {
+			return
+		}
+		d.fw_paused = true
+		if d.runout {
+			d.runout = false
+			d.con.Println("-- PAUSED BY THE PRINTER: Load the new filament " +
+				"and carry on from the printer's screen.")
+		} else {
+			d.con.Println("-- PAUSED BY THE PRINTER: Carry on from its screen.")
+		}
+		d.emit(hookEvent{Event: "pause"})
+	case "resume":
+		switch {
+		case d.changing:
+			d.changeKey("c")
+		case d.paused:
+			d.con.Println("-- RESUME (printer)")
+			d.paused = false
+			d.returnToJob()
+		}
+	case "resumed":
+		d.fw_paused = false
+		d.con.Println("-- RESUMED BY THE PRINTER")
+	}
+}
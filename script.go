@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.starlark.net/starlark"
+	"log"
+)
+
+var script_path string
+
+// How long a script handler may run before it is stopped, so a runaway
+// loop can't hold up the printer.
+const script_max_steps = 1000000
+
+// script is a Starlark script loaded with -script. It registers handlers
+// for the same events as the [hooks], which run on the drip loop and can
+// send GCode:
+//
+//	def fan_down(ev):
+//	    if ev["layer"] == 50:
+//	        gcode("M106 S204")
+//	on("layer_change", fan_down)
+type script struct {
+	d        *dripper
+	path     string
+	handlers map[string][]starlark.Callable
+}
+
+func (d *dripper) loadScript(path string) error {
+	s := &script{d: d, path: path, handlers: make(map[string][]starlark.Callable)}
+	builtins := starlark.StringDict{
+		"on":     starlark.NewBuiltin("on", s.on),
+		"gcode":  starlark.NewBuiltin("gcode", s.gcode),
+		"pause":  s.hostBuiltin("pause"),
+		"resume": s.hostBuiltin("resume"),
+		"cancel": s.hostBuiltin("cancel"),
+		"status": starlark.NewBuiltin("status", s.status),
+	}
+	if _, err := starlark.ExecFile(s.thread(), path, nil, builtins); err != nil {
+		return scriptError(err)
+	}
+	d.script = s
+	return nil
+}
+
+func (s *script) thread() *starlark.Thread {
+	t := &starlark.Thread{
+		Name: s.path,
+		Print: func(_ *starlark.Thread, msg string) {
+			s.d.con.Println(msg)
+		},
+	}
+	t.SetMaxExecutionSteps(script_max_steps)
+	return t
+}
+
+// handle calls the handlers registered for an event.
+func (s *script) handle(ev hookEvent) {
+	fns := s.handlers[ev.Event]
+	if len(fns) == 0 {
+		return
+	}
+	arg, err := toStarlark(ev)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	for _, fn := range fns {
+		_, err := starlark.Call(s.thread(), fn, starlark.Tuple{arg}, nil)
+		if err != nil {
+			log.Print(scriptError(err))
+		}
+	}
+}
+
+// on(event, fn) registers fn to be called with each event of that name.
+func (s *script) on(_ *starlark.Thread, b *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var event string
+	var fn starlark.Callable
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"event", &event, "fn", &fn); err != nil {
+		return nil, err
+	}
+	s.handlers[event] = append(s.handlers[event], fn)
+	return starlark.None, nil
+}
+
+// gcode(line, ...) sends lines ahead of the job, like hacker mode.
+func (s *script) gcode(_ *starlark.Thread, b *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if len(kwargs) > 0 {
+		return nil, fmt.Errorf("%s: unexpected keyword arguments", b.Name())
+	}
+	for _, arg := range args {
+		line, ok := starlark.AsString(arg)
+		if !ok {
+			return nil, fmt.Errorf("%s: got %s, want string", b.Name(), arg.Type())
+		}
+		s.d.queueLine(line)
+	}
+	return starlark.None, nil
+}
+
+// hostBuiltin makes a host command such as @pause callable from a script.
+func (s *script) hostBuiltin(cmd string) *starlark.Builtin {
+	return starlark.NewBuiltin(cmd, func(_ *starlark.Thread, b *starlark.Builtin,
+		args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+			return nil, err
+		}
+		s.d.hostCommand(cmd)
+		return starlark.None, nil
+	})
+}
+
+// status() returns the printer's status as a dict, as in "dripp3r status".
+func (s *script) status(_ *starlark.Thread, b *starlark.Builtin,
+	args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+	return toStarlark(s.d.status())
+}
+
+// toStarlark converts v to Starlark values by way of its JSON encoding.
+func toStarlark(v interface{}) (starlark.Value, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var x interface{}
+	json.Unmarshal(data, &x)
+	return jsonValue(x), nil
+}
+
+func jsonValue(x interface{}) starlark.Value {
+	switch x := x.(type) {
+	case map[string]interface{}:
+		dict := starlark.NewDict(len(x))
+		for k, v := range x {
+			dict.SetKey(starlark.String(k), jsonValue(v))
+		}
+		return dict
+	case []interface{}:
+		list := make([]starlark.Value, len(x))
+		for i, v := range x {
+			list[i] = jsonValue(v)
+		}
+		return starlark.NewList(list)
+	case string:
+		return starlark.String(x)
+	case float64:
+		if x == float64(int(x)) {
+			return starlark.MakeInt(int(x))
+		}
+		return starlark.Float(x)
+	case bool:
+		return starlark.Bool(x)
+	}
+	return starlark.None
+}
+
+// scriptError includes the Starlark backtrace in the error, if there is one.
+func scriptError(err error) error {
+	var eval *starlark.EvalError
+	if errors.As(err, &eval) {
+		return errors.New(eval.Backtrace())
+	}
+	return err
+}
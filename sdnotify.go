@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify tells systemd about a state change, e.g. "READY=1". It does
+// nothing unless dripp3r was started by a Type=notify unit.
+func sdNotify(state string) error {
+	sock := os.Getenv("NOTIFY_SOCKET")
+	if sock == "" {
+		return nil
+	}
+	// A leading @ means the abstract socket namespace.
+	if sock[0] == '@' {
+		sock = "\x00" + sock[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdWatchdog returns how often systemd wants to hear WATCHDOG=1, or 0 if the
+// unit has no WatchdogSec.
+func sdWatchdog() time.Duration {
+	usec, err := strconv.Atoi(os.Getenv("WATCHDOG_USEC"))
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// watchdog pings systemd for as long as every drip loop keeps answering, so
+// a wedged loop gets the daemon restarted.
+func (f *farm) watchdog(every time.Duration) {
+	for range time.Tick(every) {
+		alive := true
+		for _, d := range f.drippers {
+			req := ctlRequest{"status", ctlParams{}, make(chan ctlReply, 1)}
+			select {
+			case d.ctl_chan <- req:
+				<-req.reply
+			case <-time.After(every):
+				alive = false
+			}
+		}
+		if alive {
+			sdNotify("WATCHDOG=1")
+		}
+	}
+}
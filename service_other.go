@@ -0,0 +1,18 @@
+//go:build !windows
+
+package main
+
+import "log"
+
+func serviceMain(args []string) {
+	log.Fatal("services are only supported on Windows; for systemd see contrib/dripp3r.service")
+}
+
+func runningAsService() bool {
+	return false
+}
+
+func runService(f *farm) error {
+	f.run()
+	return nil
+}
@@ -0,0 +1,133 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+const svcName = "dripp3r"
+
+// serviceMain installs or removes the daemon as a Windows service. The
+// arguments after "install" are passed to "dripp3r daemon" when it starts.
+func serviceMain(args []string) {
+	if len(args) == 0 {
+		usage()
+	}
+	var err error
+	switch args[0] {
+	case "install":
+		if len(args) < 2 {
+			usage()
+		}
+		err = installService(args[1:])
+	case "remove":
+		err = removeService()
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func installService(args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.CreateService(svcName, exe, mgr.Config{
+		DisplayName: "dripp3r",
+		Description: "Drips GCode to 3D printers; use dripp3r attach to control it.",
+		StartType:   mgr.StartAutomatic,
+	}, append([]string{"daemon"}, args...)...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	fmt.Println("Installed service", svcName)
+	return nil
+}
+
+func removeService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(svcName)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	fmt.Println("Removed service", svcName)
+	return nil
+}
+
+func runningAsService() bool {
+	ok, err := svc.IsWindowsService()
+	return err == nil && ok
+}
+
+// runService runs the drip loops under the service control manager. Output
+// goes to dripp3r.log in ProgramData since a service has no console.
+func runService(f *farm) error {
+	dir := filepath.Join(os.Getenv("ProgramData"), "dripp3r")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	logf, err := os.OpenFile(filepath.Join(dir, "dripp3r.log"),
+		os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer logf.Close()
+	log.SetOutput(logf)
+	os.Stdout = logf
+	return svc.Run(svcName, winService{f})
+}
+
+type winService struct {
+	f *farm
+}
+
+func (s winService) Execute(args []string, reqs <-chan svc.ChangeRequest,
+	status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	done := make(chan struct{})
+	go func() {
+		s.f.run()
+		close(done)
+	}()
+	status <- svc.Status{
+		State:   svc.Running,
+		Accepts: svc.AcceptStop | svc.AcceptShutdown,
+	}
+	for {
+		select {
+		case c := <-reqs:
+			switch c.Cmd {
+			case svc.Interrogate:
+				status <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				s.f.stop()
+			}
+		case <-done:
+			return false, 0
+		}
+	}
+}
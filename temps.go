@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+type temps struct {
+	Hotend       float64 `json:"hotend"`
+	HotendTarget float64 `json:"hotend_target"`
+	Bed          float64 `json:"bed"`
+	BedTarget    float64 `json:"bed_target"`
+}
+
+func (t temps) String() string {
+	return fmt.Sprintf("T:%.1f/%.1f B:%.1f/%.1f",
+		t.Hotend, t.HotendTarget, t.Bed, t.BedTarget)
+}
+
+// setTemps takes in the temperatures the printer reported.
+func (d *dripper) setTemps(t temps) {
+	d.temps = t
+	d.bus.publish(tempsUpdated{t})
+	d.checkPreheat()
+}
+
+// Matches "T:210.3 /210.0" and "B:59.8 /60.0" in M105 replies and the
+// reports printed while heating.
+var temp_re = regexp.MustCompile(`\b([TB])\d?:\s*(-?[\d.]+)\s*/\s*(-?[\d.]+)`)
+
+// parseTemps reads the hotend and bed temperatures out of a response line.
+// The first hotend is used when there are several.
+func parseTemps(ln string) (t temps, ok bool) {
+	var seen_t, seen_b bool
+	for _, m := range temp_re.FindAllStringSubmatch(ln, -1) {
+		cur, err1 := strconv.ParseFloat(m[2], 64)
+		target, err2 := strconv.ParseFloat(m[3], 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		switch {
+		case m[1] == "T" && !seen_t:
+			t.Hotend, t.HotendTarget = cur, target
+			seen_t = true
+		case m[1] == "B" && !seen_b:
+			t.Bed, t.BedTarget = cur, target
+			seen_b = true
+		}
+	}
+	return t, seen_t || seen_b
+}
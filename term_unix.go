@@ -0,0 +1,44 @@
+//go:build !windows
+
+package main
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// termSize returns the columns and rows of the terminal f.
+func termSize(f *os.File) (int, int, error) {
+	ws, err := unix.IoctlGetWinsize(int(f.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(ws.Col), int(ws.Row), nil
+}
+
+// openTerminal opens the controlling terminal, for reading the keyboard
+// when stdin is taken.
+func openTerminal() (*os.File, error) {
+	return os.Open("/dev/tty")
+}
+
+// cbreak turns off line buffering and echo on the terminal f, so keys can be
+// read as they are pressed, and returns a func that undoes it. Ctrl-C still
+// sends SIGINT.
+func cbreak(f *os.File) (func(), error) {
+	fd := int(f.Fd())
+	old, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return nil, err
+	}
+	t := *old
+	t.Lflag &^= unix.ICANON | unix.ECHO | unix.IEXTEN
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, ioctlSetTermios, &t); err != nil {
+		return nil, err
+	}
+	return func() {
+		unix.IoctlSetTermios(fd, ioctlSetTermios, old)
+	}, nil
+}
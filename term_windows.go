@@ -0,0 +1,52 @@
+//go:build windows
+
+package main
+
+import (
+	"golang.org/x/sys/windows"
+	"os"
+)
+
+// termSize returns the columns and rows of the console window f.
+func termSize(f *os.File) (int, int, error) {
+	var info windows.ConsoleScreenBufferInfo
+	err := windows.GetConsoleScreenBufferInfo(windows.Handle(f.Fd()), &info)
+	if err != nil {
+		return 0, 0, err
+	}
+	w := info.Window
+	return int(w.Right - w.Left + 1), int(w.Bottom - w.Top + 1), nil
+}
+
+// openTerminal opens the console, for reading the keyboard when stdin is
+// taken.
+func openTerminal() (*os.File, error) {
+	return os.Open("CONIN$")
+}
+
+// cbreak turns off line buffering and echo on the console f, so keys can be
+// read as they are pressed, and returns a func that undoes it. Ctrl-C still
+// interrupts. Arrow keys and the like arrive as escape sequences, and stdout
+// is made to understand them too.
+func cbreak(f *os.File) (func(), error) {
+	in := windows.Handle(f.Fd())
+	var in_mode uint32
+	if err := windows.GetConsoleMode(in, &in_mode); err != nil {
+		return nil, err
+	}
+	raw := in_mode&^(windows.ENABLE_LINE_INPUT|windows.ENABLE_ECHO_INPUT) |
+		windows.ENABLE_VIRTUAL_TERMINAL_INPUT
+	if err := windows.SetConsoleMode(in, raw); err != nil {
+		return nil, err
+	}
+	out := windows.Handle(os.Stdout.Fd())
+	var out_mode uint32
+	if windows.GetConsoleMode(out, &out_mode) == nil {
+		windows.SetConsoleMode(out,
+			out_mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING)
+	}
+	return func() {
+		windows.SetConsoleMode(in, in_mode)
+		windows.SetConsoleMode(out, out_mode)
+	}, nil
+}
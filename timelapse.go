@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Set by -timelapse: the directory each job's timelapse frames are saved
+// under, in a directory of their own named for the job and when it started.
+var timelapse_dir string
+
+// checkTimelapse makes sure there is a way to take the frames.
+func checkTimelapse() error {
+	if timelapse_dir != "" && conf.get("timelapse", "url") == "" &&
+		conf.get("timelapse", "command") == "" {
+		return errors.New("-timelapse needs a url or command in the [timelapse] " +
+			"section of the config file")
+	}
+	return nil
+}
+
+// timelapseFrame takes the next frame of the job's timelapse, at a layer
+// change. If the [timelapse] section gives somewhere to park, the head is
+// moved out of the camera's way first, Octolapse style, and the job waits
+// for the frame before carrying on.
+func (d *dripper) timelapseFrame() {
+	if timelapse_dir == "" || d.job_name == "" {
+		return
+	}
+	if conf.get(d.section("timelapse", "park_x"), "park_x") == "" &&
+		conf.get(d.section("timelapse", "park_y"), "park_y") == "" {
+		d.takeFrame(nil)
+		return
+	}
+	d.snapping = true
+	d.holdPosition()
+	d.park("timelapse")
+	// Take the frame once the printer has got there.
+	d.hack_queue = append(d.hack_queue, "M400")
+}
+
+// snapped carries on with the job once a frame is taken, unless it was
+// paused meanwhile.
+func (d *dripper) snapped(err error) {
+	d.snapping = false
+	if err != nil {
+		d.con.Println("-- SNAPSHOT FAILED:", err)
+	}
+	if !d.paused {
+		d.returnToJob()
+	}
+}
+
+// takeFrame saves the next frame in the background: the [timelapse] url is
+// fetched, e.g. a webcam's snapshot, or else the command is run, with
+// {frame} replaced by the path to save the frame at and {layer} by the layer
+// number. If done isn't nil, it gets the outcome.
+func (d *dripper) takeFrame(done chan<- error) {
+	frame, err := d.nextFrame()
+	if err != nil {
+		d.con.Println("-- TIMELAPSE:", err)
+		if done != nil {
+			done <- err
+		}
+		return
+	}
+	url := conf.get("timelapse", "url")
+	cmd := strings.NewReplacer("{frame}", frame, "{layer}", strconv.Itoa(d.layer)).
+		Replace(conf.get("timelapse", "command"))
+	d.running.Add(1)
+	go func() {
+		defer d.running.Done()
+		var err error
+		if url != "" {
+			err = download(url, frame)
+		} else if out, cerr := shellCommand(cmd).CombinedOutput(); cerr != nil {
+			err = fmt.Errorf("%s: %v: %s", cmd, cerr, bytes.TrimSpace(out))
+		}
+		if done != nil {
+			done <- err
+		} else if err != nil {
+			d.con.Println("-- SNAPSHOT FAILED:", err)
+		}
+	}()
+}
+
+// nextFrame returns the path to save the job's next frame at, making the
+// job's directory for the first.
+func (d *dripper) nextFrame() (string, error) {
+	if d.frames_dir == "" {
+		name, _, _ := strings.Cut(filepath.Base(d.job_name), ".")
+		if name == "" || name == "-" {
+			name = "timelapse"
+		}
+		dir := filepath.Join(timelapse_dir,
+			name+"-"+time.Now().Format("20060102-150405"))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return "", err
+		}
+		d.frames_dir = dir
+		d.con.Println("-- TIMELAPSE", dir)
+	}
+	d.frames++
+	return filepath.Join(d.frames_dir, fmt.Sprintf("frame-%05d.jpg", d.frames)), nil
+}
+
+// download saves what is at a URL to a file.
+func download(url, path string) error {
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// renderTimelapse turns the frames of a job that has just finished into a
+// video next to their directory, in the background, with the [timelapse]
+// render command: {frames} is replaced by the frames' directory and {video}
+// by the path of the video. Nothing is rendered unless it is set.
+func (d *dripper) renderTimelapse() {
+	render := conf.get("timelapse", "render")
+	if render == "" || d.frames == 0 {
+		return
+	}
+	job, video := d.job_name, d.frames_dir+".mp4"
+	cmd := strings.NewReplacer("{frames}", d.frames_dir, "{video}", video).
+		Replace(render)
+	d.con.Println("-- RENDER TIMELAPSE", video)
+	d.running.Add(1)
+	go func() {
+		defer d.running.Done()
+		out, err := shellCommand(cmd).CombinedOutput()
+		if err != nil {
+			d.con.Printf("-- RENDER FAILED: %s: %v: %s\n", cmd, err,
+				bytes.TrimSpace(out))
+			return
+		}
+		log.Printf("Timelapse of %s: %s", job, video)
+	}()
+}
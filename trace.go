@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Set by -q, -v and -trace: how much of the traffic with the printer the
+// console shows. -q shows none of it, leaving the status line and messages;
+// -v shows all of it, even the polling and oks that are usually left out;
+// -trace also hex-dumps the bytes as they go over the wire.
+var (
+	terse   bool
+	verbose bool
+	trace   bool
+)
+
+// tracer hex-dumps what is read from and written to the printer's port, for
+// -trace: < before bytes read and > before bytes written.
+type tracer struct {
+	port io.ReadWriter
+	con  *console
+}
+
+func (t tracer) Read(p []byte) (int, error) {
+	n, err := t.port.Read(p)
+	if n > 0 {
+		t.dump("< ", p[:n])
+	}
+	return n, err
+}
+
+func (t tracer) Write(p []byte) (int, error) {
+	t.dump("> ", p)
+	return t.port.Write(p)
+}
+
+func (t tracer) dump(dir string, p []byte) {
+	dump := strings.TrimSuffix(hex.Dump(p), "\n")
+	t.con.Println(dir + strings.ReplaceAll(dump, "\n", "\n"+dir))
+}
+
+// Set by -dump-raw: the file the bytes to and from the printer are recorded
+// in, and the file itself once it is open.
+var (
+	dump_raw  string
+	dump_file *os.File
+)
+
+// checkDump starts the file -dump-raw records in.
+func checkDump() error {
+	if dump_raw == "" {
+		return nil
+	}
+	f, err := os.Create(dump_raw)
+	if err != nil {
+		return fmt.Errorf("-dump-raw: %w", err)
+	}
+	fmt.Fprintf(f, "# dripp3r raw dump, started %s\n",
+		time.Now().Format(time.RFC3339Nano))
+	dump_file = f
+	return nil
+}
+
+// dumper records the bytes read from and written to the printer's port in
+// the -dump-raw file, a line for each read or write: the seconds since the
+// dump started, < for bytes read or > for bytes written, and the bytes as a
+// quoted Go string, e.g.
+//
+//	0.031250 > "N1 G28*18\n"
+//	0.052083 < "ok\n"
+type dumper struct {
+	port  io.ReadWriter
+	mu    sync.Mutex
+	start time.Time
+}
+
+func newDumper(port io.ReadWriter) *dumper {
+	return &dumper{port: port, start: time.Now()}
+}
+
+func (d *dumper) Read(p []byte) (int, error) {
+	n, err := d.port.Read(p)
+	if n > 0 {
+		d.record('<', p[:n])
+	}
+	return n, err
+}
+
+func (d *dumper) Write(p []byte) (int, error) {
+	d.record('>', p)
+	return d.port.Write(p)
+}
+
+func (d *dumper) record(dir byte, p []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fmt.Fprintf(dump_file, "%.6f %c %s\n", time.Since(d.start).Seconds(), dir,
+		strconv.Quote(string(p)))
+}
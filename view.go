@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sessionView shows what a daemon streams to a monitor or attach session.
+type sessionView interface {
+	line(printer, s string)
+	temps(printer string, t temps)
+	status(printer string, st ctlStatus)
+	note(s string)
+}
+
+// plainView prints everything in order, prefixed with the printer name when
+// the daemon runs several. It can be limited to one printer.
+type plainView struct {
+	only string
+}
+
+func (v plainView) line(printer, s string) {
+	switch {
+	case v.only != "" && printer != v.only:
+	case printer != "":
+		fmt.Printf("[%s] %s\n", printer, s)
+	default:
+		fmt.Println(s)
+	}
+}
+
+func (v plainView) temps(printer string, t temps) {
+	v.line(printer, "-- "+t.String())
+}
+
+// status goes on the status line, and in the terminal's title.
+func (v plainView) status(printer string, st ctlStatus) {
+	if v.only != "" && printer != v.only {
+		return
+	}
+	if printer != "" {
+		setStatus("[" + printer + "] " + st.line())
+	} else {
+		setStatus(st.line())
+	}
+	setTitle(st.title(printer))
+}
+
+func (v plainView) note(s string) {
+	fmt.Println(s)
+}
+
+// paneView splits the terminal into one pane per printer, each showing a
+// header with the temperatures and the latest console lines.
+type paneView struct {
+	mu        sync.Mutex
+	only      string
+	names     []string
+	lines     map[string][]string
+	temp      map[string]temps
+	last_note string
+	dirty     bool
+}
+
+func newPaneView(only string) *paneView {
+	v := &paneView{
+		only:  only,
+		lines: make(map[string][]string),
+		temp:  make(map[string]temps),
+	}
+	go func() {
+		// Redraw at most ten times a second; output can be very fast.
+		for range time.Tick(100 * time.Millisecond) {
+			v.mu.Lock()
+			if v.dirty {
+				v.draw()
+				v.dirty = false
+			}
+			v.mu.Unlock()
+		}
+	}()
+	return v
+}
+
+func (v *paneView) pane(printer string) bool {
+	if v.only != "" && printer != v.only {
+		return false
+	}
+	if _, ok := v.lines[printer]; !ok {
+		v.names = append(v.names, printer)
+		v.lines[printer] = nil
+	}
+	v.dirty = true
+	return true
+}
+
+func (v *paneView) line(printer, s string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !v.pane(printer) {
+		return
+	}
+	lines := append(v.lines[printer], s)
+	// Keep only what could possibly fit on screen.
+	if len(lines) > 200 {
+		lines = lines[len(lines)-200:]
+	}
+	v.lines[printer] = lines
+}
+
+func (v *paneView) temps(printer string, t temps) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.pane(printer) {
+		v.temp[printer] = t
+	}
+}
+
+// status isn't shown: each pane's header has the temperatures.
+func (v *paneView) status(printer string, st ctlStatus) {}
+
+func (v *paneView) note(s string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.last_note = s
+	v.dirty = true
+}
+
+func (v *paneView) draw() {
+	cols, rows, err := termSize(os.Stdout)
+	if err != nil {
+		cols, rows = 80, 24
+	}
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	row := func(s string, header bool) {
+		if len(s) > cols {
+			s = s[:cols]
+		}
+		if header {
+			s = "\x1b[7m" + s + strings.Repeat(" ", cols-len(s)) + "\x1b[0m"
+		}
+		b.WriteString(s + "\x1b[K\n")
+	}
+
+	// The last row is kept for notes.
+	n := len(v.names)
+	for i, name := range v.names {
+		height := (rows - 1) / n
+		if i == n-1 {
+			height = rows - 1 - height*(n-1)
+		}
+		if height < 1 {
+			continue
+		}
+		title := name
+		if title == "" {
+			title = "printer"
+		}
+		row(fmt.Sprintf("== %s  %s", title, v.temp[name]), true)
+		lines := v.lines[name]
+		if len(lines) > height-1 {
+			lines = lines[len(lines)-(height-1):]
+		}
+		for j := 0; j < height-1; j++ {
+			if j < len(lines) {
+				row(lines[j], false)
+			} else {
+				row("", false)
+			}
+		}
+	}
+	b.WriteString(v.last_note + "\x1b[K")
+	fmt.Print(b.String())
+}
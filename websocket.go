@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// wsConn is the server's end of a WebSocket (RFC 6455), for the text
+// messages of the Moonraker API.
+type wsConn struct {
+	conn net.Conn
+	rd   *bufio.Reader
+	mu   sync.Mutex // held while writing a frame
+}
+
+const ws_guid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes.
+const (
+	ws_text  = 0x1
+	ws_close = 0x8
+	ws_ping  = 0x9
+	ws_pong  = 0xa
+)
+
+// The largest message taken from a client.
+const ws_max_message = 1 << 20
+
+// wsUpgrade takes over an HTTP request asking for a WebSocket. Browsers
+// open WebSockets from any page, so one from a page of another origin than
+// the server's own is refused, unless origins lists it, e.g.
+// "http://mainsail.local".
+func wsUpgrade(w http.ResponseWriter, r *http.Request, origins []string) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		http.Error(w, "expected a WebSocket", http.StatusBadRequest)
+		return nil, errors.New("not a WebSocket request")
+	}
+	if !wsOriginOK(r, origins) {
+		http.Error(w, "WebSocket from another origin", http.StatusForbidden)
+		return nil, errors.New("WebSocket from " + r.Header.Get("Origin"))
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "cannot take over the connection", http.StatusInternalServerError)
+		return nil, errors.New("cannot hijack the connection")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + ws_guid))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) +
+		"\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, rd: rw.Reader}, nil
+}
+
+// wsOriginOK reports whether a WebSocket request comes from the server's
+// own origin, or one of origins. Programs other than browsers send no
+// Origin.
+//
+// The server's own origin is that of the address the request came in on,
+// or a loopback one, never the Host header: any web site can have its name
+// turned into the server's address, and then its pages send that name.
+func wsOriginOK(r *http.Request, origins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, o := range origins {
+		if strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	local, ok := r.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	if port != strconv.Itoa(local.Port) {
+		return false
+	}
+	host := u.Hostname()
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.Equal(local.IP))
+}
+
+// read returns the next text message, answering pings on the way.
+func (c *wsConn) read() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case ws_ping:
+			if err := c.writeFrame(ws_pong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case ws_pong:
+			continue
+		case ws_close:
+			c.writeFrame(ws_close, nil)
+			return nil, io.EOF
+		}
+		msg = append(msg, payload...)
+		if len(msg) > ws_max_message {
+			return nil, errors.New("WebSocket message too long")
+		}
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.rd, head[:]); err != nil {
+		return
+	}
+	fin, op = head[0]&0x80 != 0, head[0]&0x0f
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err = io.ReadFull(c.rd, b[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err = io.ReadFull(c.rd, b[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	if n > ws_max_message {
+		err = errors.New("WebSocket frame too long")
+		return
+	}
+	// Clients always mask what they send.
+	var mask [4]byte
+	if head[1]&0x80 != 0 {
+		if _, err = io.ReadFull(c.rd, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.rd, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// write sends a text message.
+func (c *wsConn) write(msg []byte) error {
+	return c.writeFrame(ws_text, msg)
+}
+
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	head := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		head = append(head, byte(n))
+	case n <= 0xffff:
+		head = append(head, 126, 0, 0)
+		binary.BigEndian.PutUint16(head[2:], uint16(n))
+	default:
+		head = append(head, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(head[2:], uint64(n))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.conn.Write(append(head, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
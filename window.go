@@ -0,0 +1,83 @@
+package main
+
+import "time"
+
+// window is how many lines are sent ahead of their answers, on firmware that
+// answers each line rather than counting characters like Grbl. It starts at
+// one line, grows while lines are answered cleanly and shrinks when the
+// printer asks for lines again, so a flaky link soon goes back to one line
+// at a time.
+type window struct {
+	size     int           // most lines in flight, as far as the link goes
+	most     int           // the most it grows to
+	clean    int           // lines answered since it last grew or shrank
+	advanced bool          // the printer reports its free buffer slots
+	free     int           // free slots in the last report
+	latency  time.Duration // average time to answer a line sent alone
+}
+
+// How many lines must be answered in a row for the window to grow by one.
+const window_grow = 32
+
+// The most the window grows to, unless [firmware] bufsize says how many
+// lines the printer's command buffer holds.
+const max_window = 16
+
+// The most lines in flight when the printer doesn't say how much room it
+// has. Marlin's receive buffer holds a second line of any length while it
+// works on the first.
+const blind_window = 2
+
+// Answers slower than this on average, e.g. over a network serial bridge,
+// make it worth sending a line ahead when the printer doesn't say how much
+// room it has.
+const slow_link = 10 * time.Millisecond
+
+// limit returns how many lines may be in flight.
+func (w *window) limit() int {
+	n := w.size
+	switch {
+	case w.advanced:
+		if w.free < n {
+			n = w.free
+		}
+	case w.latency > slow_link:
+		if blind_window < n {
+			n = blind_window
+		}
+	default:
+		n = 1
+	}
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// answered counts a line answered cleanly, which took took to be answered
+// if it was the only one in flight.
+func (w *window) answered(alone bool, took time.Duration) {
+	if alone {
+		w.latency += (took - w.latency) / 8
+	}
+	w.clean++
+	if w.clean >= window_grow && w.size < w.most {
+		w.size++
+		w.clean = 0
+	}
+}
+
+// resent halves the window when the printer asks for a line again.
+func (w *window) resent() {
+	w.size /= 2
+	if w.size < 1 {
+		w.size = 1
+	}
+	w.clean = 0
+}
+
+// report takes in the free slots the printer reported.
+func (w *window) report(free int) {
+	w.advanced = true
+	w.free = free
+}
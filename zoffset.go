@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Matches the probe's Z offset in replies to M851 from different Marlin
+// versions: "Probe Z Offset: -1.50", "Probe Offset X-43 Y-10 Z-1.50" or
+// "M851 X-43.00 Y-10.00 Z-1.50".
+var zoffset_re = regexp.MustCompile(
+	`Probe Z Offset:\s*(-?[\d.]+)|(?:Probe Offset|M851)\b[^Z]*Z\s*(-?[\d.]+)`)
+
+// Z offset steps for the arrow keys and PgUp/PgDn, in mm.
+const (
+	zoffset_fine   = 0.01
+	zoffset_coarse = 0.1
+)
+
+// startZOffset homes and puts the nozzle where the probe thinks Z0 is, so
+// the gap can be closed with the keys until a sheet of paper just drags.
+// The distance moved is added to the probe's Z offset (M851).
+func (d *dripper) startZOffset() (string, error) {
+	if d.gcode != nil && !d.paused {
+		return "", errors.New("pause the job before setting the Z offset")
+	}
+	d.zoffsetting = true
+	d.zoffset_moved = 0
+	d.zoffset_saved = true
+	// Soft endstops would keep the nozzle from going below Z0.
+	d.hack_queue = append(d.hack_queue, "M851", "G28", "M211 S0", "G1 Z0 F600")
+	d.con.Println("-- Z OFFSET: Up/Down move the nozzle 0.01mm, PgUp/PgDn " +
+		"0.1mm, until a sheet of paper just drags. s saves, q leaves.")
+	return "setting the Z offset", nil
+}
+
+// zoffsetKey handles a key while setting the Z offset.
+func (d *dripper) zoffsetKey(k string) (string, error) {
+	var z float64
+	switch k {
+	case "up":
+		z = zoffset_fine
+	case "down":
+		z = -zoffset_fine
+	case "pgup":
+		z = zoffset_coarse
+	case "pgdn":
+		z = -zoffset_coarse
+	case "s":
+		if !d.zoffset_known {
+			return "", errors.New("the printer has not reported its Z offset (M851)")
+		}
+		d.zoffset += d.zoffset_moved
+		d.zoffset_moved = 0
+		d.zoffset_saved = true
+		d.hack_queue = append(d.hack_queue,
+			fmt.Sprintf("M851 Z%.2f", d.zoffset), "M500")
+		d.con.Printf("-- Z OFFSET SAVED %.2f\n", d.zoffset)
+		return fmt.Sprintf("saved %.2f", d.zoffset), nil
+	case "q", "esc":
+		if !d.zoffset_saved {
+			d.zoffset_saved = true
+			d.con.Println("-- Z OFFSET NOT SAVED: Press s to save it, or q " +
+				"again to leave without.")
+			return "not saved", nil
+		}
+		d.zoffsetting = false
+		d.hack_queue = append(d.hack_queue, "M211 S1")
+		d.moveBy("G1 Z5 F600")
+		d.con.Println("-- END Z OFFSET")
+		return "done setting the Z offset", nil
+	default:
+		return "", fmt.Errorf("%w %q setting the Z offset", errNoHotkey, k)
+	}
+	d.zoffset_moved += z
+	d.zoffset_saved = false
+	d.moveBy("G1 Z" + gnum(z) + " F600")
+	if d.zoffset_known {
+		d.con.Printf("-- Z OFFSET %.2f (moved %+.2f)\n",
+			d.zoffset+d.zoffset_moved, d.zoffset_moved)
+	} else {
+		d.con.Printf("-- Z OFFSET moved %+.2f\n", d.zoffset_moved)
+	}
+	return fmt.Sprintf("moved %+.2f", d.zoffset_moved), nil
+}
+
+// trackZOffset picks up the probe's Z offset from a response.
+func (d *dripper) trackZOffset(ln string) {
+	m := zoffset_re.FindStringSubmatch(ln)
+	if m == nil {
+		return
+	}
+	s := m[1] + m[2]
+	if z, err := strconv.ParseFloat(s, 64); err == nil {
+		d.zoffset, d.zoffset_known = z, true
+	}
+}